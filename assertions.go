@@ -0,0 +1,154 @@
+package testdeck
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"regexp"
+
+	"github.com/mercari/testdeck/httputils"
+)
+
+/*
+assertions.go: Assertions on JSON response bodies (e.g. the body returned by httputils.SendHTTPRequest)
+
+The assertions mark the test case as failed with Errorf and let it continue, and return true if they passed
+Paths use the syntax of httputils.GetJSONValue (e.g. data.items[0].id)
+*/
+
+// AssertJSONEquals checks that the value at the path of the JSON body is equal to expected
+// expected is compared as JSON, so numbers of any type, slices, maps and structs with json tags can be used
+// Numbers are compared exactly (e.g. 64-bit IDs are not rounded to a float64), so 2 is equal to 2.0 but 9007199254740993 is not equal to 9007199254740992
+func (c *TD) AssertJSONEquals(body *bytes.Buffer, path string, expected interface{}) bool {
+	c.T.Helper()
+	actual, err := jsonPathValue(body, path)
+	if err != nil {
+		c.Errorf("AssertJSONEquals: %s", err.Error())
+		return false
+	}
+
+	b, err := json.Marshal(expected)
+	if err != nil {
+		c.Errorf("AssertJSONEquals: expected value cannot be converted to JSON: %s", err.Error())
+		return false
+	}
+	want, _ := httputils.DecodeJSON(bytes.NewBuffer(b))
+
+	if !jsonEqual(want, actual) {
+		c.Errorf("AssertJSONEquals: %s is %s, expected %s", path, toJSON(actual), string(b))
+		return false
+	}
+	return true
+}
+
+// AssertJSONExists checks that the JSON body has a value (which can be null) at the path
+func (c *TD) AssertJSONExists(body *bytes.Buffer, path string) bool {
+	c.T.Helper()
+	if _, err := httputils.GetJSONValue(path, body); err != nil {
+		c.Errorf("AssertJSONExists: %s", err.Error())
+		return false
+	}
+	return true
+}
+
+// AssertJSONMatches checks that the value at the path of the JSON body matches the regular expression
+// Values that are not strings are matched in their JSON form (e.g. 12 or true)
+func (c *TD) AssertJSONMatches(body *bytes.Buffer, path string, pattern string) bool {
+	c.T.Helper()
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		c.Errorf("AssertJSONMatches: invalid pattern: %s", err.Error())
+		return false
+	}
+	value, err := jsonPathValue(body, path)
+	if err != nil {
+		c.Errorf("AssertJSONMatches: %s", err.Error())
+		return false
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		s = toJSON(value)
+	}
+	if !re.MatchString(s) {
+		c.Errorf("AssertJSONMatches: %s is %q, which does not match %s", path, s, pattern)
+		return false
+	}
+	return true
+}
+
+// AssertJSONSchema checks that the JSON body matches the JSON Schema (see httputils.ValidateJSONSchema for the supported keywords)
+func (c *TD) AssertJSONSchema(body *bytes.Buffer, schema string) bool {
+	c.T.Helper()
+	if err := httputils.ValidateJSONSchema([]byte(schema), body); err != nil {
+		c.Errorf("AssertJSONSchema: %s", err.Error())
+		return false
+	}
+	return true
+}
+
+// Returns the value at the path of the JSON body, with numbers as json.Number so that they are exact
+func jsonPathValue(body *bytes.Buffer, path string) (interface{}, error) {
+	decoded, err := httputils.DecodeJSON(body)
+	if err != nil {
+		return nil, err
+	}
+	return httputils.JSONPathValue(decoded, path)
+}
+
+// Returns true if the decoded JSON values are equal, comparing numbers by their exact value
+func jsonEqual(a, b interface{}) bool {
+	switch a := a.(type) {
+	case map[string]interface{}:
+		m, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(m) {
+			return false
+		}
+		for k, v := range a {
+			if w, ok := m[k]; !ok || !jsonEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		l, ok := b.([]interface{})
+		if !ok || len(a) != len(l) {
+			return false
+		}
+		for i := range a {
+			if !jsonEqual(a[i], l[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	x, aIsNumber := jsonNumber(a)
+	y, bIsNumber := jsonNumber(b)
+	if aIsNumber || bIsNumber {
+		return aIsNumber && bIsNumber && x.Cmp(y) == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// Returns the exact value of a decoded JSON number (a json.Number, or a float64 for the length of an array)
+func jsonNumber(v interface{}) (*big.Rat, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		return new(big.Rat).SetString(n.String())
+	case float64:
+		return new(big.Rat).SetFloat64(n), true
+	}
+	return nil, false
+}
+
+// Returns the value in JSON for failure messages
+func toJSON(value interface{}) string {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(b)
+}
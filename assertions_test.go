@@ -0,0 +1,66 @@
+package testdeck
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const assertionsJSON = `{"data": {"id": "user-1", "count": 2, "items": [{"id": "a"}, {"id": "b"}], "deleted": null, "big": 9007199254740993, "ratio": 2.0}}`
+
+func Test_TD_JSONAssertions_ShouldPass(t *testing.T) {
+	// Arrange
+	mock := newMockT()
+	td := TD{T: mock}
+	body := bytes.NewBufferString(assertionsJSON)
+
+	// Act
+	results := []bool{
+		td.AssertJSONEquals(body, "data.count", 2),
+		td.AssertJSONEquals(body, "data.items[1]", map[string]string{"id": "b"}),
+		td.AssertJSONEquals(body, "data.deleted", nil),
+		td.AssertJSONEquals(body, "data.big", int64(9007199254740993)),
+		td.AssertJSONEquals(body, "data.ratio", 2),
+		td.AssertJSONEquals(body, "data.items.#", 2),
+		td.AssertJSONMatches(body, "data.big", `^9007199254740993$`),
+		td.AssertJSONExists(body, "data.items[0].id"),
+		td.AssertJSONMatches(body, "data.id", `^user-\d+$`),
+		td.AssertJSONMatches(body, "data.count", `^\d$`),
+		td.AssertJSONSchema(body, `{"type": "object", "required": ["data"]}`),
+	}
+
+	// Assert
+	for i, result := range results {
+		assert.True(t, result, i)
+	}
+	assert.Equal(t, 0, mock.callCount.get("Errorf"))
+	assert.Empty(t, td.statuses)
+}
+
+func Test_TD_JSONAssertions_ShouldFailTestCase(t *testing.T) {
+	// Arrange
+	mock := newMockT()
+	td := TD{T: mock}
+	body := bytes.NewBufferString(assertionsJSON)
+
+	// Act
+	results := []bool{
+		td.AssertJSONEquals(body, "data.count", 3),
+		td.AssertJSONEquals(body, "data.missing", 3),
+		td.AssertJSONEquals(body, "data.big", int64(9007199254740992)),
+		td.AssertJSONEquals(body, "data.count", "2"),
+		td.AssertJSONExists(body, "data.items[2]"),
+		td.AssertJSONMatches(body, "data.id", `^admin$`),
+		td.AssertJSONMatches(body, "data.id", `(`),
+		td.AssertJSONSchema(body, `{"properties": {"data": {"properties": {"id": {"type": "integer"}}}}}`),
+	}
+
+	// Assert
+	for i, result := range results {
+		assert.False(t, result, i)
+	}
+	assert.Equal(t, len(results), mock.callCount.get("Errorf"))
+	assert.Len(t, td.statuses, len(results))
+	assert.False(t, td.fatal)
+}
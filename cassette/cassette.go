@@ -0,0 +1,161 @@
+package cassette
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/mercari/testdeck/runner"
+	"github.com/mercari/testdeck/service/config"
+	"google.golang.org/grpc"
+)
+
+/*
+cassette.go: Records the gRPC and HTTP exchanges made through grpcutils and httputils to a cassette file, and replays them so tests can run without the real services
+*/
+
+// Mode is whether a cassette records or replays exchanges
+type Mode string
+
+const (
+	ModeRecord Mode = "record" // exchanges are sent to the real services and written to the cassette file
+	ModeReplay Mode = "replay" // exchanges are served from the cassette file
+)
+
+// Cassette contains the recorded exchanges of a test run
+type Cassette struct {
+	path   string
+	mode   Mode
+	mu     sync.Mutex
+	data   file
+	used   map[interface{}]bool // interactions that have been replayed
+	server *grpc.Server         // the stand-in server that replays gRPC exchanges
+	addr   string               // the address of the stand-in server
+}
+
+// The format of cassette files
+type file struct {
+	GRPC []*GRPCInteraction `json:"grpc"`
+	HTTP []*HTTPInteraction `json:"http"`
+}
+
+var (
+	currentMu sync.Mutex
+	current   *Cassette
+)
+
+// Returns the cassette that is in use, or nil if exchanges are not recorded or replayed
+func Current() *Cassette {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+	return current
+}
+
+// Starts recording to or replaying from the cassette file, and makes it the current cassette
+// In replay mode a local stand-in server is started, and grpcutils connects to it instead of the real services
+// If the testdeck runner is running, the cassette is stopped when the test run finishes
+func Start(path string, mode Mode) (*Cassette, error) {
+	c := &Cassette{
+		path: path,
+		mode: mode,
+		used: map[interface{}]bool{},
+	}
+
+	switch mode {
+	case ModeRecord:
+	case ModeReplay:
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cassette: %s", err.Error())
+		}
+		if err := json.Unmarshal(data, &c.data); err != nil {
+			return nil, fmt.Errorf("invalid cassette %s: %s", path, err.Error())
+		}
+		if err := c.startServer(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown cassette mode %q", mode)
+	}
+
+	currentMu.Lock()
+	current = c
+	currentMu.Unlock()
+
+	if runner.Initialized() {
+		runner.Instance(nil).Defer(func() { c.Stop() })
+	}
+	return c, nil
+}
+
+// Starts the cassette declared by the CASSETTE_MODE and CASSETTE_FILE environment variables
+// Returns nil if CASSETTE_MODE is not set, so tests call the real services
+func StartFromEnv(env *config.Env) (*Cassette, error) {
+	if env.CassetteMode == "" {
+		return nil, nil
+	}
+	return Start(env.CassetteFile, Mode(env.CassetteMode))
+}
+
+// Stops the cassette: in record mode the exchanges are written to the cassette file, in replay mode the stand-in server is stopped
+// Streams that are still open are saved with the messages exchanged so far
+func (c *Cassette) Stop() error {
+	currentMu.Lock()
+	if current == c {
+		current = nil
+	}
+	currentMu.Unlock()
+
+	if c.mode == ModeReplay {
+		if c.server != nil {
+			c.server.Stop()
+		}
+		return nil
+	}
+
+	return c.Save()
+}
+
+// Writes the recorded exchanges to the cassette file
+func (c *Cassette) Save() error {
+	c.mu.Lock()
+	data, err := json.MarshalIndent(c.data, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(c.path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(c.path, data, 0644)
+}
+
+// Returns the mode of the cassette
+func (c *Cassette) Mode() Mode {
+	return c.mode
+}
+
+// Returns the address of the stand-in server that replays gRPC exchanges (empty in record mode)
+func (c *Cassette) Addr() string {
+	return c.addr
+}
+
+// Returns the recorded gRPC exchanges
+func (c *Cassette) GRPCInteractions() []*GRPCInteraction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*GRPCInteraction(nil), c.data.GRPC...)
+}
+
+// Returns the recorded HTTP exchanges
+func (c *Cassette) HTTPInteractions() []*HTTPInteraction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*HTTPInteraction(nil), c.data.HTTP...)
+}
@@ -0,0 +1,197 @@
+package cassette
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type testServer struct {
+	testpb.UnimplementedTestServiceServer
+	calls int
+}
+
+func (s *testServer) UnaryCall(ctx context.Context, req *testpb.SimpleRequest) (*testpb.SimpleResponse, error) {
+	s.calls++
+	grpc.SetHeader(ctx, metadata.Pairs("x-request-id", fmt.Sprint(s.calls)))
+	if req.ResponseSize < 0 {
+		st, _ := status.New(codes.InvalidArgument, "negative size").WithDetails(&testpb.Payload{Body: []byte("size")})
+		return nil, st.Err()
+	}
+	return &testpb.SimpleResponse{Username: fmt.Sprintf("user%d", req.ResponseSize)}, nil
+}
+
+func (s *testServer) FullDuplexCall(stream testpb.TestService_FullDuplexCallServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		body := strings.ToUpper(string(req.GetPayload().GetBody()))
+		if err := stream.Send(&testpb.StreamingOutputCallResponse{Payload: &testpb.Payload{Body: []byte(body)}}); err != nil {
+			return err
+		}
+	}
+}
+
+// starts the test service and returns its address and a function that stops it
+func startTestServer(t *testing.T) (string, func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	s := grpc.NewServer()
+	testpb.RegisterTestServiceServer(s, &testServer{})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return lis.Addr().String(), s.Stop
+}
+
+func dial(t *testing.T, target string, opts ...grpc.DialOption) testpb.TestServiceClient {
+	conn, err := grpc.Dial(target, append(opts, grpc.WithInsecure())...)
+	require.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return testpb.NewTestServiceClient(conn)
+}
+
+// makes the same calls in record and replay mode
+func callTestService(t *testing.T, client testpb.TestServiceClient) (names []string, header metadata.MD, failed error, chat []string) {
+	ctx := context.Background()
+	for _, size := range []int32{2, 1, 2} {
+		resp, err := client.UnaryCall(ctx, &testpb.SimpleRequest{ResponseSize: size}, grpc.Header(&header))
+		require.Nil(t, err)
+		names = append(names, resp.Username)
+	}
+
+	_, failed = client.UnaryCall(ctx, &testpb.SimpleRequest{ResponseSize: -1})
+
+	stream, err := client.FullDuplexCall(ctx)
+	require.Nil(t, err)
+	for _, body := range []string{"a", "b"} {
+		require.Nil(t, stream.Send(&testpb.StreamingOutputCallRequest{Payload: &testpb.Payload{Body: []byte(body)}}))
+		resp, err := stream.Recv()
+		require.Nil(t, err)
+		chat = append(chat, string(resp.Payload.Body))
+	}
+	require.Nil(t, stream.CloseSend())
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+	return
+}
+
+func Test_Cassette_ShouldReplayRecordedGRPCCalls(t *testing.T) {
+	// Arrange
+	path := filepath.Join(t.TempDir(), "testdata", "grpc.json")
+	target, stop := startTestServer(t)
+
+	recording, err := Start(path, ModeRecord)
+	require.Nil(t, err)
+	assert.Equal(t, recording, Current())
+	names, header, failed, chat := callTestService(t, dial(t, target, recording.DialOptions()...))
+	require.Nil(t, recording.Stop())
+	stop()
+
+	// Act
+	replaying, err := Start(path, ModeReplay)
+	require.Nil(t, err)
+	defer replaying.Stop()
+	client := dial(t, replaying.Addr(), replaying.DialOptions()...)
+	replayedNames, replayedHeader, replayedFailed, replayedChat := callTestService(t, client)
+	unknown, err := client.StreamingOutputCall(context.Background(), &testpb.StreamingOutputCallRequest{})
+	require.Nil(t, err)
+	_, unknownErr := unknown.Recv()
+
+	// Assert
+	assert.Len(t, recording.GRPCInteractions(), 5)
+	assert.Equal(t, []string{"user2", "user1", "user2"}, names)
+	assert.Equal(t, names, replayedNames)
+	assert.Equal(t, []string{"3"}, header.Get("x-request-id"))
+	assert.Equal(t, header.Get("x-request-id"), replayedHeader.Get("x-request-id"))
+	assert.Equal(t, []string{"A", "B"}, replayedChat)
+	assert.Equal(t, chat, replayedChat)
+
+	st := status.Convert(replayedFailed)
+	assert.Equal(t, status.Convert(failed).Proto().String(), st.Proto().String())
+	require.Len(t, st.Details(), 1)
+	assert.Equal(t, []byte("size"), st.Details()[0].(*testpb.Payload).Body)
+
+	// the stream was not recorded
+	assert.Equal(t, codes.Unimplemented, status.Code(unknownErr))
+}
+
+func Test_Cassette_ShouldReplayRecordedHTTPRequests(t *testing.T) {
+	// Arrange
+	path := filepath.Join(t.TempDir(), "http.json")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "%s %s", r.URL.Path, body)
+	}))
+	client := &http.Client{Transport: WrapTransport(nil)}
+	send := func(method, body string) (*http.Response, string, error) {
+		req, _ := http.NewRequest(method, server.URL+"/items", strings.NewReader(body))
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, "", err
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return resp, string(b), nil
+	}
+
+	recording, err := Start(path, ModeRecord)
+	require.Nil(t, err)
+	_, _, err = send(http.MethodPost, "a")
+	require.Nil(t, err)
+	_, _, err = send(http.MethodPost, "b")
+	require.Nil(t, err)
+	require.Nil(t, recording.Stop())
+	server.Close()
+
+	// Act
+	replaying, err := Start(path, ModeReplay)
+	require.Nil(t, err)
+	defer replaying.Stop()
+	respB, bodyB, errB := send(http.MethodPost, "b")
+	_, bodyA, errA := send(http.MethodPost, "a")
+	_, _, unknownErr := send(http.MethodDelete, "")
+
+	// Assert
+	require.Nil(t, errB)
+	require.Nil(t, errA)
+	assert.Equal(t, http.StatusCreated, respB.StatusCode)
+	assert.Equal(t, http.MethodPost, respB.Header.Get("X-Method"))
+	assert.Equal(t, "/items b", bodyB)
+	assert.Equal(t, "/items a", bodyA)
+	assert.NotNil(t, unknownErr)
+}
+
+func Test_Start_ShouldFailWithUnknownMode(t *testing.T) {
+	_, err := Start(filepath.Join(t.TempDir(), "cassette.json"), Mode("rewind"))
+
+	assert.NotNil(t, err)
+	assert.Nil(t, Current())
+}
+
+func Test_Start_ShouldFailWithMissingCassette(t *testing.T) {
+	_, err := Start(filepath.Join(t.TempDir(), "missing.json"), ModeReplay)
+
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,324 @@
+package cassette
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	grpcproto "google.golang.org/grpc/encoding/proto"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+/*
+grpc.go: Client interceptors that record gRPC calls, and the stand-in server that replays them
+*/
+
+// Directions of the messages of a gRPC call, from the point of view of the client
+const (
+	DirectionSend = "send"
+	DirectionRecv = "recv"
+)
+
+// GRPCInteraction is a recorded gRPC call
+type GRPCInteraction struct {
+	Method  string              `json:"method"`
+	Events  []Event             `json:"events"`            // the messages in the order the client sent and received them
+	Code    codes.Code          `json:"code"`              // the status code of the call
+	Message string              `json:"message,omitempty"` // the status message of the call
+	Status  []byte              `json:"status,omitempty"`  // the full status in wire format, only saved when it has details
+	Header  map[string][]string `json:"header,omitempty"`
+	Trailer map[string][]string `json:"trailer,omitempty"`
+}
+
+// Event is a message sent or received by the client, in wire format
+type Event struct {
+	Direction string `json:"direction"`
+	Message   []byte `json:"message"`
+}
+
+// Returns the first message sent by the client, or nil if it sent none
+func (i *GRPCInteraction) firstSent() []byte {
+	for _, e := range i.Events {
+		if e.Direction == DirectionSend {
+			return e.Message
+		}
+	}
+	return nil
+}
+
+// Returns the recorded status of the call
+func (i *GRPCInteraction) status() *status.Status {
+	if len(i.Status) > 0 {
+		var st spb.Status
+		if err := proto.Unmarshal(i.Status, &st); err == nil {
+			return status.FromProto(&st)
+		}
+	}
+	return status.New(i.Code, i.Message)
+}
+
+// Saves the status of the error
+func (i *GRPCInteraction) setStatus(err error) {
+	st := status.Convert(err)
+	i.Code = st.Code()
+	i.Message = st.Message()
+	if len(st.Details()) > 0 {
+		i.Status, _ = proto.Marshal(st.Proto())
+	}
+}
+
+// The codec used by gRPC clients to marshal messages, so that the recorded messages are the same as the ones sent
+var wireCodec = encoding.GetCodec(grpcproto.Name)
+
+// ----------
+// recording
+// ----------
+
+// Returns the dial options that record the calls of the connection, or nil if the cassette is not recording
+// grpcutils.Dial installs them already, use this when dialing connections yourself (in replay mode, dial Addr() instead)
+func (c *Cassette) DialOptions() []grpc.DialOption {
+	if c == nil || c.mode != ModeRecord {
+		return nil
+	}
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(c.unaryInterceptor()),
+		grpc.WithChainStreamInterceptor(c.streamInterceptor()),
+	}
+}
+
+// Adds a call in the order it started
+func (c *Cassette) addGRPC(in *GRPCInteraction) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data.GRPC = append(c.data.GRPC, in)
+}
+
+// Updates a call that was added already
+func (c *Cassette) update(f func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f()
+}
+
+func (c *Cassette) unaryInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		in := &GRPCInteraction{Method: method}
+		c.addGRPC(in)
+
+		var header, trailer metadata.MD
+		opts = append(opts, grpc.Header(&header), grpc.Trailer(&trailer))
+		err := invoker(ctx, method, req, reply, cc, opts...)
+
+		c.update(func() {
+			if b, merr := wireCodec.Marshal(req); merr == nil {
+				in.Events = append(in.Events, Event{Direction: DirectionSend, Message: b})
+			}
+			if err == nil {
+				if b, merr := wireCodec.Marshal(reply); merr == nil {
+					in.Events = append(in.Events, Event{Direction: DirectionRecv, Message: b})
+				}
+			}
+			in.setStatus(err)
+			in.Header = header
+			in.Trailer = trailer
+		})
+		return err
+	}
+}
+
+func (c *Cassette) streamInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		in := &GRPCInteraction{Method: method}
+		c.addGRPC(in)
+
+		s, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			c.update(func() { in.setStatus(err) })
+			return nil, err
+		}
+		return &recordedStream{ClientStream: s, cassette: c, interaction: in, serverStreams: desc.ServerStreams}, nil
+	}
+}
+
+// A stream that records the messages sent and received
+type recordedStream struct {
+	grpc.ClientStream
+	cassette      *Cassette
+	interaction   *GRPCInteraction
+	serverStreams bool
+	finished      bool
+}
+
+func (s *recordedStream) SendMsg(m interface{}) error {
+	if b, err := wireCodec.Marshal(m); err == nil {
+		s.cassette.update(func() {
+			s.interaction.Events = append(s.interaction.Events, Event{Direction: DirectionSend, Message: b})
+		})
+	}
+	return s.ClientStream.SendMsg(m)
+}
+
+func (s *recordedStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == io.EOF {
+		s.finish(nil)
+		return err
+	}
+	if err != nil {
+		s.finish(err)
+		return err
+	}
+
+	if b, merr := wireCodec.Marshal(m); merr == nil {
+		s.cassette.update(func() {
+			s.interaction.Events = append(s.interaction.Events, Event{Direction: DirectionRecv, Message: b})
+		})
+	}
+	// client-streaming calls receive a single response, after which the stream is finished
+	if !s.serverStreams {
+		s.finish(nil)
+	}
+	return nil
+}
+
+// Saves the status, header and trailer of the stream once
+func (s *recordedStream) finish(err error) {
+	if s.finished {
+		return
+	}
+	s.finished = true
+
+	header, _ := s.ClientStream.Header()
+	trailer := s.ClientStream.Trailer()
+	s.cassette.update(func() {
+		s.interaction.setStatus(err)
+		s.interaction.Header = header
+		s.interaction.Trailer = trailer
+	})
+}
+
+// ----------
+// replaying
+// ----------
+
+// Starts the stand-in server that replays the recorded calls on a local port
+func (c *Cassette) startServer() error {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("failed to start the replay server: %s", err.Error())
+	}
+	c.server = grpc.NewServer(grpc.UnknownServiceHandler(c.replay), grpc.ForceServerCodec(rawCodec{}))
+	c.addr = lis.Addr().String()
+	go c.server.Serve(lis)
+	return nil
+}
+
+// Replays the recorded call that matches the method and the first message sent by the client
+func (c *Cassette) replay(_ interface{}, stream grpc.ServerStream) error {
+	method, _ := grpc.MethodFromServerStream(stream)
+
+	// the first message is used to find the call, it is nil if the client closed the stream without sending any
+	var first []byte
+	if err := stream.RecvMsg(&first); err != nil && err != io.EOF {
+		return err
+	}
+
+	in := c.matchGRPC(method, first)
+	if in == nil {
+		return status.Errorf(codes.Unimplemented, "the cassette has no recorded call of %s", method)
+	}
+
+	if len(in.Header) > 0 {
+		if err := stream.SetHeader(in.Header); err != nil {
+			return err
+		}
+	}
+
+	firstConsumed := false
+	for _, e := range in.Events {
+		switch e.Direction {
+		case DirectionSend:
+			if !firstConsumed {
+				firstConsumed = true
+				continue
+			}
+			// the messages are only read to keep the order of the call, they are not checked
+			var msg []byte
+			if err := stream.RecvMsg(&msg); err != nil && err != io.EOF {
+				return err
+			}
+		case DirectionRecv:
+			if err := stream.SendMsg(e.Message); err != nil {
+				return err
+			}
+		}
+	}
+
+	stream.SetTrailer(in.Trailer)
+	return in.status().Err()
+}
+
+// Returns the call to replay for the method and first message
+// Calls are replayed in the order they were recorded: an unused call with the same first message is preferred,
+// then any unused call of the method; when all were replayed, the calls with the same first message are reused
+func (c *Cassette) matchGRPC(method string, first []byte) *GRPCInteraction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var sameMethod, reused *GRPCInteraction
+	for _, in := range c.data.GRPC {
+		if in.Method != method {
+			continue
+		}
+		same := bytes.Equal(in.firstSent(), first)
+		if !c.used[in] {
+			if same {
+				c.used[in] = true
+				return in
+			}
+			if sameMethod == nil {
+				sameMethod = in
+			}
+		} else if same && reused == nil {
+			reused = in
+		}
+	}
+
+	if sameMethod != nil {
+		c.used[sameMethod] = true
+		return sameMethod
+	}
+	return reused
+}
+
+// A codec that passes the messages through without decoding them
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("cassette: cannot marshal %T", v)
+	}
+	return b, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("cassette: cannot unmarshal into %T", v)
+	}
+	*b = append([]byte(nil), data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return grpcproto.Name
+}
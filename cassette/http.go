@@ -0,0 +1,170 @@
+package cassette
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"unicode/utf8"
+)
+
+/*
+http.go: An HTTP transport that records requests and responses, or serves the responses from the cassette
+*/
+
+// HTTPInteraction is a recorded HTTP request and its response
+type HTTPInteraction struct {
+	Method       string              `json:"method"`
+	URL          string              `json:"url"`
+	RequestBody  string              `json:"requestBody,omitempty"`
+	StatusCode   int                 `json:"statusCode"`
+	Header       map[string][]string `json:"header,omitempty"` // the headers of the response
+	ResponseBody string              `json:"responseBody,omitempty"`
+	Base64       bool                `json:"base64,omitempty"` // the bodies are base64 encoded because they are not text
+}
+
+// Returns the bodies of the request and response
+func (i *HTTPInteraction) bodies() ([]byte, []byte) {
+	if !i.Base64 {
+		return []byte(i.RequestBody), []byte(i.ResponseBody)
+	}
+	req, _ := base64.StdEncoding.DecodeString(i.RequestBody)
+	resp, _ := base64.StdEncoding.DecodeString(i.ResponseBody)
+	return req, resp
+}
+
+// Saves the bodies of the request and response, base64 encoded if either is not text
+func (i *HTTPInteraction) setBodies(req, resp []byte) {
+	i.Base64 = !utf8.Valid(req) || !utf8.Valid(resp)
+	if i.Base64 {
+		i.RequestBody = base64.StdEncoding.EncodeToString(req)
+		i.ResponseBody = base64.StdEncoding.EncodeToString(resp)
+		return
+	}
+	i.RequestBody = string(req)
+	i.ResponseBody = string(resp)
+}
+
+// Returns a transport that records or replays the requests sent through it while a cassette is in use,
+// and sends them with base (http.DefaultTransport if nil) otherwise
+// httputils.Client (and so SendHTTPRequest) uses it already, use this for your own HTTP clients
+func WrapTransport(base http.RoundTripper) http.RoundTripper {
+	return &transport{base: base}
+}
+
+type transport struct {
+	base http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	c := Current()
+	if c == nil {
+		return base.RoundTrip(req)
+	}
+
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	if c.mode == ModeReplay {
+		return c.replayHTTP(req, body)
+	}
+
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	in := &HTTPInteraction{
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header.Clone(),
+	}
+	in.setBodies(body, respBody)
+	c.mu.Lock()
+	c.data.HTTP = append(c.data.HTTP, in)
+	c.mu.Unlock()
+	return resp, nil
+}
+
+// Returns the recorded response of the request
+func (c *Cassette) replayHTTP(req *http.Request, body []byte) (*http.Response, error) {
+	in := c.matchHTTP(req.Method, req.URL.String(), body)
+	if in == nil {
+		return nil, fmt.Errorf("the cassette has no recorded request %s %s", req.Method, req.URL.String())
+	}
+
+	_, respBody := in.bodies()
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", in.StatusCode, http.StatusText(in.StatusCode)),
+		StatusCode:    in.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header(in.Header).Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(respBody)),
+		ContentLength: int64(len(respBody)),
+		Request:       req,
+	}, nil
+}
+
+// Returns the request to replay, matched the same way as gRPC calls:
+// an unused request with the same method, URL and body, then an unused request with the same method and URL,
+// then a request with the same method, URL and body that was replayed already
+func (c *Cassette) matchHTTP(method, url string, body []byte) *HTTPInteraction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var sameURL, reused *HTTPInteraction
+	for _, in := range c.data.HTTP {
+		if in.Method != method || in.URL != url {
+			continue
+		}
+		reqBody, _ := in.bodies()
+		same := bytes.Equal(reqBody, body)
+		if !c.used[in] {
+			if same {
+				c.used[in] = true
+				return in
+			}
+			if sameURL == nil {
+				sameURL = in
+			}
+		} else if same && reused == nil {
+			reused = in
+		}
+	}
+
+	if sameURL != nil {
+		c.used[sameURL] = true
+		return sameURL
+	}
+	return reused
+}
+
+// Reads the body of the request and replaces it so that it can be sent
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
@@ -51,6 +51,24 @@ type Statistics struct {
 	End      time.Time
 	Duration time.Duration
 	Output   string
+	RPCs     []RPCRecord // the gRPC calls made by the test case (see the recorder package)
+}
+
+// RPCRecord is a gRPC call made during a test case, recorded so that failures can be debugged without a rerun
+type RPCRecord struct {
+	Method    string              // full method name (e.g. /echo.EchoService/Say)
+	Target    string              // the address of the service
+	Lifecycle string              // the lifecycle stage the call was made in
+	Streaming bool                // whether the call was a streaming call
+	Request   string              // the request in JSON (a JSON array of all messages sent for streaming calls)
+	Response  string              // the response in JSON (a JSON array of all messages received for streaming calls)
+	Status    string              // the status code (e.g. OK or InvalidArgument)
+	Error     string              // the status message if the call failed
+	Metadata  map[string][]string // outgoing metadata
+	Header    map[string][]string // header metadata sent by the server
+	Trailer   map[string][]string // trailer metadata sent by the server
+	Start     time.Time
+	Duration  time.Duration
 }
 
 const DefaultHttpTimeout = time.Second * 30 // default HTTP client timeout
@@ -3,11 +3,15 @@ package fuzzer
 import (
 	"context"
 	"fmt"
-	"github.com/google/gofuzz"
-	"github.com/mercari/testdeck/grpcutils"
 	"reflect"
 	"strings"
-	"testing"
+	"time"
+
+	"github.com/google/gofuzz"
+	"github.com/mercari/testdeck"
+	"github.com/mercari/testdeck/grpcutils"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/dynamicpb"
 )
 
 /*
@@ -23,17 +27,49 @@ const DefaultNilChance = 0.05
 
 // Represents configurable options for fuzzing
 type FuzzOptions struct {
-	rounds    int      // the number of inputs to try
-	nilChance float64  // the probability of getting a nil value
-	debugMode bool     // prints the values tried (for debugging purpose)
-	ignoreNil []string // the names of fields that do not support empty/nil values (the fuzzer will not try an empty value when fuzzing these fields)
+	Rounds    int           // the number of inputs to try (DefaultFuzzRounds if 0)
+	NilChance float64       // the probability of getting a nil value
+	DebugMode bool          // prints the values tried (for debugging purpose)
+	IgnoreNil []string      // the names of fields that do not support empty/nil values (the fuzzer will not try an empty value when fuzzing these fields)
+	Timeout   time.Duration // how long to wait for each call (0 means no timeout for gRPC, and the timeout of the client for HTTP)
+	Oracles   []Oracle      // decide whether an input failed, instead of the default oracle of the endpoint (AnyError for gRPC, ServerError for HTTP)
+}
+
+// Returns the options for fuzzing a field, with the defaults filled in
+func fuzzOptions(opts []FuzzOptions, fieldName string, defaultOracle Oracle) FuzzOptions {
+	o := FuzzOptions{Rounds: DefaultFuzzRounds, NilChance: DefaultNilChance}
+	if len(opts) > 0 {
+		o = opts[0]
+		if o.Rounds == 0 {
+			o.Rounds = DefaultFuzzRounds
+		}
+	}
+
+	// if field does not support empty/nil values, set probability of nil values to 0
+	if contains(fieldName, o.IgnoreNil) {
+		o.NilChance = 0
+	}
+	if len(o.Oracles) == 0 {
+		o.Oracles = []Oracle{defaultOracle}
+	}
+	return o
 }
 
 // Runs a fuzz test on all fields of the specified GRPC endpoint
 // client is the client for the microservice (e.g. echoClient)
 // req is a sample, valid request (the fuzzer will mutate the values in this request)
 // opts are configurations for the fuzzing, if not included the default settings will be used
-func FuzzGrpcEndpoint(t *testing.T, ctx context.Context, client interface{}, methodName string, req interface{}, opts ...FuzzOptions) {
+// The failed inputs are reported with t.Errorf and returned
+func FuzzGrpcEndpoint(t testdeck.TestingT, ctx context.Context, client interface{}, methodName string, req interface{}, opts ...FuzzOptions) []Failure {
+	var failures []Failure
+
+	// the fields of dynamic messages (see grpc.DynamicClient) are not Go struct fields
+	if m, ok := req.(*dynamicpb.Message); ok {
+		for _, f := range grpc.DynamicFields(m) {
+			failures = append(failures, FuzzThisField(t, ctx, client, methodName, req, f.Name, opts...)...)
+		}
+		return failures
+	}
 
 	// get parameters of the sample request using reflection because we do not know the protobuf type
 	fieldNames := reflect.TypeOf(req).Elem()
@@ -48,13 +84,15 @@ func FuzzGrpcEndpoint(t *testing.T, ctx context.Context, client interface{}, met
 			break
 		}
 
-		// run fuzz tests on this field
-		if len(opts) > 0 {
-			FuzzThisField(t, ctx, client, methodName, req, fieldName, opts[0])
-		} else {
-			FuzzThisField(t, ctx, client, methodName, req, fieldName)
+		// skip the internal fields of protobuf messages (state, sizeCache, etc.)
+		if fieldNames.Field(i).PkgPath != "" {
+			continue
 		}
+
+		// run fuzz tests on this field
+		failures = append(failures, FuzzThisField(t, ctx, client, methodName, req, fieldName, opts...)...)
 	}
+	return failures
 }
 
 // Runs the specified field of the endpoint
@@ -62,105 +100,124 @@ func FuzzGrpcEndpoint(t *testing.T, ctx context.Context, client interface{}, met
 // req is a sample, valid request (the fuzzer will mutate the values in this request)
 // fieldName is the field to fuzz
 // opts are configurations for the fuzzing, if not included the default settings will be used
-func FuzzThisField(t *testing.T, ctx context.Context, client interface{}, methodName string, req interface{}, fieldName string, opts ...FuzzOptions) {
+// Strings, booleans and numbers can be fuzzed, other fields are skipped
+// The fields of dynamic messages are named as in the proto file (e.g. message_body)
+func FuzzThisField(t testdeck.TestingT, ctx context.Context, client interface{}, methodName string, req interface{}, fieldName string, opts ...FuzzOptions) []Failure {
+	o := fuzzOptions(opts, fieldName, AnyError)
 
-	// the log of values that were tried; it is printed only if opts.debugMode is set to true
-	var log []string
+	if m, ok := req.(*dynamicpb.Message); ok {
+		return fuzzDynamicField(t, ctx, o, client, methodName, m, fieldName)
+	}
+
+	// get the current field to fuzz
+	field := reflect.ValueOf(req).Elem().FieldByName(fieldName)
+	if !field.CanSet() {
+		return nil
+	}
 
-	// default fuzz settings
-	var rounds int = DefaultFuzzRounds
-	var nilChance float64 = DefaultNilChance
-	var debugMode bool = false
+	// set field back to the normal value when done fuzzing
+	normal := reflect.New(field.Type()).Elem()
+	normal.Set(field)
+	defer field.Set(normal)
 
-	// change fuzz settings if config was passed in
-	if len(opts) > 0 {
-		rounds = opts[0].rounds
+	return fuzzValues(t, o, methodName, fieldName, field.Type(), func(input reflect.Value) (Result, bool) {
+		field.Set(input)
 
-		// if field does not support empty/nil values, set probability of nil values to 0
-		if contains(fieldName, opts[0].ignoreNil) {
-			nilChance = 0
-		} else {
-			nilChance = opts[0].nilChance
+		callCtx := ctx
+		if o.Timeout > 0 {
+			var cancel context.CancelFunc
+			callCtx, cancel = context.WithTimeout(ctx, o.Timeout)
+			defer cancel()
 		}
 
-		debugMode = opts[0].debugMode
-	}
+		start := time.Now()
+		res, err := grpc.CallRpcMethod(callCtx, client, methodName, req)
+		return Result{Response: res, Err: err, Duration: time.Since(start)}, true
+	})
+}
 
-	// get the current field to fuzz
-	field := reflect.ValueOf(req).Elem().FieldByName(fieldName)
+// Runs the specified field of a dynamic message
+// Each value is set with protoreflect on a copy of the request, so req is never modified
+func fuzzDynamicField(t testdeck.TestingT, ctx context.Context, o FuzzOptions, client interface{}, methodName string, req *dynamicpb.Message, fieldName string) []Failure {
+	var typ reflect.Type
+	for _, f := range grpc.DynamicFields(req) {
+		if f.Name == fieldName {
+			typ = f.Type
+		}
+	}
+	if typ == nil {
+		return nil
+	}
 
-	// fuzz with different input depending on the type of the field
-	switch field.Kind() {
-
-	// String type
-	case reflect.String:
-		// make a copy of the normal value of this field
-		copy := field.String()
-
-		for i := 0; i < rounds; i++ {
-			// fuzz string
-			var s string
-			fuzz.New().NilChance(nilChance).Fuzz(&s)
-			field.SetString(s)
-			_, err := grpc.CallRpcMethod(ctx, client, methodName, req)
-			if err != nil {
-				t.Errorf("[FAIL] Fuzzing %s > %s: \"%s\" --> ERROR: %s\n", methodName, fieldName, s, err.Error())
-			}
-
-			// add input to debug log
-			log = append(log, fmt.Sprintf("[PASS] Fuzzing %s > %s: \"%s\"\n", methodName, fieldName, s))
+	return fuzzValues(t, o, methodName, fieldName, typ, func(input reflect.Value) (Result, bool) {
+		r := proto.Clone(req).(*dynamicpb.Message)
+		if err := grpc.SetDynamicField(r, fieldName, input.Interface()); err != nil {
+			return Result{}, false
 		}
 
-		// set field back to the normal value when done fuzzing
-		field.SetString(copy)
-
-	case reflect.Int:
-		// make a copy of the normal value of this field
-		copy := field.Int()
-
-		for i := 0; i < rounds; i++ {
-			// fuzz int
-			var j int
-			fuzz.New().NilChance(nilChance).Fuzz(&j)
-			field.SetInt(int64(j))
-			_, err := grpc.CallRpcMethod(ctx, client, methodName, req)
-			if err != nil {
-				t.Errorf("Error occurred while fuzzing %s. Input: \"%d\"; Error: %s", methodName, j, err.Error())
-			}
-
-			// add input to debug log
-			log = append(log, fmt.Sprintf("Fuzzing %s > %s: \"%d\"\n", methodName, fieldName, j))
+		callCtx := ctx
+		if o.Timeout > 0 {
+			var cancel context.CancelFunc
+			callCtx, cancel = context.WithTimeout(ctx, o.Timeout)
+			defer cancel()
 		}
 
-		// set field back to the normal value when done fuzzing
-		field.SetInt(copy)
-
-	case reflect.Float64:
-		// make a copy of the normal value of this field
-		copy := field.Float()
-
-		for i := 0; i < rounds; i++ {
-			// fuzz float
-			var f float64
-			fuzz.New().NilChance(nilChance).Fuzz(&f)
-			field.SetFloat(f)
-			_, err := grpc.CallRpcMethod(ctx, client, methodName, req)
-			if err != nil {
-				t.Errorf("Error occurred while fuzzing %s. Input: \"%b\"; Error: %s", methodName, f, err.Error())
-			}
-
-			// add input to debug log
-			log = append(log, fmt.Sprintf("Fuzzing %s > %s: \"%b\"\n", methodName, fieldName, f))
+		start := time.Now()
+		res, err := grpc.CallRpcMethod(callCtx, client, methodName, r)
+		return Result{Response: res, Err: err, Duration: time.Since(start)}, true
+	})
+}
+
+// Calls the endpoint with random values of the type, checks each result with the oracles and returns the failed inputs
+// call returns false if the value cannot be sent at all, in which case it is skipped
+func fuzzValues(t testdeck.TestingT, o FuzzOptions, target string, fieldName string, typ reflect.Type, call func(input reflect.Value) (Result, bool)) []Failure {
+	if !fuzzable(typ.Kind()) {
+		return nil
+	}
+
+	// the log of values that were tried; it is printed only if o.DebugMode is set to true
+	var log []string
+	var failures []Failure
+
+	f := fuzz.New().NilChance(o.NilChance)
+	for i := 0; i < o.Rounds; i++ {
+		input := reflect.New(typ)
+		f.Fuzz(input.Interface())
+
+		r, sent := call(input.Elem())
+		if !sent {
+			continue
+		}
+		r.Target = target
+		r.Field = fieldName
+		r.Input = input.Elem().Interface()
+
+		if failure, failed := check(r, o.Oracles); failed {
+			t.Errorf("%s\n", failure)
+			failures = append(failures, failure)
+			continue
 		}
 
-		// set field back to the normal value when done fuzzing
-		field.SetFloat(copy)
+		// add input to debug log
+		log = append(log, fmt.Sprintf("[PASS] Fuzzing %s > %s: %s\n", target, fieldName, formatInput(r.Input)))
 	}
 
 	// print log if debug mode
-	if debugMode {
+	if o.DebugMode {
 		fmt.Println(log)
 	}
+	return failures
+}
+
+// Returns true if values of the kind can be generated
+func fuzzable(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.String, reflect.Bool, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
 }
 
 // Helper function to determine if an array of strings contains a certain string
@@ -0,0 +1,178 @@
+package fuzzer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mercari/testdeck"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// records the errors of the fuzzer instead of failing the test
+type recorder struct {
+	testdeck.TestingT
+
+	mu     sync.Mutex
+	errors []string
+}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+// a test service that fails for negative sizes and rejects sizes over 1000
+type sizeServer struct {
+	testpb.UnimplementedTestServiceServer
+}
+
+func (sizeServer) UnaryCall(ctx context.Context, req *testpb.SimpleRequest) (*testpb.SimpleResponse, error) {
+	switch {
+	case req.ResponseSize < 0:
+		return nil, status.Error(codes.Internal, "negative size")
+	case req.ResponseSize > 1000:
+		return nil, status.Error(codes.InvalidArgument, "size is too large")
+	}
+	return &testpb.SimpleResponse{}, nil
+}
+
+func startSizeServer(t *testing.T) testpb.TestServiceClient {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	s := gogrpc.NewServer()
+	testpb.RegisterTestServiceServer(s, sizeServer{})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := gogrpc.Dial(lis.Addr().String(), gogrpc.WithInsecure())
+	require.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return testpb.NewTestServiceClient(conn)
+}
+
+func Test_FuzzThisField_ShouldReportErrors(t *testing.T) {
+	// Arrange
+	client := startSizeServer(t)
+	rec := &recorder{TestingT: t}
+	req := &testpb.SimpleRequest{ResponseSize: 10}
+
+	// Act
+	failures := FuzzThisField(rec, context.Background(), client, "UnaryCall", req, "ResponseSize", FuzzOptions{Rounds: 50})
+
+	// Assert
+	require.NotEmpty(t, failures)
+	assert.Len(t, rec.errors, len(failures))
+	for _, f := range failures {
+		size, err := strconv.Atoi(f.Input)
+		require.Nil(t, err)
+		assert.True(t, size < 0 || size > 1000, size)
+		assert.Equal(t, "UnaryCall", f.Target)
+		assert.Equal(t, "ResponseSize", f.Field)
+	}
+	assert.Equal(t, int32(10), req.ResponseSize, "the field should be set back to the sample value")
+}
+
+func Test_FuzzGrpcEndpoint_ShouldUseOracles(t *testing.T) {
+	// Arrange
+	client := startSizeServer(t)
+	rec := &recorder{TestingT: t}
+	req := &testpb.SimpleRequest{ResponseSize: 10, FillUsername: true}
+
+	// Act
+	failures := FuzzGrpcEndpoint(rec, context.Background(), client, "UnaryCall", req, FuzzOptions{Rounds: 50, Oracles: []Oracle{ServerError}})
+
+	// Assert
+	require.NotEmpty(t, failures)
+	assert.Len(t, rec.errors, len(failures))
+	for _, f := range failures {
+		assert.Equal(t, "ResponseSize", f.Field)
+		assert.Contains(t, f.Reason, "negative size")
+		assert.Contains(t, f.String(), "[FAIL] Fuzzing UnaryCall > ResponseSize: -")
+	}
+}
+
+// a client like grpc.DynamicClient that sends dynamic requests to sizeServer
+type dynamicSizeClient struct{}
+
+func (dynamicSizeClient) InvokeMethod(ctx context.Context, methodName string, req interface{}, opts ...gogrpc.CallOption) (interface{}, error) {
+	b, err := proto.Marshal(req.(*dynamicpb.Message))
+	if err != nil {
+		return nil, err
+	}
+	r := &testpb.SimpleRequest{}
+	if err := proto.Unmarshal(b, r); err != nil {
+		return nil, err
+	}
+	return sizeServer{}.UnaryCall(ctx, r)
+}
+
+func Test_FuzzGrpcEndpoint_ShouldFuzzDynamicMessages(t *testing.T) {
+	// Arrange
+	rec := &recorder{TestingT: t}
+	req := dynamicpb.NewMessage((&testpb.SimpleRequest{}).ProtoReflect().Descriptor())
+	size := req.Descriptor().Fields().ByName("response_size")
+	req.Set(size, protoreflect.ValueOfInt32(10))
+
+	// Act
+	failures := FuzzGrpcEndpoint(rec, context.Background(), dynamicSizeClient{}, "UnaryCall", req, FuzzOptions{Rounds: 50})
+
+	// Assert
+	require.NotEmpty(t, failures)
+	for _, f := range failures {
+		assert.Equal(t, "response_size", f.Field)
+	}
+	assert.Equal(t, int64(10), req.Get(size).Int(), "the sample request should not be modified")
+}
+
+func Test_ServerError(t *testing.T) {
+	reset := &url.Error{Op: "Post", URL: "http://localhost", Err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}}
+	cases := map[string]struct {
+		result Result
+		failed bool
+	}{
+		"ok":                 {Result{StatusCode: 200}, false},
+		"client error":       {Result{StatusCode: 400, Err: errors.New("HTTP 400")}, false},
+		"server error":       {Result{StatusCode: 503, Err: errors.New("HTTP 503")}, true},
+		"timeout":            {Result{Err: context.DeadlineExceeded}, true},
+		"connection reset":   {Result{Err: reset}, true},
+		"connection closed":  {Result{Err: &url.Error{Op: "Post", URL: "http://localhost", Err: io.EOF}}, true},
+		"invalid request":    {Result{Err: errors.New("invalid header field value")}, false},
+		"grpc internal":      {Result{Err: status.Error(codes.Internal, "panic")}, true},
+		"grpc unavailable":   {Result{Err: status.Error(codes.Unavailable, "closed")}, true},
+		"grpc deadline":      {Result{Err: status.Error(codes.DeadlineExceeded, "deadline")}, true},
+		"grpc invalid input": {Result{Err: status.Error(codes.InvalidArgument, "invalid")}, false},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := ServerError(tc.result)
+
+			assert.Equal(t, tc.failed, err != nil, err)
+		})
+	}
+}
+
+func Test_MaxDuration(t *testing.T) {
+	oracle := MaxDuration(time.Second)
+
+	assert.Nil(t, oracle(Result{Duration: time.Millisecond}))
+	assert.NotNil(t, oracle(Result{Duration: 2 * time.Second}))
+}
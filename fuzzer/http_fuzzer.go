@@ -0,0 +1,83 @@
+package fuzzer
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/mercari/testdeck"
+	"github.com/mercari/testdeck/httputils"
+	"github.com/mercari/testdeck/intruder"
+)
+
+/*
+http_fuzzer.go: Runs the fuzzer on HTTP endpoints (e.g. REST gateways) by fuzzing query parameters, form fields, JSON body fields, multipart fields, headers and cookies
+*/
+
+// HTTPRequest is a sample, valid HTTP request with a JSON, form or multipart body (the fuzzer will mutate the values in a copy of this request)
+// It is the same type as the sample request of the HTTP intruder
+type HTTPRequest = intruder.HTTPRequest
+
+// Runs a fuzz test on all parameters of the specified HTTP request
+// req is a sample, valid request (the fuzzer will mutate the values in a copy of this request)
+// opts are configurations for the fuzzing, if not included the default settings will be used (the names in IgnoreNil are positions, e.g. query:lang)
+// By default, 5xx responses, timeouts and connection resets are failures (see ServerError); they are reported with t.Errorf and returned
+func FuzzHTTPEndpoint(t testdeck.TestingT, req HTTPRequest, opts ...FuzzOptions) []Failure {
+	var failures []Failure
+	for _, p := range req.Positions() {
+		failures = append(failures, FuzzThisHTTPPosition(t, req, p, opts...)...)
+	}
+	return failures
+}
+
+// Runs a fuzz test on the specified parameter of the HTTP request
+// req is a sample, valid request (the fuzzer will mutate the values in a copy of this request)
+// p is the parameter to fuzz, which is one of req.Positions()
+// opts are configurations for the fuzzing, if not included the default settings will be used
+func FuzzThisHTTPPosition(t testdeck.TestingT, req HTTPRequest, p intruder.HTTPPosition, opts ...FuzzOptions) []Failure {
+	o := fuzzOptions(opts, p.String(), ServerError)
+
+	if o.Timeout > 0 {
+		client := req.Client
+		if client == nil {
+			client = httputils.DefaultClient
+		}
+		// the client keeps its cookies, so that a logged-in session is still used
+		req.Client = client.WithTimeout(o.Timeout)
+	}
+
+	return fuzzValues(t, o, req.Method+" "+req.URL, p.String(), positionType(p.Kind), func(input reflect.Value) (Result, bool) {
+		// headers and cookies cannot contain line breaks, so the request cannot be sent at all
+		if s := input.String(); input.Kind() == reflect.String && (p.Location == intruder.LocationHeader || p.Location == intruder.LocationCookie) && strings.ContainsAny(s, "\r\n\x00") {
+			return Result{}, false
+		}
+
+		fuzzed, err := req.With(p, input.Interface())
+		if err != nil {
+			return Result{}, false
+		}
+
+		start := time.Now()
+		res, err := fuzzed.Send()
+		r := Result{Err: err, Duration: time.Since(start)}
+		if res != nil {
+			r.Response = res
+			r.StatusCode = res.StatusCode
+		}
+		return r, true
+	})
+}
+
+// Returns the type of the values to generate for a parameter
+func positionType(kind reflect.Kind) reflect.Type {
+	switch kind {
+	case reflect.Bool:
+		return reflect.TypeOf(false)
+	case reflect.Int:
+		return reflect.TypeOf(0)
+	case reflect.Float64:
+		return reflect.TypeOf(0.0)
+	default:
+		return reflect.TypeOf("")
+	}
+}
@@ -0,0 +1,127 @@
+package fuzzer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mercari/testdeck/httputils"
+	"github.com/mercari/testdeck/intruder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// a test server that closes the connection for unknown queries, fails for negative counts and rejects unknown names
+func startItemServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/items", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("q") != "ok" {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+
+		var body struct {
+			Name  string  `json:"name"`
+			Count float64 `json:"count"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		switch {
+		case body.Count < 0:
+			w.WriteHeader(http.StatusInternalServerError)
+		case body.Name != "sample":
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func Test_FuzzHTTPEndpoint_ShouldReportServerErrors(t *testing.T) {
+	// Arrange
+	server := startItemServer(t)
+	rec := &recorder{TestingT: t}
+	req := HTTPRequest{
+		Method:  http.MethodPost,
+		URL:     server.URL + "/items",
+		Query:   map[string][]string{"q": {"ok"}},
+		JSON:    map[string]interface{}{"name": "sample", "count": 1},
+		Headers: map[string]string{"X-Request-Id": "1"},
+	}
+
+	// Act
+	failures := FuzzHTTPEndpoint(rec, req, FuzzOptions{Rounds: 20})
+
+	// Assert
+	assert.Len(t, rec.errors, len(failures))
+	fields := map[string]bool{}
+	for _, f := range failures {
+		fields[f.Field] = true
+		assert.Equal(t, "POST "+server.URL+"/items", f.Target)
+		switch f.Field {
+		case "query:q":
+			assert.Contains(t, f.Reason, "connection reset")
+		case "json:count":
+			assert.Equal(t, http.StatusInternalServerError, f.StatusCode)
+		}
+	}
+	// the 400 responses to fuzzed names are not failures
+	assert.Equal(t, map[string]bool{"query:q": true, "json:count": true}, fields)
+	assert.Equal(t, 1, req.JSON["count"], "the sample request should not be modified")
+}
+
+func Test_FuzzThisHTTPPosition_ShouldReportTimeouts(t *testing.T) {
+	// Arrange
+	server := startItemServer(t)
+	rec := &recorder{TestingT: t}
+	req := HTTPRequest{Method: http.MethodGet, URL: server.URL + "/slow", Headers: map[string]string{"X-Request-Id": "1"}}
+	p := intruder.HTTPPosition{Location: intruder.LocationHeader, Name: "X-Request-Id", Kind: reflect.String}
+
+	// Act
+	failures := FuzzThisHTTPPosition(rec, req, p, FuzzOptions{Rounds: 2, Timeout: 100 * time.Millisecond})
+
+	// Assert
+	require.Len(t, failures, 2)
+	for _, f := range failures {
+		assert.Equal(t, "header:X-Request-Id", f.Field)
+		assert.Contains(t, f.Reason, "timeout")
+	}
+	assert.Nil(t, req.Client, "the sample request should not be modified")
+}
+
+func Test_FuzzThisHTTPPosition_ShouldKeepSessionWithTimeout(t *testing.T) {
+	// Arrange
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/"})
+			return
+		}
+		if c, err := r.Cookie("session"); err != nil || c.Value != "abc" {
+			// a server that fails for requests without a session
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(server.Close)
+	session, err := httputils.NewClient()
+	require.Nil(t, err)
+	_, _, err = session.Send(http.MethodPost, server.URL+"/login", nil, nil)
+	require.Nil(t, err)
+	rec := &recorder{TestingT: t}
+	req := HTTPRequest{Method: http.MethodGet, URL: server.URL + "/me", Query: map[string][]string{"q": {"ok"}}, Client: session}
+	p := intruder.HTTPPosition{Location: intruder.LocationQuery, Name: "q", Kind: reflect.String}
+
+	// Act
+	failures := FuzzThisHTTPPosition(rec, req, p, FuzzOptions{Rounds: 5, Timeout: time.Second})
+
+	// Assert
+	assert.Empty(t, failures, "the requests should be sent with the cookies of the session")
+	assert.Empty(t, rec.errors)
+}
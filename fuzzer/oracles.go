@@ -0,0 +1,139 @@
+package fuzzer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/*
+oracles.go: Decides which fuzzed inputs failed, and describes the failures in a structured way
+*/
+
+// Result is the result of a call with a fuzzed input
+type Result struct {
+	Target     string        // the rpc method (e.g. Say) or the HTTP method and URL (e.g. POST https://example.com/v1/echo)
+	Field      string        // the field the input was set to (e.g. MessageId or json:message_body)
+	Input      interface{}   // the fuzzed value
+	Response   interface{}   // the response returned by the endpoint (*intruder.HTTPResponse for HTTP endpoints)
+	StatusCode int           // the HTTP status code of the response (0 for gRPC endpoints or if there was no response)
+	Duration   time.Duration // how long the endpoint took to respond
+	Err        error         // the error returned by the endpoint
+}
+
+// Oracle decides whether a fuzzed input failed, and returns the reason if it did
+type Oracle func(r Result) error
+
+// Failure is a fuzzed input that failed
+type Failure struct {
+	Target     string        `json:"target"`               // the rpc method or the HTTP method and URL
+	Field      string        `json:"field"`                // the field the input was set to
+	Input      string        `json:"input"`                // the fuzzed value (strings are quoted)
+	Reason     string        `json:"reason"`               // why the input failed
+	StatusCode int           `json:"statusCode,omitempty"` // the HTTP status code of the response
+	Duration   time.Duration `json:"duration"`             // how long the endpoint took to respond
+}
+
+// Returns the failure in the format of the fuzzer log (e.g. [FAIL] Fuzzing Say > MessageId: "" --> ERROR: ...)
+func (f Failure) String() string {
+	return fmt.Sprintf("[FAIL] Fuzzing %s > %s: %s --> ERROR: %s", f.Target, f.Field, f.Input, f.Reason)
+}
+
+// Checks the result with each oracle and returns the failure found by the first oracle that fails it
+func check(r Result, oracles []Oracle) (Failure, bool) {
+	for _, oracle := range oracles {
+		if err := oracle(r); err != nil {
+			return Failure{
+				Target:     r.Target,
+				Field:      r.Field,
+				Input:      formatInput(r.Input),
+				Reason:     err.Error(),
+				StatusCode: r.StatusCode,
+				Duration:   r.Duration,
+			}, true
+		}
+	}
+	return Failure{}, false
+}
+
+// Returns the input as it is written in the fuzzer log
+func formatInput(input interface{}) string {
+	if s, ok := input.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprint(input)
+}
+
+// ----------
+// oracles
+// ----------
+
+// AnyError fails every input that the endpoint returns an error for (including HTTP status codes of 400 or higher)
+// This is the default oracle for gRPC endpoints, so the fuzzer finds the inputs that the endpoint rejects
+func AnyError(r Result) error {
+	return r.Err
+}
+
+// ServerError fails the inputs that make the server fail rather than reject them: 5xx HTTP responses,
+// Internal, Unknown and DataLoss gRPC errors, timeouts and connections that are reset or closed by the server
+// This is the default oracle for HTTP endpoints
+func ServerError(r Result) error {
+	switch {
+	case r.StatusCode >= http.StatusInternalServerError:
+		if r.Err == nil {
+			return fmt.Errorf("HTTP %d", r.StatusCode)
+		}
+		return r.Err
+	case r.Err == nil || r.StatusCode != 0:
+		return nil
+	case isTimeout(r.Err):
+		return fmt.Errorf("timeout after %s: %s", r.Duration, r.Err.Error())
+	case isConnectionReset(r.Err):
+		return fmt.Errorf("connection reset: %s", r.Err.Error())
+	}
+
+	// other errors that are not gRPC errors (e.g. a request that could not be built) are not failures of the server
+	st, ok := status.FromError(r.Err)
+	if !ok {
+		return nil
+	}
+	switch st.Code() {
+	case codes.Internal, codes.Unknown, codes.DataLoss:
+		return r.Err
+	case codes.Unavailable:
+		return fmt.Errorf("connection reset: %s", r.Err.Error())
+	}
+	return nil
+}
+
+// MaxDuration fails the inputs that the endpoint takes longer than d to respond to
+func MaxDuration(d time.Duration) Oracle {
+	return func(r Result) error {
+		if r.Duration > d {
+			return fmt.Errorf("took %s, longer than %s", r.Duration, d)
+		}
+		return nil
+	}
+}
+
+// Returns true if the call timed out
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || status.Code(err) == codes.DeadlineExceeded {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// Returns true if the server reset or closed the connection without a response
+func isConnectionReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
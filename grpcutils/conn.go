@@ -0,0 +1,271 @@
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mercari/testdeck/cassette"
+	"github.com/mercari/testdeck/recorder"
+	"github.com/mercari/testdeck/runner"
+	"github.com/mercari/testdeck/service/config"
+	"golang.org/x/oauth2"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+)
+
+/*
+conn.go: A connection factory for gRPC services configured from config.Env, and a pool that reuses connections between tests
+*/
+
+// ConnConfig configures the connections made by Dial
+type ConnConfig struct {
+	Target             string                           // the address of the service (e.g. localhost:8080)
+	Insecure           bool                             // connect without TLS
+	CACertFile         string                           // PEM file with the CA certificates of the service, the system certificates are used if empty
+	ServerName         string                           // the name to verify the certificate against, if it is different from the host of the target
+	Token              string                           // bearer token sent with every RPC
+	TokenSource        oauth2.TokenSource               // OAuth token source used for every RPC (instead of Token)
+	Timeout            time.Duration                    // default timeout of each RPC, used when the context has no deadline
+	KeepaliveTime      time.Duration                    // how often to ping the service, keepalive is disabled if 0
+	KeepaliveTimeout   time.Duration                    // how long to wait for a ping to be acknowledged
+	UnaryInterceptors  []gogrpc.UnaryClientInterceptor  // interceptors for unary RPCs (e.g. logging or retries)
+	StreamInterceptors []gogrpc.StreamClientInterceptor // interceptors for streaming RPCs
+	DialOptions        []gogrpc.DialOption              // any other dial options
+	Proxy              string                           // URL of an HTTP CONNECT proxy to connect through (e.g. http://localhost:8080), the proxy set by ConnectToProxy is used if empty
+	ProxyCACertFile    string                           // PEM file with the CA certificate of the proxy, trusted in addition to the CA of the service so that the proxy can intercept TLS
+
+	direct bool // ignore the proxies (e.g. when connecting to the stand-in server of a cassette)
+}
+
+// Returns the connection config of the service declared by the GRPC_* environment variables
+func ConnConfigFromEnv(env *config.Env) ConnConfig {
+	return ConnConfig{
+		Target:           env.GrpcTarget,
+		Insecure:         env.GrpcInsecure,
+		CACertFile:       env.GrpcCACert,
+		ServerName:       env.GrpcServerName,
+		Token:            env.GrpcToken,
+		Timeout:          env.GrpcTimeout,
+		KeepaliveTime:    env.GrpcKeepaliveTime,
+		KeepaliveTimeout: env.GrpcKeepaliveTimeout,
+		Proxy:            env.GrpcProxy,
+		ProxyCACertFile:  env.GrpcProxyCACert,
+	}
+}
+
+// Returns the dial options for the config
+func (c ConnConfig) Options() ([]gogrpc.DialOption, error) {
+	var opts []gogrpc.DialOption
+	proxy, proxyCA := c.proxy()
+
+	switch {
+	case c.Insecure && c.CACertFile != "":
+		return nil, fmt.Errorf("a CA certificate cannot be used with an insecure connection")
+	case c.Insecure:
+		opts = append(opts, gogrpc.WithInsecure())
+	case proxyCA != "":
+		pool, err := rootCAs(c.CACertFile, proxyCA)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, gogrpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{RootCAs: pool, ServerName: c.ServerName})))
+	case c.CACertFile != "":
+		creds, err := credentials.NewClientTLSFromFile(c.CACertFile, c.ServerName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %s", err.Error())
+		}
+		opts = append(opts, gogrpc.WithTransportCredentials(creds))
+	default:
+		opts = append(opts, gogrpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{ServerName: c.ServerName})))
+	}
+
+	switch {
+	case c.TokenSource != nil:
+		opts = append(opts, gogrpc.WithPerRPCCredentials(tokenCredentials{source: c.TokenSource, secure: !c.Insecure}))
+	case c.Token != "":
+		token := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: c.Token, TokenType: "Bearer"})
+		opts = append(opts, gogrpc.WithPerRPCCredentials(tokenCredentials{source: token, secure: !c.Insecure}))
+	}
+
+	if proxy != "" {
+		dialer, err := proxyDialOption(proxy)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, dialer)
+	}
+
+	if c.KeepaliveTime > 0 {
+		opts = append(opts, gogrpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                c.KeepaliveTime,
+			Timeout:             c.KeepaliveTimeout,
+			PermitWithoutStream: true,
+		}))
+	}
+
+	// the calls of test cases are recorded (see TD.Context)
+	unary := append([]gogrpc.UnaryClientInterceptor{recorder.UnaryClientInterceptor()}, c.UnaryInterceptors...)
+	stream := append([]gogrpc.StreamClientInterceptor{recorder.StreamClientInterceptor()}, c.StreamInterceptors...)
+	if c.Timeout > 0 {
+		// the timeout is applied first so that the other interceptors see the deadline
+		unary = append([]gogrpc.UnaryClientInterceptor{timeoutUnaryInterceptor(c.Timeout)}, unary...)
+		stream = append([]gogrpc.StreamClientInterceptor{timeoutStreamInterceptor(c.Timeout)}, stream...)
+	}
+	opts = append(opts, gogrpc.WithChainUnaryInterceptor(unary...), gogrpc.WithChainStreamInterceptor(stream...))
+
+	return append(opts, c.DialOptions...), nil
+}
+
+// Connects to the service with the config
+// While a cassette is replaying, the connection is made to its stand-in server instead (see the cassette package)
+func Dial(ctx context.Context, c ConnConfig) (*gogrpc.ClientConn, error) {
+	if c.Target == "" {
+		return nil, fmt.Errorf("the target of the gRPC connection is not set")
+	}
+
+	cs := cassette.Current()
+	if cs != nil && cs.Mode() == cassette.ModeReplay {
+		c.Target = cs.Addr()
+		c.Insecure = true
+		c.CACertFile = ""
+		c.direct = true
+	}
+
+	opts, err := c.Options()
+	if err != nil {
+		return nil, err
+	}
+	return gogrpc.DialContext(ctx, c.Target, append(opts, cs.DialOptions()...)...)
+}
+
+// ----------
+// connection pool
+// ----------
+
+// ConnPool reuses connections between tests, one for each target
+// If the pool is used while the testdeck runner is running, its connections are closed when the test run finishes
+type ConnPool struct {
+	mu         sync.Mutex
+	conns      map[string]*gogrpc.ClientConn
+	registered bool // Close has been registered with the runner
+}
+
+// DefaultPool is the pool used by Connect
+var DefaultPool = NewConnPool()
+
+// Creates an empty pool
+func NewConnPool() *ConnPool {
+	return &ConnPool{conns: map[string]*gogrpc.ClientConn{}}
+}
+
+// Returns the connection to the target of the config, connecting to it if there is none yet
+// Connections are shared by target, so the config of the first connection to a target is used for all tests
+func (p *ConnPool) Get(ctx context.Context, c ConnConfig) (*gogrpc.ClientConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if conn, ok := p.conns[c.Target]; ok {
+		return conn, nil
+	}
+
+	conn, err := Dial(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	p.conns[c.Target] = conn
+
+	if !p.registered && runner.Initialized() {
+		runner.Instance(nil).Defer(func() { p.Close() })
+		p.registered = true
+	}
+	return conn, nil
+}
+
+// Closes all connections of the pool and removes them, returns the first error
+func (p *ConnPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var first error
+	for target, conn := range p.conns {
+		if err := conn.Close(); err != nil && first == nil {
+			first = err
+		}
+		delete(p.conns, target)
+	}
+	p.registered = false
+	return first
+}
+
+// Returns a pooled connection to the service declared by the environment variables of the test service (see config.Env)
+// If env is nil, the environment variables are read
+func Connect(ctx context.Context, env *config.Env) (*gogrpc.ClientConn, error) {
+	if env == nil {
+		var err error
+		if env, err = config.ReadFromEnv(); err != nil {
+			return nil, err
+		}
+	}
+	return DefaultPool.Get(ctx, ConnConfigFromEnv(env))
+}
+
+// ----------
+// credentials and interceptors
+// ----------
+
+// Sends an OAuth token in the authorization metadata of every RPC
+type tokenCredentials struct {
+	source oauth2.TokenSource
+	secure bool
+}
+
+func (c tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token, err := c.source.Token()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"authorization": token.Type() + " " + token.AccessToken}, nil
+}
+
+// Tokens can be sent over insecure connections when testing local services
+func (c tokenCredentials) RequireTransportSecurity() bool {
+	return c.secure
+}
+
+// Adds the timeout to unary RPCs whose context has no deadline
+func timeoutUnaryInterceptor(timeout time.Duration) gogrpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *gogrpc.ClientConn, invoker gogrpc.UnaryInvoker, opts ...gogrpc.CallOption) error {
+		if _, ok := ctx.Deadline(); !ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// Adds the timeout to streams whose context has no deadline
+func timeoutStreamInterceptor(timeout time.Duration) gogrpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *gogrpc.StreamDesc, cc *gogrpc.ClientConn, method string, streamer gogrpc.Streamer, opts ...gogrpc.CallOption) (gogrpc.ClientStream, error) {
+		if _, ok := ctx.Deadline(); ok {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		s, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		// the context of the stream is done when the stream finishes
+		go func() {
+			<-s.Context().Done()
+			cancel()
+		}()
+		return s, nil
+	}
+}
@@ -0,0 +1,103 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mercari/testdeck/service/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	gogrpc "google.golang.org/grpc"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+)
+
+// starts the metadata test service (which returns the authorization metadata as the username) and returns its address
+func startMetadataServer(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+
+	s := gogrpc.NewServer()
+	testpb.RegisterTestServiceServer(s, metadataServer{})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return lis.Addr().String()
+}
+
+func Test_Dial_ShouldSendToken(t *testing.T) {
+	// Arrange
+	env := &config.Env{GrpcTarget: startMetadataServer(t), GrpcInsecure: true, GrpcToken: "abc"}
+	conn, err := Dial(context.Background(), ConnConfigFromEnv(env))
+	require.Nil(t, err)
+	defer conn.Close()
+
+	// Act
+	res, err := testpb.NewTestServiceClient(conn).UnaryCall(context.Background(), &testpb.SimpleRequest{})
+
+	// Assert
+	require.Nil(t, err)
+	assert.Equal(t, "Bearer abc", res.Username)
+}
+
+func Test_Dial_ShouldUseTokenSourceAndInterceptors(t *testing.T) {
+	// Arrange
+	var deadline time.Time
+	var methods []string
+	conn, err := Dial(context.Background(), ConnConfig{
+		Target:      startMetadataServer(t),
+		Insecure:    true,
+		TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "xyz"}),
+		Timeout:     time.Minute,
+		UnaryInterceptors: []gogrpc.UnaryClientInterceptor{
+			func(ctx context.Context, method string, req, reply interface{}, cc *gogrpc.ClientConn, invoker gogrpc.UnaryInvoker, opts ...gogrpc.CallOption) error {
+				methods = append(methods, method)
+				deadline, _ = ctx.Deadline()
+				return invoker(ctx, method, req, reply, cc, opts...)
+			},
+		},
+	})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	// Act
+	res, err := testpb.NewTestServiceClient(conn).UnaryCall(context.Background(), &testpb.SimpleRequest{})
+
+	// Assert
+	require.Nil(t, err)
+	assert.Equal(t, "Bearer xyz", res.Username)
+	assert.Equal(t, []string{"/grpc.testing.TestService/UnaryCall"}, methods)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, 10*time.Second)
+}
+
+func Test_Dial_ShouldReturnConfigErrors(t *testing.T) {
+	_, noTarget := Dial(context.Background(), ConnConfig{Insecure: true})
+	_, insecureWithCA := Dial(context.Background(), ConnConfig{Target: "localhost:1", Insecure: true, CACertFile: "ca.pem"})
+	_, missingCA := Dial(context.Background(), ConnConfig{Target: "localhost:1", CACertFile: "testdata/missing.pem"})
+
+	assert.NotNil(t, noTarget)
+	assert.NotNil(t, insecureWithCA)
+	assert.NotNil(t, missingCA)
+}
+
+func Test_ConnPool_ShouldReuseConnectionsByTarget(t *testing.T) {
+	// Arrange
+	p := NewConnPool()
+	c := ConnConfig{Target: startMetadataServer(t), Insecure: true}
+
+	// Act
+	first, err := p.Get(context.Background(), c)
+	require.Nil(t, err)
+	second, _ := p.Get(context.Background(), c)
+	other, _ := p.Get(context.Background(), ConnConfig{Target: "localhost:1", Insecure: true})
+	closeErr := p.Close()
+	afterClose, _ := p.Get(context.Background(), c)
+	defer p.Close()
+
+	// Assert
+	assert.Same(t, first, second)
+	assert.NotSame(t, first, other)
+	assert.Nil(t, closeErr)
+	assert.NotSame(t, first, afterClose)
+}
@@ -0,0 +1,417 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/mercari/testdeck/cassette"
+	"github.com/mercari/testdeck/recorder"
+	gogrpc "google.golang.org/grpc"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+/*
+dynamic.go: A gRPC client that calls methods without generated code by reading the service's descriptors through server reflection
+*/
+
+// MethodInvoker is implemented by clients that can call rpc methods by name without generated code (e.g. DynamicClient)
+// CallRpcMethod uses it instead of looking up the method of a generated client
+type MethodInvoker interface {
+	InvokeMethod(ctx context.Context, methodName string, req interface{}, opts ...gogrpc.CallOption) (interface{}, error)
+}
+
+// DynamicClient calls the methods of a gRPC service that has server reflection enabled
+// Requests can be built from JSON, so the test binary does not need to import the service's generated code
+type DynamicClient struct {
+	conn    *gogrpc.ClientConn
+	owned   bool // the connection was opened by the client and is closed by Close
+	service protoreflect.ServiceDescriptor
+}
+
+// Connects to the target and returns a client for the service (e.g. "echo.EchoService")
+// opts are passed to grpc.DialContext (e.g. grpc.WithInsecure()), the calls of test cases are recorded as well (see TD.Context)
+// While a cassette is replaying, the client connects to its stand-in server instead
+// The connection goes through the proxy set by ConnectToProxy, but the CA certificate of the proxy must be added to opts yourself
+func DialDynamicClient(ctx context.Context, target string, service string, opts ...gogrpc.DialOption) (*DynamicClient, error) {
+	opts = append(recorder.DialOptions(), opts...)
+	cs := cassette.Current()
+	if cs != nil && cs.Mode() == cassette.ModeReplay {
+		target = cs.Addr()
+		opts = append(opts, gogrpc.WithInsecure())
+	} else if proxy, _ := (ConnConfig{}).proxy(); proxy != "" {
+		dialer, err := proxyDialOption(proxy)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, dialer)
+	}
+
+	conn, err := gogrpc.DialContext(ctx, target, append(opts, cs.DialOptions()...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := NewDynamicClient(ctx, conn, service)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.owned = true
+	return c, nil
+}
+
+// Returns a client for the service (e.g. "echo.EchoService") using an existing connection
+// The descriptors of the service are read through server reflection
+func NewDynamicClient(ctx context.Context, conn *gogrpc.ClientConn, service string) (*DynamicClient, error) {
+	files, err := resolveFiles(ctx, conn, service)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, fmt.Errorf("service %s was not found: %s", service, err.Error())
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", service)
+	}
+
+	return &DynamicClient{conn: conn, service: sd}, nil
+}
+
+// Closes the connection if it was opened by DialDynamicClient
+func (c *DynamicClient) Close() error {
+	if c.owned {
+		return c.conn.Close()
+	}
+	return nil
+}
+
+// Returns the connection of the client
+func (c *DynamicClient) Conn() *gogrpc.ClientConn {
+	return c.conn
+}
+
+// Returns the descriptor of the service
+func (c *DynamicClient) Service() protoreflect.ServiceDescriptor {
+	return c.service
+}
+
+// Returns the names of all methods of the service in sorted order
+func (c *DynamicClient) Methods() []string {
+	var names []string
+	methods := c.service.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		names = append(names, string(methods.Get(i).Name()))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the descriptor of the method (e.g. "Say")
+func (c *DynamicClient) Method(methodName string) (protoreflect.MethodDescriptor, error) {
+	m := c.service.Methods().ByName(protoreflect.Name(methodName))
+	if m == nil {
+		return nil, fmt.Errorf("method %s was not found in %s", methodName, c.service.FullName())
+	}
+	return m, nil
+}
+
+// Returns the full name of the method that is used on the wire (e.g. /echo.EchoService/Say)
+func (c *DynamicClient) FullMethod(methodName string) string {
+	return fmt.Sprintf("/%s/%s", c.service.FullName(), methodName)
+}
+
+// Returns an empty request message for the method
+func (c *DynamicClient) NewRequest(methodName string) (*dynamicpb.Message, error) {
+	m, err := c.Method(methodName)
+	if err != nil {
+		return nil, err
+	}
+	return dynamicpb.NewMessage(m.Input()), nil
+}
+
+// Returns a request message for the method built from JSON (in the protobuf JSON format, e.g. {"message_id": "1"})
+func (c *DynamicClient) RequestFromJSON(methodName string, data []byte) (*dynamicpb.Message, error) {
+	req, err := c.NewRequest(methodName)
+	if err != nil {
+		return nil, err
+	}
+	if err := protojson.Unmarshal(data, req); err != nil {
+		return nil, fmt.Errorf("failed to build %s request from JSON: %s", methodName, err.Error())
+	}
+	return req, nil
+}
+
+// Calls a unary method and returns the response message
+// req can be a protobuf message, JSON ([]byte, string or json.RawMessage), or any value that can be marshaled to JSON
+// (e.g. a struct with json tags that match the field names of the request message)
+func (c *DynamicClient) Invoke(ctx context.Context, methodName string, req interface{}, opts ...gogrpc.CallOption) (*dynamicpb.Message, error) {
+	m, err := c.Method(methodName)
+	if err != nil {
+		return nil, err
+	}
+	if m.IsStreamingClient() || m.IsStreamingServer() {
+		return nil, fmt.Errorf("method %s is a streaming method, use OpenStream or the stream helpers", methodName)
+	}
+
+	in, err := c.toRequest(m, req)
+	if err != nil {
+		return nil, err
+	}
+
+	out := dynamicpb.NewMessage(m.Output())
+	if err := c.conn.Invoke(ctx, c.FullMethod(methodName), in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Calls a unary method and returns the response decoded from its JSON form (map[string]interface{})
+// This allows CallRpcMethod (and therefore the fuzzer and intruder) to use the client, and to check the fields of the response
+func (c *DynamicClient) InvokeMethod(ctx context.Context, methodName string, req interface{}, opts ...gogrpc.CallOption) (interface{}, error) {
+	res, err := c.Invoke(ctx, methodName, req, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return decodeMessage(res)
+}
+
+// Returns the message decoded from its JSON form
+func decodeMessage(m proto.Message) (map[string]interface{}, error) {
+	data, err := protojson.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	var decoded map[string]interface{}
+	err = json.Unmarshal(data, &decoded)
+	return decoded, err
+}
+
+// Converts the request to a message of the input type of the method
+func (c *DynamicClient) toRequest(m protoreflect.MethodDescriptor, req interface{}) (proto.Message, error) {
+	var data []byte
+	switch r := req.(type) {
+	case proto.Message:
+		if r.ProtoReflect().Descriptor().FullName() == m.Input().FullName() {
+			return r, nil
+		}
+		return nil, fmt.Errorf("request is %s but %s expects %s", r.ProtoReflect().Descriptor().FullName(), m.Name(), m.Input().FullName())
+	case []byte:
+		data = r
+	case json.RawMessage:
+		data = r
+	case string:
+		data = []byte(r)
+	default:
+		var err error
+		if data, err = json.Marshal(req); err != nil {
+			return nil, err
+		}
+	}
+
+	in := dynamicpb.NewMessage(m.Input())
+	if err := protojson.Unmarshal(data, in); err != nil {
+		return nil, fmt.Errorf("failed to build %s request: %s", m.Name(), err.Error())
+	}
+	return in, nil
+}
+
+// ----------
+// fields of dynamic messages
+// ----------
+
+// DynamicField is a singular scalar field of a dynamic message, which values can be set with SetDynamicField
+type DynamicField struct {
+	Name string       // the name of the field in the proto file (e.g. message_body)
+	Type reflect.Type // the Go type of its values (e.g. string, int32 for int32 and enum fields, []byte for bytes fields)
+}
+
+// Returns the singular scalar fields of the message, in the order they are declared
+// Repeated, map and message fields are not returned, because a single value cannot be set to them
+// This lets the fuzzer and the intruder inject values into requests built by DynamicClient, whose fields are not Go struct fields
+func DynamicFields(m *dynamicpb.Message) []DynamicField {
+	var fields []DynamicField
+	fds := m.Descriptor().Fields()
+	for i := 0; i < fds.Len(); i++ {
+		if t := dynamicFieldType(fds.Get(i)); t != nil {
+			fields = append(fields, DynamicField{Name: string(fds.Get(i).Name()), Type: t})
+		}
+	}
+	return fields
+}
+
+// Sets the field of the message (its proto or JSON name, e.g. message_body or messageBody) to the value
+// The value is converted to the type of the field (e.g. an int payload can be set to an int64 field)
+func SetDynamicField(m *dynamicpb.Message, name string, value interface{}) error {
+	fd := m.Descriptor().Fields().ByName(protoreflect.Name(name))
+	if fd == nil {
+		fd = m.Descriptor().Fields().ByJSONName(name)
+	}
+	if fd == nil {
+		return fmt.Errorf("%s does not have a field called %s", m.Descriptor().FullName(), name)
+	}
+	t := dynamicFieldType(fd)
+	if t == nil {
+		return fmt.Errorf("%s.%s is not a singular scalar field", m.Descriptor().FullName(), name)
+	}
+
+	v := reflect.ValueOf(value)
+	if !v.IsValid() || !v.Type().ConvertibleTo(t) {
+		return fmt.Errorf("%v cannot be set to %s.%s (%s)", value, m.Descriptor().FullName(), name, fd.Kind())
+	}
+	converted := v.Convert(t).Interface()
+	if fd.Kind() == protoreflect.EnumKind {
+		m.Set(fd, protoreflect.ValueOfEnum(protoreflect.EnumNumber(converted.(int32))))
+		return nil
+	}
+	m.Set(fd, protoreflect.ValueOf(converted))
+	return nil
+}
+
+// Returns the Go type of the values of a singular scalar field, or nil for repeated, map and message fields
+func dynamicFieldType(fd protoreflect.FieldDescriptor) reflect.Type {
+	if fd.IsList() || fd.IsMap() {
+		return nil
+	}
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return reflect.TypeOf(false)
+	case protoreflect.EnumKind, protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return reflect.TypeOf(int32(0))
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return reflect.TypeOf(int64(0))
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return reflect.TypeOf(uint32(0))
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return reflect.TypeOf(uint64(0))
+	case protoreflect.FloatKind:
+		return reflect.TypeOf(float32(0))
+	case protoreflect.DoubleKind:
+		return reflect.TypeOf(float64(0))
+	case protoreflect.StringKind:
+		return reflect.TypeOf("")
+	case protoreflect.BytesKind:
+		return reflect.TypeOf([]byte(nil))
+	}
+	return nil
+}
+
+// ----------
+// server reflection
+// ----------
+
+// Reads the file that defines the symbol and all of its dependencies through server reflection
+func resolveFiles(ctx context.Context, conn *gogrpc.ClientConn, symbol string) (*protoregistry.Files, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.CloseSend()
+
+	r := &reflectionResolver{stream: stream, protos: map[string]*descriptorpb.FileDescriptorProto{}}
+	if err := r.request(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: symbol},
+	}); err != nil {
+		return nil, err
+	}
+
+	files := &protoregistry.Files{}
+	for _, name := range r.order {
+		if err := r.register(files, name); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// Collects file descriptors from the server reflection stream
+type reflectionResolver struct {
+	stream rpb.ServerReflection_ServerReflectionInfoClient
+	protos map[string]*descriptorpb.FileDescriptorProto
+	order  []string // the names of the files in the order they were received
+}
+
+// Sends the request and adds the files in the response, then requests any dependencies that were not included
+func (r *reflectionResolver) request(req *rpb.ServerReflectionRequest) error {
+	if err := r.stream.Send(req); err != nil {
+		return err
+	}
+	res, err := r.stream.Recv()
+	if err != nil {
+		return err
+	}
+	if e := res.GetErrorResponse(); e != nil {
+		return fmt.Errorf("server reflection error: %s", e.GetErrorMessage())
+	}
+
+	var received []*descriptorpb.FileDescriptorProto
+	for _, b := range res.GetFileDescriptorResponse().GetFileDescriptorProto() {
+		fd := &descriptorpb.FileDescriptorProto{}
+		if err := proto.Unmarshal(b, fd); err != nil {
+			return err
+		}
+		if _, ok := r.protos[fd.GetName()]; !ok {
+			r.protos[fd.GetName()] = fd
+			r.order = append(r.order, fd.GetName())
+			received = append(received, fd)
+		}
+	}
+
+	for _, fd := range received {
+		for _, dep := range fd.GetDependency() {
+			if _, ok := r.protos[dep]; ok {
+				continue
+			}
+			if err := r.request(&rpb.ServerReflectionRequest{
+				MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{FileByFilename: dep},
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Registers the file after all of its dependencies
+func (r *reflectionResolver) register(files *protoregistry.Files, name string) error {
+	if _, err := files.FindFileByPath(name); err == nil {
+		return nil
+	}
+
+	fd, ok := r.protos[name]
+	if !ok {
+		// the server did not send the file, so it must be one of the well-known files linked into the test binary
+		f, err := protoregistry.GlobalFiles.FindFileByPath(name)
+		if err != nil {
+			return fmt.Errorf("file descriptor %s was not found", name)
+		}
+		return files.RegisterFile(f)
+	}
+
+	for _, dep := range fd.GetDependency() {
+		if err := r.register(files, dep); err != nil {
+			return err
+		}
+	}
+
+	f, err := protodesc.NewFile(fd, files)
+	if err != nil {
+		return fmt.Errorf("invalid file descriptor %s: %s", name, err.Error())
+	}
+	return files.RegisterFile(f)
+}
@@ -0,0 +1,145 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const healthService = "grpc.health.v1.Health"
+
+// starts a server with the health service and server reflection, and returns its address
+func startHealthServer(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+
+	s := gogrpc.NewServer()
+	h := health.NewServer()
+	h.SetServingStatus("echo", healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(s, h)
+	reflection.Register(s)
+
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	return lis.Addr().String()
+}
+
+func dialHealthClient(t *testing.T) *DynamicClient {
+	c, err := DialDynamicClient(context.Background(), startHealthServer(t), healthService, gogrpc.WithInsecure())
+	require.Nil(t, err)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func Test_DynamicClient_ShouldReadMethodsThroughReflection(t *testing.T) {
+	// Act
+	c := dialHealthClient(t)
+
+	// Assert
+	assert.Equal(t, []string{"Check", "Watch"}, c.Methods())
+	m, err := c.Method("Check")
+	require.Nil(t, err)
+	assert.Equal(t, "grpc.health.v1.HealthCheckRequest", string(m.Input().FullName()))
+	assert.Equal(t, "/grpc.health.v1.Health/Check", c.FullMethod("Check"))
+}
+
+func Test_DynamicClient_InvokeWithJSON(t *testing.T) {
+	// Arrange
+	c := dialHealthClient(t)
+	req, err := c.RequestFromJSON("Check", []byte(`{"service": "echo"}`))
+	require.Nil(t, err)
+
+	// Act
+	res, err := c.Invoke(context.Background(), "Check", req)
+
+	// Assert
+	require.Nil(t, err)
+	b, _ := protojson.Marshal(res)
+	assert.JSONEq(t, `{"status": "NOT_SERVING"}`, string(b))
+}
+
+func Test_CallRpcMethod_ShouldUseDynamicClient(t *testing.T) {
+	// Arrange
+	c := dialHealthClient(t)
+	type checkRequest struct {
+		Service string `json:"service"`
+	}
+
+	// Act
+	res, err := CallRpcMethod(context.Background(), c, "Check", &checkRequest{Service: ""})
+	_, notFound := CallRpcMethod(context.Background(), c, "Check", &checkRequest{Service: "unknown"})
+
+	// Assert
+	require.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"status": "SERVING"}, res)
+	assert.Equal(t, codes.NotFound, status.Code(notFound))
+}
+
+func Test_DynamicClient_ShouldReturnErrors(t *testing.T) {
+	// Arrange
+	addr := startHealthServer(t)
+	c := dialHealthClient(t)
+
+	// Act
+	_, unknownService := DialDynamicClient(context.Background(), addr, "echo.EchoService", gogrpc.WithInsecure())
+	_, unknownMethod := c.Invoke(context.Background(), "Say", `{}`)
+	_, streaming := c.Invoke(context.Background(), "Watch", `{}`)
+	_, badJSON := c.Invoke(context.Background(), "Check", `{"message_id": "1"}`)
+
+	// Assert
+	assert.NotNil(t, unknownService)
+	assert.NotNil(t, unknownMethod)
+	assert.NotNil(t, streaming)
+	assert.NotNil(t, badJSON)
+}
+
+func Test_SetDynamicField_ShouldSetScalarFields(t *testing.T) {
+	// Arrange
+	m := dynamicpb.NewMessage((&testpb.SimpleRequest{}).ProtoReflect().Descriptor())
+
+	// Act
+	fields := DynamicFields(m)
+	errSize := SetDynamicField(m, "response_size", 5)
+	errType := SetDynamicField(m, "responseType", 1)
+	errUser := SetDynamicField(m, "fill_username", true)
+	errMessage := SetDynamicField(m, "payload", "a")
+	errUnknown := SetDynamicField(m, "unknown", "a")
+	errConvert := SetDynamicField(m, "response_size", "a")
+
+	// Assert
+	var names []string
+	for _, f := range fields {
+		names = append(names, f.Name)
+	}
+	assert.Contains(t, names, "response_size")
+	assert.NotContains(t, names, "payload", "message fields cannot be set to a single value")
+	assert.Equal(t, "int32", fields[1].Type.String())
+
+	require.Nil(t, errSize)
+	require.Nil(t, errType)
+	require.Nil(t, errUser)
+	want := &testpb.SimpleRequest{ResponseSize: 5, ResponseType: 1, FillUsername: true}
+	got := &testpb.SimpleRequest{}
+	b, err := proto.Marshal(m)
+	require.Nil(t, err)
+	require.Nil(t, proto.Unmarshal(b, got))
+	assert.True(t, proto.Equal(want, got), got)
+
+	assert.NotNil(t, errMessage)
+	assert.NotNil(t, errUnknown)
+	assert.NotNil(t, errConvert)
+}
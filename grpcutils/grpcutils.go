@@ -2,33 +2,171 @@ package grpc
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"reflect"
+
+	gogrpc "google.golang.org/grpc"
 )
 
 /*
 grpc_helper.go: Helper methods for testing gRPC endpoints
 */
 
+// ErrNilResponse is returned when a method returns neither a response nor an error
+var ErrNilResponse = errors.New("the method returned a nil response without an error")
+
+var (
+	contextType    = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType      = reflect.TypeOf((*error)(nil)).Elem()
+	callOptionType = reflect.TypeOf((*gogrpc.CallOption)(nil)).Elem()
+)
+
 // Calls a grpc method by its name. Returns the response in generic form.
 // client: The grpc client to use
 // methodName: The name of the method to call
 // req: The request casted to generic interface{}
+// opts: Call options passed to the method (e.g. grpc.WaitForReady(true))
 // returns the response casted to generic interface{} and error
-func CallRpcMethod(ctx context.Context, client interface{}, methodName string, req interface{}) (interface{}, error) {
-	var err error
-	m := reflect.ValueOf(client).MethodByName(methodName)
+// An error is returned instead of panicking if the method does not exist or the request has the wrong type
+func CallRpcMethod(ctx context.Context, client interface{}, methodName string, req interface{}, opts ...gogrpc.CallOption) (interface{}, error) {
+	// clients without generated code (e.g. DynamicClient) call the method themselves
+	if invoker, ok := client.(MethodInvoker); ok {
+		return invoker.InvokeMethod(ctx, methodName, req, opts...)
+	}
+
+	m, err := LookupRpcMethod(client, methodName)
+	if err != nil {
+		return nil, err
+	}
+	return m.Call(ctx, req, opts...)
+}
+
+// RpcMethod is a unary method of a generated client that was looked up by its name
+// The signature of the method is checked once by LookupRpcMethod, so it can be called many times (e.g. by the fuzzer)
+type RpcMethod struct {
+	name string
+	fn   reflect.Value
+}
+
+// Returns the unary method of the client with the name
+// Generated unary methods have the signature Method(ctx context.Context, req *Request, opts ...grpc.CallOption) (*Response, error)
+func LookupRpcMethod(client interface{}, methodName string) (*RpcMethod, error) {
+	if client == nil {
+		return nil, fmt.Errorf("cannot call %s because the client is nil", methodName)
+	}
+
+	fn := reflect.ValueOf(client).MethodByName(methodName)
+	if !fn.IsValid() {
+		return nil, fmt.Errorf("method %s was not found in %T", methodName, client)
+	}
+
+	t := fn.Type()
+	if t.NumOut() == 2 && isStream(t.Out(0)) {
+		return nil, fmt.Errorf("method %s is a streaming method, use CallServerStream, CallClientStream or CallBidiStream", methodName)
+	}
+	params := t.NumIn() == 2 || (t.NumIn() == 3 && t.IsVariadic() && t.In(2).Elem() == callOptionType)
+	if !params || t.In(0) != contextType || t.NumOut() != 2 || t.Out(1) != errorType {
+		return nil, fmt.Errorf("method %s is not a unary rpc method: %s", methodName, t)
+	}
+
+	return &RpcMethod{name: methodName, fn: fn}, nil
+}
+
+// Sets fnPtr to the unary method of the client with the name
+// fnPtr must point to a function variable with the same signature as the method, so that calls to it are type checked at compile time:
+//
+//	var say func(context.Context, *pb.SayRequest, ...grpc.CallOption) (*pb.SayResponse, error)
+//	err := BindRpcMethod(client, "Say", &say)
+func BindRpcMethod(client interface{}, methodName string, fnPtr interface{}) error {
+	m, err := LookupRpcMethod(client, methodName)
+	if err != nil {
+		return err
+	}
+
+	v := reflect.ValueOf(fnPtr)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Func {
+		return fmt.Errorf("expected a pointer to a function variable but got %T", fnPtr)
+	}
+	if !m.fn.Type().AssignableTo(v.Elem().Type()) {
+		return fmt.Errorf("method %s has the signature %s but the variable is %s", methodName, m.fn.Type(), v.Elem().Type())
+	}
+
+	v.Elem().Set(m.fn)
+	return nil
+}
+
+// Returns the name of the method
+func (m *RpcMethod) Name() string {
+	return m.name
+}
+
+// Returns the type of the request (e.g. *pb.SayRequest)
+func (m *RpcMethod) RequestType() reflect.Type {
+	return m.fn.Type().In(1)
+}
+
+// Returns the type of the response (e.g. *pb.SayResponse)
+func (m *RpcMethod) ResponseType() reflect.Type {
+	return m.fn.Type().Out(0)
+}
+
+// Returns a new empty request for the method
+func (m *RpcMethod) NewRequest() interface{} {
+	t := m.RequestType()
+	if t.Kind() == reflect.Ptr {
+		return reflect.New(t.Elem()).Interface()
+	}
+	return reflect.Zero(t).Interface()
+}
+
+// Calls the method and returns the response in generic form
+// An error is returned if the request does not have the type of the method, or if the method returns a nil response without an error
+func (m *RpcMethod) Call(ctx context.Context, req interface{}, opts ...gogrpc.CallOption) (interface{}, error) {
+	if ctx == nil {
+		return nil, fmt.Errorf("cannot call %s because the context is nil", m.name)
+	}
+	if req == nil {
+		return nil, fmt.Errorf("cannot call %s because the request is nil", m.name)
+	}
+	if !reflect.TypeOf(req).AssignableTo(m.RequestType()) {
+		return nil, fmt.Errorf("request is %T but %s expects %s", req, m.name, m.RequestType())
+	}
+
 	in := []reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(req)}
-	res := m.Call(in)
+	if len(opts) > 0 && !m.fn.Type().IsVariadic() {
+		return nil, fmt.Errorf("method %s does not take call options", m.name)
+	}
+	for i := range opts {
+		// use the interface type so that nil options do not panic
+		in = append(in, reflect.ValueOf(&opts[i]).Elem())
+	}
+
+	out := m.fn.Call(in)
 
-	// most rpc methods return two items (response and error) but check the length in case
-	if len(res) != 2 {
-		return nil, fmt.Errorf("expected 2 return items but got %d", len(res))
+	var err error
+	if e := out[1].Interface(); e != nil {
+		err = e.(error)
 	}
 
-	if res[1].Interface() != nil {
-		err = res[1].Interface().(error)
+	// typed nil pointers would otherwise be returned as non-nil interface{} values
+	if isNil(out[0]) {
+		if err == nil {
+			err = fmt.Errorf("%s: %w", m.name, ErrNilResponse)
+		}
+		return nil, err
 	}
+	return out[0].Interface(), err
+}
 
-	return res[0].Interface(), err
+// Returns true if the value is nil or a nil pointer, interface, map, slice, func or chan
+func isNil(v reflect.Value) bool {
+	if !v.IsValid() {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return v.IsNil()
+	}
+	return false
 }
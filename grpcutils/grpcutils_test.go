@@ -0,0 +1,112 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gogrpc "google.golang.org/grpc"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+)
+
+// a client with the same method signatures as generated clients
+type fakeTestClient struct {
+	options int // the number of call options passed to the last call
+}
+
+func (c *fakeTestClient) UnaryCall(ctx context.Context, req *testpb.SimpleRequest, opts ...gogrpc.CallOption) (*testpb.SimpleResponse, error) {
+	c.options = len(opts)
+	switch req.ResponseSize {
+	case 0:
+		return nil, nil
+	case -1:
+		return nil, errors.New("failed")
+	}
+	return &testpb.SimpleResponse{Payload: &testpb.Payload{Body: make([]byte, req.ResponseSize)}}, nil
+}
+
+func (c *fakeTestClient) NotAnRpc(req string) string {
+	return req
+}
+
+func Test_CallRpcMethod_ShouldPassCallOptions(t *testing.T) {
+	// Arrange
+	c := &fakeTestClient{}
+
+	// Act
+	res, err := CallRpcMethod(context.Background(), c, "UnaryCall", &testpb.SimpleRequest{ResponseSize: 2}, gogrpc.WaitForReady(true), gogrpc.MaxCallRecvMsgSize(10))
+
+	// Assert
+	require.Nil(t, err)
+	assert.Len(t, res.(*testpb.SimpleResponse).Payload.Body, 2)
+	assert.Equal(t, 2, c.options)
+}
+
+func Test_CallRpcMethod_ShouldReturnErrorsInsteadOfPanicking(t *testing.T) {
+	// Arrange
+	c := &fakeTestClient{}
+	ctx := context.Background()
+
+	// Act
+	_, typo := CallRpcMethod(ctx, c, "UnaryCal", &testpb.SimpleRequest{})
+	_, notRpc := CallRpcMethod(ctx, c, "NotAnRpc", "a")
+	_, wrongRequest := CallRpcMethod(ctx, c, "UnaryCall", &testpb.Empty{})
+	_, nilRequest := CallRpcMethod(ctx, c, "UnaryCall", nil)
+	_, nilClient := CallRpcMethod(ctx, nil, "UnaryCall", &testpb.SimpleRequest{})
+	_, streaming := CallRpcMethod(ctx, testpb.NewTestServiceClient(nil), "FullDuplexCall", &testpb.SimpleRequest{})
+
+	// Assert
+	assert.EqualError(t, typo, "method UnaryCal was not found in *grpc.fakeTestClient")
+	assert.NotNil(t, notRpc)
+	assert.EqualError(t, wrongRequest, "request is *grpc_testing.Empty but UnaryCall expects *grpc_testing.SimpleRequest")
+	assert.NotNil(t, nilRequest)
+	assert.NotNil(t, nilClient)
+	assert.NotNil(t, streaming)
+}
+
+func Test_CallRpcMethod_NilResponses(t *testing.T) {
+	// Arrange
+	c := &fakeTestClient{}
+
+	// Act
+	nilRes, nilErr := CallRpcMethod(context.Background(), c, "UnaryCall", &testpb.SimpleRequest{ResponseSize: 0})
+	failedRes, failedErr := CallRpcMethod(context.Background(), c, "UnaryCall", &testpb.SimpleRequest{ResponseSize: -1})
+
+	// Assert
+	assert.Nil(t, nilRes)
+	assert.True(t, errors.Is(nilErr, ErrNilResponse))
+	assert.Nil(t, failedRes) // an untyped nil, not a nil *SimpleResponse
+	assert.EqualError(t, failedErr, "failed")
+}
+
+func Test_LookupRpcMethod(t *testing.T) {
+	// Act
+	m, err := LookupRpcMethod(&fakeTestClient{}, "UnaryCall")
+
+	// Assert
+	require.Nil(t, err)
+	assert.Equal(t, "UnaryCall", m.Name())
+	assert.Equal(t, &testpb.SimpleRequest{}, m.NewRequest())
+	assert.Equal(t, "*grpc_testing.SimpleResponse", m.ResponseType().String())
+}
+
+func Test_BindRpcMethod_ShouldSetTypedFunction(t *testing.T) {
+	// Arrange
+	var call func(context.Context, *testpb.SimpleRequest, ...gogrpc.CallOption) (*testpb.SimpleResponse, error)
+	var wrong func(context.Context, *testpb.Empty) (*testpb.Empty, error)
+
+	// Act
+	err := BindRpcMethod(&fakeTestClient{}, "UnaryCall", &call)
+	wrongErr := BindRpcMethod(&fakeTestClient{}, "UnaryCall", &wrong)
+	notPointer := BindRpcMethod(&fakeTestClient{}, "UnaryCall", call)
+
+	// Assert
+	require.Nil(t, err)
+	res, err := call(context.Background(), &testpb.SimpleRequest{ResponseSize: 1})
+	require.Nil(t, err)
+	assert.Len(t, res.Payload.Body, 1)
+	assert.NotNil(t, wrongErr)
+	assert.NotNil(t, notPointer)
+}
@@ -0,0 +1,83 @@
+package grpc
+
+import (
+	"context"
+	"sort"
+
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+/*
+metadata.go: Calls grpc methods with outgoing metadata and captures the headers, trailers, status and peer of the response
+*/
+
+// CallOptions are the options of CallRpcMethodWithMetadata
+type CallOptions struct {
+	Metadata map[string]string   // outgoing metadata sent with the request (e.g. authorization, x-request-id)
+	Options  []gogrpc.CallOption // other call options passed to the method (e.g. grpc.WaitForReady(true))
+}
+
+// CallResult is the response of a method together with the metadata of the call
+type CallResult struct {
+	Response interface{}    // the response, nil if the call failed
+	Header   metadata.MD    // the header metadata sent by the server
+	Trailer  metadata.MD    // the trailer metadata sent by the server
+	Status   *status.Status // the status of the call (codes.OK if it succeeded)
+	Details  []interface{}  // the details attached to the status (e.g. errdetails.BadRequest)
+	Peer     string         // the address of the server that handled the call
+}
+
+// Calls a grpc method by its name like CallRpcMethod, and returns the response with the headers, trailers, status and peer of the call
+// The result is returned even if the call fails, so that the trailers and status details of errors can be checked
+// Clients must accept call options, which generated clients and DynamicClient do
+func CallRpcMethodWithMetadata(ctx context.Context, client interface{}, methodName string, req interface{}, opts ...CallOptions) (*CallResult, error) {
+	var o CallOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+
+	// sort the keys so that the metadata is always sent in the same order
+	var keys []string
+	for k := range o.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		ctx = metadata.AppendToOutgoingContext(ctx, k, o.Metadata[k])
+	}
+
+	res := &CallResult{}
+	var p peer.Peer
+	callOpts := append([]gogrpc.CallOption{}, o.Options...)
+	callOpts = append(callOpts, gogrpc.Header(&res.Header), gogrpc.Trailer(&res.Trailer), gogrpc.Peer(&p))
+
+	var err error
+	res.Response, err = CallRpcMethod(ctx, client, methodName, req, callOpts...)
+
+	res.Status = status.Convert(err)
+	res.Details = res.Status.Details()
+	if p.Addr != nil {
+		res.Peer = p.Addr.String()
+	}
+	return res, err
+}
+
+// Returns the first value of the header with the key, or an empty string if the server did not send it
+func (r *CallResult) HeaderValue(key string) string {
+	return firstValue(r.Header, key)
+}
+
+// Returns the first value of the trailer with the key, or an empty string if the server did not send it
+func (r *CallResult) TrailerValue(key string) string {
+	return firstValue(r.Trailer, key)
+}
+
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
@@ -0,0 +1,81 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// a test service that returns the request id as a header and the remaining rate limit as a trailer
+type metadataServer struct {
+	testpb.UnimplementedTestServiceServer
+}
+
+func (metadataServer) UnaryCall(ctx context.Context, req *testpb.SimpleRequest) (*testpb.SimpleResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	gogrpc.SetHeader(ctx, metadata.MD{"x-request-id": md.Get("x-request-id")})
+	gogrpc.SetTrailer(ctx, metadata.Pairs("x-ratelimit-remaining", "9"))
+
+	if md.Get("authorization") == nil {
+		st, _ := status.New(codes.Unauthenticated, "no token").WithDetails(&testpb.Payload{Body: []byte("login")})
+		return nil, st.Err()
+	}
+	return &testpb.SimpleResponse{Username: md.Get("authorization")[0]}, nil
+}
+
+func Test_CallRpcMethodWithMetadata_ShouldCaptureHeadersAndTrailers(t *testing.T) {
+	// Arrange
+	client := testpb.NewTestServiceClient(dialTestServer(t, metadataServer{}))
+	opts := CallOptions{Metadata: map[string]string{"authorization": "bearer test", "x-request-id": "req-1"}}
+
+	// Act
+	res, err := CallRpcMethodWithMetadata(context.Background(), client, "UnaryCall", &testpb.SimpleRequest{}, opts)
+
+	// Assert
+	require.Nil(t, err)
+	assert.Equal(t, "bearer test", res.Response.(*testpb.SimpleResponse).Username)
+	assert.Equal(t, "req-1", res.HeaderValue("x-request-id"))
+	assert.Equal(t, "9", res.TrailerValue("x-ratelimit-remaining"))
+	assert.Equal(t, codes.OK, res.Status.Code())
+	assert.Empty(t, res.Details)
+	assert.Contains(t, res.Peer, "127.0.0.1:")
+}
+
+func Test_CallRpcMethodWithMetadata_ShouldReturnStatusDetails(t *testing.T) {
+	// Arrange
+	client := testpb.NewTestServiceClient(dialTestServer(t, metadataServer{}))
+
+	// Act
+	res, err := CallRpcMethodWithMetadata(context.Background(), client, "UnaryCall", &testpb.SimpleRequest{})
+
+	// Assert
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	require.NotNil(t, res)
+	assert.Nil(t, res.Response)
+	assert.Equal(t, "no token", res.Status.Message())
+	require.Len(t, res.Details, 1)
+	assert.True(t, proto.Equal(&testpb.Payload{Body: []byte("login")}, res.Details[0].(proto.Message)))
+	assert.Equal(t, "9", res.TrailerValue("x-ratelimit-remaining"))
+}
+
+func Test_CallRpcMethodWithMetadata_ShouldWorkWithDynamicClient(t *testing.T) {
+	// Arrange
+	c, err := NewDynamicClient(context.Background(), dialTestServer(t, metadataServer{}), "grpc.testing.TestService")
+	require.Nil(t, err)
+
+	// Act
+	res, err := CallRpcMethodWithMetadata(context.Background(), c, "UnaryCall", `{}`, CallOptions{Metadata: map[string]string{"authorization": "a"}})
+
+	// Assert
+	require.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"username": "a"}, res.Response)
+	assert.Equal(t, "9", res.TrailerValue("x-ratelimit-remaining"))
+}
@@ -0,0 +1,173 @@
+package grpc
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/testdata"
+)
+
+// An HTTP CONNECT proxy that records the requests it received
+type connectProxy struct {
+	addr   string
+	status int // the status of the CONNECT responses
+
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+func startConnectProxy(t *testing.T, status int) *connectProxy {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { lis.Close() })
+
+	p := &connectProxy{addr: lis.Addr().String(), status: status}
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go p.handle(conn)
+		}
+	}()
+	return p
+}
+
+func (p *connectProxy) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	req, err := http.ReadRequest(r)
+	if err != nil {
+		return
+	}
+	p.mu.Lock()
+	p.requests = append(p.requests, req)
+	p.mu.Unlock()
+
+	if p.status != http.StatusOK {
+		io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+		return
+	}
+	target, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		return
+	}
+	defer target.Close()
+	io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+
+	go io.Copy(target, r)
+	io.Copy(conn, target)
+}
+
+func (p *connectProxy) received() []*http.Request {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*http.Request(nil), p.requests...)
+}
+
+// Calls the metadata server through the connection
+func unaryCall(conn *gogrpc.ClientConn) error {
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "bearer test")
+	_, err := testpb.NewTestServiceClient(conn).UnaryCall(ctx, &testpb.SimpleRequest{})
+	return err
+}
+
+func Test_Dial_ShouldConnectThroughProxy(t *testing.T) {
+	// Arrange
+	target := startMetadataServer(t)
+	proxy := startConnectProxy(t, http.StatusOK)
+	conn, err := Dial(context.Background(), ConnConfig{Target: target, Insecure: true, Proxy: "http://user:secret@" + proxy.addr})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	// Act
+	err = unaryCall(conn)
+
+	// Assert
+	require.Nil(t, err)
+	requests := proxy.received()
+	require.Len(t, requests, 1)
+	assert.Equal(t, http.MethodConnect, requests[0].Method)
+	assert.Equal(t, target, requests[0].Host)
+	assert.Equal(t, "Basic dXNlcjpzZWNyZXQ=", requests[0].Header.Get("Proxy-Authorization"))
+}
+
+func Test_ConnectToProxy_ShouldBeUsedByDial(t *testing.T) {
+	// Arrange
+	target := startMetadataServer(t)
+	proxy := startConnectProxy(t, http.StatusOK)
+	require.Nil(t, ConnectToProxy("http://"+proxy.addr))
+	defer DisconnectFromProxy()
+
+	// Act
+	conn, err := Dial(context.Background(), ConnConfig{Target: target, Insecure: true})
+	require.Nil(t, err)
+	defer conn.Close()
+	err = unaryCall(conn)
+
+	// Assert
+	require.Nil(t, err)
+	assert.Len(t, proxy.received(), 1)
+}
+
+func Test_Dial_ShouldFailWhenProxyRefuses(t *testing.T) {
+	target := startMetadataServer(t)
+	proxy := startConnectProxy(t, http.StatusProxyAuthRequired)
+	conn, err := Dial(context.Background(), ConnConfig{Target: target, Insecure: true, Proxy: "http://" + proxy.addr})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	err = unaryCall(conn)
+
+	assert.NotNil(t, err)
+}
+
+func Test_Dial_ShouldTrustProxyCACert(t *testing.T) {
+	// Arrange
+	// the certificate of the server is signed by the test CA, like the certificates made by a debugging proxy that intercepts TLS
+	creds, err := credentials.NewServerTLSFromFile(testdata.Path("x509/server1_cert.pem"), testdata.Path("x509/server1_key.pem"))
+	require.Nil(t, err)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	s := gogrpc.NewServer(gogrpc.Creds(creds))
+	testpb.RegisterTestServiceServer(s, metadataServer{})
+	go s.Serve(lis)
+	defer s.Stop()
+	proxy := startConnectProxy(t, http.StatusOK)
+
+	call := func(c ConnConfig) error {
+		conn, err := Dial(context.Background(), c)
+		require.Nil(t, err)
+		defer conn.Close()
+		return unaryCall(conn)
+	}
+	c := ConnConfig{Target: lis.Addr().String(), ServerName: "x.test.example.com", Proxy: "http://" + proxy.addr}
+
+	// Act
+	untrusted := call(c)
+	c.ProxyCACertFile = testdata.Path("x509/server_ca_cert.pem")
+	trusted := call(c)
+
+	// Assert
+	assert.NotNil(t, untrusted)
+	assert.Nil(t, trusted)
+}
+
+func Test_ConnectToProxy_ShouldRejectInvalidProxies(t *testing.T) {
+	assert.NotNil(t, ConnectToProxy("socks5://localhost:1080"))
+	assert.NotNil(t, ConnectToProxy("http://localhost:8080", "missing.pem"))
+	_, err := ConnConfig{Target: "localhost:1", Proxy: "localhost:8080"}.Options()
+	assert.NotNil(t, err)
+}
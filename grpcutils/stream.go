@@ -0,0 +1,298 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+/*
+stream.go: Helpers for calling server-streaming, client-streaming and bidirectional streaming methods by name
+*/
+
+// ErrStreamClosed is the error of messages that were not sent because the server closed the stream first
+var ErrStreamClosed = errors.New("the stream was closed by the server before the message was sent")
+
+// Stream is a client stream of any type of streaming method
+type Stream interface {
+	Send(req interface{}) error
+	Recv() (interface{}, error)
+	CloseSend() error
+}
+
+// StreamOpener is implemented by clients that can open streams by method name without generated code (e.g. DynamicClient)
+type StreamOpener interface {
+	OpenStream(ctx context.Context, methodName string) (Stream, error)
+}
+
+// SentMessage is the result of sending one message of a stream
+type SentMessage struct {
+	Index   int         // the position of the message in the sequence
+	Request interface{} // the message that was sent
+	Err     error       // the error of sending the message (ErrStreamClosed if the server closed the stream first)
+}
+
+// StreamResult is the result of a streaming call
+type StreamResult struct {
+	Sent        []SentMessage // one for each message of the sequence, in order
+	Responses   []interface{} // all responses received from the server, in order
+	Err         error         // the error the stream ended with (e.g. a status error), nil if it ended normally
+	ClosedEarly bool          // the server closed the stream before all messages were sent
+}
+
+// Returns the messages that could not be sent
+func (r *StreamResult) Failed() []SentMessage {
+	var failed []SentMessage
+	for _, m := range r.Sent {
+		if m.Err != nil {
+			failed = append(failed, m)
+		}
+	}
+	return failed
+}
+
+// Calls a server-streaming method by its name and collects all responses
+// client: The grpc client to use (a generated client or a StreamOpener such as DynamicClient)
+// methodName: The name of the method to call
+// req: The request
+// returns an error only if the stream could not be opened, errors of the stream itself are in the result
+func CallServerStream(ctx context.Context, client interface{}, methodName string, req interface{}) (*StreamResult, error) {
+	s, err := openStream(ctx, client, methodName, req)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &StreamResult{Sent: []SentMessage{{Index: 0, Request: req}}}
+	res.Responses, res.Err = recvAll(s)
+	return res, nil
+}
+
+// Calls a client-streaming method by its name, sends the messages in order and receives the response
+// returns an error only if the stream could not be opened, errors of the stream itself are in the result
+func CallClientStream(ctx context.Context, client interface{}, methodName string, reqs []interface{}) (*StreamResult, error) {
+	s, err := openStream(ctx, client, methodName, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &StreamResult{}
+	sendAll(s, reqs, res, nil)
+	s.CloseSend()
+
+	// if the server closed the stream early, its status is returned here
+	r, err := s.Recv()
+	if err != nil {
+		res.Err = err
+	} else {
+		res.Responses = []interface{}{r}
+	}
+	return res, nil
+}
+
+// Calls a bidirectional streaming method by its name, sends the messages in order and collects all responses
+// Responses are received while the messages are sent, so servers that reply to each message do not block
+// returns an error only if the stream could not be opened, errors of the stream itself are in the result
+func CallBidiStream(ctx context.Context, client interface{}, methodName string, reqs []interface{}) (*StreamResult, error) {
+	s, err := openStream(ctx, client, methodName, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &StreamResult{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		res.Responses, res.Err = recvAll(s)
+	}()
+
+	sendAll(s, reqs, res, done)
+	s.CloseSend()
+	<-done
+	return res, nil
+}
+
+// Sends the messages in order and records the result of each one
+// closed is closed when the stream has ended, so the remaining messages are not sent (nil if it is not known)
+func sendAll(s Stream, reqs []interface{}, res *StreamResult, closed <-chan struct{}) {
+	for i, req := range reqs {
+		m := SentMessage{Index: i, Request: req}
+		select {
+		case <-closed:
+			res.ClosedEarly = true
+		default:
+		}
+
+		if res.ClosedEarly {
+			m.Err = ErrStreamClosed
+		} else if err := s.Send(req); err == io.EOF {
+			// gRPC returns io.EOF when the server has already closed the stream
+			res.ClosedEarly = true
+			m.Err = ErrStreamClosed
+		} else if err != nil {
+			m.Err = err
+		}
+		res.Sent = append(res.Sent, m)
+	}
+}
+
+// Receives responses until the stream ends, returns the error that ended it or nil if it ended normally
+func recvAll(s Stream) ([]interface{}, error) {
+	var responses []interface{}
+	for {
+		r, err := s.Recv()
+		if err == io.EOF {
+			return responses, nil
+		}
+		if err != nil {
+			return responses, err
+		}
+		responses = append(responses, r)
+	}
+}
+
+// Opens a stream of the method
+// req is only passed to server-streaming methods, which take the request when the stream is opened
+func openStream(ctx context.Context, client interface{}, methodName string, req interface{}) (Stream, error) {
+	if opener, ok := client.(StreamOpener); ok {
+		s, err := opener.OpenStream(ctx, methodName)
+		if err != nil || req == nil {
+			return s, err
+		}
+		if err := s.Send(req); err != nil {
+			return nil, err
+		}
+		return s, s.CloseSend()
+	}
+
+	m := reflect.ValueOf(client).MethodByName(methodName)
+	if !m.IsValid() {
+		return nil, fmt.Errorf("method %s was not found in %T", methodName, client)
+	}
+
+	// generated stream methods are Method(ctx, opts...) or Method(ctx, req, opts...) for server-streaming methods
+	mt := m.Type()
+	in := []reflect.Value{reflect.ValueOf(ctx)}
+	switch {
+	case !mt.IsVariadic() || mt.NumOut() != 2 || !isStream(mt.Out(0)):
+		return nil, fmt.Errorf("method %s is not a streaming method", methodName)
+	case mt.NumIn() == 2 && req != nil:
+		return nil, fmt.Errorf("method %s does not take a request, use CallClientStream or CallBidiStream", methodName)
+	case mt.NumIn() == 3 && req == nil:
+		return nil, fmt.Errorf("method %s is a server-streaming method, use CallServerStream", methodName)
+	case mt.NumIn() == 3:
+		if !reflect.TypeOf(req).AssignableTo(mt.In(1)) {
+			return nil, fmt.Errorf("request is %T but %s expects %s", req, methodName, mt.In(1))
+		}
+		in = append(in, reflect.ValueOf(req))
+	case mt.NumIn() != 2:
+		return nil, fmt.Errorf("method %s is not a streaming method", methodName)
+	}
+
+	out := m.Call(in)
+	if err, _ := out[1].Interface().(error); err != nil {
+		return nil, err
+	}
+	return &reflectStream{v: out[0]}, nil
+}
+
+// Returns true if the type is a generated client stream (they all embed grpc.ClientStream)
+func isStream(t reflect.Type) bool {
+	_, ok := t.MethodByName("CloseSend")
+	return ok
+}
+
+// A stream of a generated client (e.g. Echo_SayStreamClient)
+type reflectStream struct {
+	v reflect.Value
+}
+
+func (s *reflectStream) Send(req interface{}) error {
+	m := s.v.MethodByName("Send")
+	if !m.IsValid() {
+		return fmt.Errorf("%s does not send messages", s.v.Type())
+	}
+	if req == nil || !reflect.TypeOf(req).AssignableTo(m.Type().In(0)) {
+		return fmt.Errorf("message is %T but the stream expects %s", req, m.Type().In(0))
+	}
+	err, _ := m.Call([]reflect.Value{reflect.ValueOf(req)})[0].Interface().(error)
+	return err
+}
+
+// Client-streaming streams do not have Recv, so their single response is received with CloseAndRecv
+func (s *reflectStream) Recv() (interface{}, error) {
+	m := s.v.MethodByName("Recv")
+	if !m.IsValid() {
+		m = s.v.MethodByName("CloseAndRecv")
+	}
+	if !m.IsValid() {
+		return nil, fmt.Errorf("%s does not receive messages", s.v.Type())
+	}
+	out := m.Call(nil)
+	err, _ := out[1].Interface().(error)
+	return out[0].Interface(), err
+}
+
+func (s *reflectStream) CloseSend() error {
+	err, _ := s.v.MethodByName("CloseSend").Call(nil)[0].Interface().(error)
+	return err
+}
+
+// ----------
+// dynamic client
+// ----------
+
+// Opens a stream of a streaming method of the service
+// Messages can be anything that Invoke accepts, and responses are returned decoded from their JSON form like InvokeMethod
+func (c *DynamicClient) OpenStream(ctx context.Context, methodName string) (Stream, error) {
+	m, err := c.Method(methodName)
+	if err != nil {
+		return nil, err
+	}
+	if !m.IsStreamingClient() && !m.IsStreamingServer() {
+		return nil, fmt.Errorf("method %s is not a streaming method", methodName)
+	}
+
+	desc := &gogrpc.StreamDesc{
+		StreamName:    methodName,
+		ClientStreams: m.IsStreamingClient(),
+		ServerStreams: m.IsStreamingServer(),
+	}
+	cs, err := c.conn.NewStream(ctx, desc, c.FullMethod(methodName))
+	if err != nil {
+		return nil, err
+	}
+	return &dynamicStream{client: c, method: m, stream: cs}, nil
+}
+
+// A stream of a DynamicClient
+type dynamicStream struct {
+	client *DynamicClient
+	method protoreflect.MethodDescriptor
+	stream gogrpc.ClientStream
+}
+
+func (s *dynamicStream) Send(req interface{}) error {
+	in, err := s.client.toRequest(s.method, req)
+	if err != nil {
+		return err
+	}
+	return s.stream.SendMsg(in)
+}
+
+func (s *dynamicStream) Recv() (interface{}, error) {
+	out := dynamicpb.NewMessage(s.method.Output())
+	if err := s.stream.RecvMsg(out); err != nil {
+		return nil, err
+	}
+	return decodeMessage(out)
+}
+
+func (s *dynamicStream) CloseSend() error {
+	return s.stream.CloseSend()
+}
@@ -0,0 +1,200 @@
+package grpc
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// a test service that streams one response per requested size, and closes streams with an error when it receives "stop"
+type streamingServer struct {
+	testpb.UnimplementedTestServiceServer
+}
+
+func (streamingServer) StreamingOutputCall(req *testpb.StreamingOutputCallRequest, stream testpb.TestService_StreamingOutputCallServer) error {
+	for _, p := range req.ResponseParameters {
+		if err := stream.Send(&testpb.StreamingOutputCallResponse{Payload: &testpb.Payload{Body: make([]byte, p.Size)}}); err != nil {
+			return err
+		}
+	}
+	if string(req.GetPayload().GetBody()) == "stop" {
+		return status.Error(codes.Aborted, "stopped")
+	}
+	return nil
+}
+
+func (streamingServer) StreamingInputCall(stream testpb.TestService_StreamingInputCallServer) error {
+	var size int32
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&testpb.StreamingInputCallResponse{AggregatedPayloadSize: size})
+		}
+		if err != nil {
+			return err
+		}
+		if string(req.GetPayload().GetBody()) == "stop" {
+			return status.Error(codes.InvalidArgument, "stopped")
+		}
+		size += int32(len(req.GetPayload().GetBody()))
+	}
+}
+
+func (streamingServer) FullDuplexCall(stream testpb.TestService_FullDuplexCallServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if string(req.GetPayload().GetBody()) == "stop" {
+			return status.Error(codes.InvalidArgument, "stopped")
+		}
+		if err := stream.Send(&testpb.StreamingOutputCallResponse{Payload: req.Payload}); err != nil {
+			return err
+		}
+	}
+}
+
+// starts the test service with server reflection and returns a connection to it
+func dialTestServer(t *testing.T, srv testpb.TestServiceServer) *gogrpc.ClientConn {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+
+	s := gogrpc.NewServer()
+	testpb.RegisterTestServiceServer(s, srv)
+	reflection.Register(s)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := gogrpc.Dial(lis.Addr().String(), gogrpc.WithInsecure())
+	require.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func payload(body string) *testpb.Payload {
+	return &testpb.Payload{Body: []byte(body)}
+}
+
+func Test_CallServerStream_ShouldCollectAllResponses(t *testing.T) {
+	// Arrange
+	client := testpb.NewTestServiceClient(dialTestServer(t, streamingServer{}))
+	req := &testpb.StreamingOutputCallRequest{ResponseParameters: []*testpb.ResponseParameters{{Size: 1}, {Size: 2}, {Size: 3}}}
+
+	// Act
+	res, err := CallServerStream(context.Background(), client, "StreamingOutputCall", req)
+
+	// Assert
+	require.Nil(t, err)
+	assert.Nil(t, res.Err)
+	require.Len(t, res.Responses, 3)
+	assert.Len(t, res.Responses[2].(*testpb.StreamingOutputCallResponse).Payload.Body, 3)
+}
+
+func Test_CallServerStream_ShouldReturnStreamError(t *testing.T) {
+	// Arrange
+	client := testpb.NewTestServiceClient(dialTestServer(t, streamingServer{}))
+	req := &testpb.StreamingOutputCallRequest{ResponseParameters: []*testpb.ResponseParameters{{Size: 1}}, Payload: payload("stop")}
+
+	// Act
+	res, err := CallServerStream(context.Background(), client, "StreamingOutputCall", req)
+
+	// Assert
+	require.Nil(t, err)
+	assert.Len(t, res.Responses, 1)
+	assert.Equal(t, codes.Aborted, status.Code(res.Err))
+}
+
+func Test_CallClientStream_ShouldSendAllMessages(t *testing.T) {
+	// Arrange
+	client := testpb.NewTestServiceClient(dialTestServer(t, streamingServer{}))
+	reqs := []interface{}{
+		&testpb.StreamingInputCallRequest{Payload: payload("a")},
+		&testpb.StreamingInputCallRequest{Payload: payload("bc")},
+	}
+
+	// Act
+	res, err := CallClientStream(context.Background(), client, "StreamingInputCall", reqs)
+
+	// Assert
+	require.Nil(t, err)
+	assert.Nil(t, res.Err)
+	assert.Empty(t, res.Failed())
+	require.Len(t, res.Responses, 1)
+	assert.Equal(t, int32(3), res.Responses[0].(*testpb.StreamingInputCallResponse).AggregatedPayloadSize)
+}
+
+func Test_CallBidiStream_ShouldReportEarlyClose(t *testing.T) {
+	// Arrange
+	client := testpb.NewTestServiceClient(dialTestServer(t, streamingServer{}))
+	reqs := []interface{}{&testpb.StreamingOutputCallRequest{Payload: payload("a")}, &testpb.StreamingOutputCallRequest{Payload: payload("stop")}}
+
+	// messages are buffered until the flow control window is full, so send more than fits
+	for i := 0; i < 100; i++ {
+		reqs = append(reqs, &testpb.StreamingOutputCallRequest{Payload: &testpb.Payload{Body: make([]byte, 64*1024)}})
+	}
+
+	// Act
+	res, err := CallBidiStream(context.Background(), client, "FullDuplexCall", reqs)
+
+	// Assert
+	require.Nil(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(res.Err))
+	require.Len(t, res.Responses, 1)
+	assert.Len(t, res.Sent, len(reqs))
+	assert.True(t, res.ClosedEarly)
+	failed := res.Failed()
+	require.NotEmpty(t, failed)
+	assert.Equal(t, ErrStreamClosed, failed[len(failed)-1].Err)
+}
+
+func Test_DynamicClient_ShouldOpenStreams(t *testing.T) {
+	// Arrange
+	c, err := NewDynamicClient(context.Background(), dialTestServer(t, streamingServer{}), "grpc.testing.TestService")
+	require.Nil(t, err)
+
+	// Act
+	server, serverErr := CallServerStream(context.Background(), c, "StreamingOutputCall", `{"responseParameters": [{"size": 1}, {"size": 2}]}`)
+	bidi, bidiErr := CallBidiStream(context.Background(), c, "FullDuplexCall", []interface{}{`{"payload": {"body": "YQ=="}}`, `{"unknown": 1}`})
+	_, unary := c.OpenStream(context.Background(), "UnaryCall")
+
+	// Assert
+	require.Nil(t, serverErr)
+	assert.Nil(t, server.Err)
+	assert.Len(t, server.Responses, 2)
+	require.Nil(t, bidiErr)
+	assert.Equal(t, []interface{}{map[string]interface{}{"payload": map[string]interface{}{"body": "YQ=="}}}, bidi.Responses)
+	require.Len(t, bidi.Failed(), 1)
+	assert.Equal(t, 1, bidi.Failed()[0].Index)
+	assert.NotNil(t, unary)
+}
+
+func Test_CallStream_ShouldReturnErrorsForWrongMethods(t *testing.T) {
+	// Arrange
+	client := testpb.NewTestServiceClient(dialTestServer(t, streamingServer{}))
+	ctx := context.Background()
+
+	// Act
+	_, unknown := CallBidiStream(ctx, client, "Say", nil)
+	_, unary := CallServerStream(ctx, client, "UnaryCall", &testpb.SimpleRequest{})
+	_, noRequest := CallClientStream(ctx, client, "StreamingOutputCall", nil)
+	_, wrongRequest := CallServerStream(ctx, client, "StreamingOutputCall", &testpb.SimpleRequest{})
+
+	// Assert
+	assert.NotNil(t, unknown)
+	assert.NotNil(t, unary)
+	assert.NotNil(t, noRequest)
+	assert.NotNil(t, wrongRequest)
+}
@@ -1,12 +1,16 @@
 package testdeck
 
 import (
+	"context"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/mercari/testdeck/constants"
 	"github.com/mercari/testdeck/deferrer"
+	"github.com/mercari/testdeck/httputils"
+	"github.com/mercari/testdeck/recorder"
 	"github.com/mercari/testdeck/runner"
 )
 
@@ -36,10 +40,15 @@ type TestConfig struct {
 type TD struct {
 	T                TestingT // wrapper on testing.T
 	fatal            bool
-	currentLifecycle string
+	currentLifecycle string // read by the gRPC recorder from other goroutines, so it is guarded by lifecycleMu
+	lifecycleMu      sync.RWMutex
 	statuses         []constants.Status // stack of statuses; statuses are emitted by Error/Fatal operation or when the lifecycle completes successfully
 	timings          map[string]constants.Timing
-	actualName       string // name of testdeck test case (to pass to testing.T)
+	actualName       string             // name of testdeck test case (to pass to testing.T)
+	recorder         *recorder.Recorder // records the gRPC calls made with Context()
+	recorderOnce     sync.Once
+	httpClient       *httputils.Client // HTTP session shared by the lifecycle stages
+	httpClientOnce   sync.Once
 }
 
 // An interface for testdeck test cases; it is implemented by the TestCase struct below
@@ -131,7 +140,7 @@ func Test(t TestingT, tc TestCaseDelegate, options ...TestConfig) *TD {
 		if !td.Skipped() || arrangeComplete {
 			tc.AfterMethod(td)
 		}
-		td.currentLifecycle = constants.LifecycleTestFinished
+		td.setLifecycle(constants.LifecycleTestFinished)
 
 		// add the final status so it is clear the test finished
 		if len(td.statuses) == 0 {
@@ -162,6 +171,48 @@ func Test(t TestingT, tc TestCaseDelegate, options ...TestConfig) *TD {
 	return td
 }
 
+// Context returns a context that records the gRPC calls made with it, so that they are saved with the statistics of the test case
+// Calls are recorded on connections made by grpcutils (e.g. grpcutils.Connect) or dialed with recorder.DialOptions()
+func (c *TD) Context() context.Context {
+	return recorder.NewContext(context.Background(), c.rpcRecorder())
+}
+
+// RPCs returns the gRPC calls made with Context() so far
+func (c *TD) RPCs() []constants.RPCRecord {
+	return c.rpcRecorder().Records()
+}
+
+// HTTPClient returns an HTTP client that is shared by the lifecycle stages of the test case, so that cookies set in Arrange (e.g. by logging in) are sent in Act
+// It has the settings of httputils.DefaultClient and its own cookie jar
+func (c *TD) HTTPClient() *httputils.Client {
+	c.httpClientOnce.Do(func() {
+		c.httpClient = httputils.DefaultClient.NewSession()
+	})
+	return c.httpClient
+}
+
+// Returns the lifecycle stage that is running
+// gRPC calls can be recorded from goroutines started by the test, so the stage is read under a lock
+func (c *TD) lifecycle() string {
+	c.lifecycleMu.RLock()
+	defer c.lifecycleMu.RUnlock()
+	return c.currentLifecycle
+}
+
+// Sets the lifecycle stage that is running
+func (c *TD) setLifecycle(lifecycle string) {
+	c.lifecycleMu.Lock()
+	defer c.lifecycleMu.Unlock()
+	c.currentLifecycle = lifecycle
+}
+
+func (c *TD) rpcRecorder() *recorder.Recorder {
+	c.recorderOnce.Do(func() {
+		c.recorder = recorder.New(c.lifecycle)
+	})
+	return c.recorder
+}
+
 // -----
 // Statistics
 // -----
@@ -177,6 +228,7 @@ func (c *TD) makeStatistics(start time.Time, end time.Time) *constants.Statistic
 		Start:    start,
 		End:      end,
 		Duration: end.Sub(start),
+		RPCs:     c.rpcRecorder().Records(),
 	}
 }
 
@@ -184,7 +236,7 @@ func (c *TD) makeStatistics(start time.Time, end time.Time) *constants.Statistic
 func (c *TD) setPassed() {
 	status := constants.Status{
 		Status:    constants.StatusPass,
-		Lifecycle: c.currentLifecycle,
+		Lifecycle: c.lifecycle(),
 		Fatal:     false,
 	}
 	c.statuses = append(c.statuses, status)
@@ -194,7 +246,7 @@ func (c *TD) setPassed() {
 func (c *TD) setFailed(fatal bool) {
 	status := constants.Status{
 		Status:    constants.StatusFail,
-		Lifecycle: c.currentLifecycle,
+		Lifecycle: c.lifecycle(),
 		Fatal:     fatal,
 	}
 	c.statuses = append(c.statuses, status)
@@ -204,7 +256,7 @@ func (c *TD) setFailed(fatal bool) {
 func (c *TD) setSkipped() {
 	status := constants.Status{
 		Status:    constants.StatusSkip,
-		Lifecycle: c.currentLifecycle,
+		Lifecycle: c.lifecycle(),
 	}
 	c.statuses = append(c.statuses, status)
 }
@@ -214,7 +266,7 @@ func (c *TD) setSkipped() {
 // t is the current test case
 // lifecycle is the current test case step to save timing for
 func timedRun(fn func(t *TD), t *TD, lifecycle string) {
-	t.currentLifecycle = lifecycle
+	t.setLifecycle(lifecycle)
 
 	timing := constants.Timing{
 		Lifecycle: lifecycle,
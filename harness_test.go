@@ -4,9 +4,12 @@ import (
 	"errors"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/mercari/testdeck/constants"
 	. "github.com/mercari/testdeck/fname"
+	"github.com/mercari/testdeck/httputils"
+	"github.com/mercari/testdeck/recorder"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -179,6 +182,64 @@ func Test_TD_ArgMethodsPassThrough(t *testing.T) {
 	// assert.Equal(t, 0, mock.callCount.get("Helper")) // methods may arbitrarily call Helper
 }
 
+func Test_TD_Context_ShouldRecordRPCsInStatistics(t *testing.T) {
+	// Arrange
+	td := TD{
+		T:                newMockT(),
+		currentLifecycle: constants.LifecycleAct,
+	}
+
+	// Act
+	r := recorder.FromContext(td.Context())
+	r.Add(constants.RPCRecord{Method: "/echo.EchoService/Say"})
+	stats := td.makeStatistics(time.Now(), time.Now())
+
+	// Assert
+	require.NotNil(t, r)
+	assert.Same(t, r, recorder.FromContext(td.Context()))
+	assert.Equal(t, []constants.RPCRecord{{Method: "/echo.EchoService/Say"}}, td.RPCs())
+	assert.Equal(t, td.RPCs(), stats.RPCs)
+}
+
+func Test_TD_Lifecycle_ShouldBeSafeToReadFromOtherGoroutines(t *testing.T) {
+	// Arrange
+	td := &TD{T: newMockT(), timings: make(map[string]constants.Timing)}
+	done := make(chan struct{})
+	read := make(chan string)
+
+	// Act
+	// like the gRPC recorder reading the stage for a call made from a goroutine of the test (run with -race)
+	go func() {
+		last := ""
+		for {
+			select {
+			case <-done:
+				read <- last
+				return
+			default:
+				last = td.lifecycle()
+			}
+		}
+	}()
+	for _, lifecycle := range []string{constants.LifecycleArrange, constants.LifecycleAct, constants.LifecycleAssert} {
+		timedRun(nil, td, lifecycle)
+	}
+	close(done)
+
+	// Assert
+	assert.Contains(t, []string{"", constants.LifecycleArrange, constants.LifecycleAct, constants.LifecycleAssert}, <-read)
+	assert.Equal(t, constants.LifecycleAssert, td.lifecycle())
+}
+
+func Test_TD_HTTPClient_ShouldBeSharedByTestCase(t *testing.T) {
+	td := TD{T: newMockT()}
+	other := TD{T: newMockT()}
+
+	assert.Same(t, td.HTTPClient(), td.HTTPClient())
+	assert.NotSame(t, td.HTTPClient(), other.HTTPClient())
+	assert.NotSame(t, httputils.DefaultClient, td.HTTPClient())
+}
+
 func Test_TD_ArgfMethodsPassThrough(t *testing.T) {
 	// Arrange
 	mock := newMockT()
@@ -0,0 +1,284 @@
+package httputils
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/mercari/testdeck/cassette"
+	"github.com/mercari/testdeck/constants"
+)
+
+/*
+client.go: A session-style HTTP client with a cookie jar, default headers and its own timeout, proxy and TLS settings
+*/
+
+// ClientConfig configures a Client
+type ClientConfig struct {
+	Timeout            time.Duration     // timeout of each request, constants.DefaultHttpTimeout if 0
+	Proxy              string            // URL of a proxy to send the requests through (e.g. a debugging proxy such as Burp Suite)
+	CACertFile         string            // PEM file with the CA certificates of the server, the system certificates are used if empty
+	InsecureSkipVerify bool              // do not verify the certificate of the server (only for test environments with self-signed certificates)
+	TLSConfig          *tls.Config       // any other TLS settings (e.g. client certificates), CACertFile and InsecureSkipVerify are applied on top of it
+	Headers            map[string]string // headers sent with every request, unless the request sets them itself
+	DisableCookies     bool              // do not keep the cookies set by the server
+	Transport          http.RoundTripper // the transport to send the requests with, if set Proxy and the TLS settings are ignored
+}
+
+// Client sends HTTP requests and keeps the cookies set by the server between them, like a browser session
+// A client can be shared by the lifecycle stages of a test case (see TD.HTTPClient) and is safe for concurrent use
+type Client struct {
+	config ClientConfig
+	client *http.Client
+
+	mu      sync.RWMutex
+	base    http.RoundTripper
+	parent  *Client // the client this one was copied from, whose current transport is used until SetProxy is called on this one
+	headers map[string]string
+}
+
+// DefaultClient is the client used by SendHTTPRequest
+// It does not keep cookies, because it is shared by every test in the process; use TD.HTTPClient or NewClient for sessions
+var DefaultClient = mustNewClient(ClientConfig{DisableCookies: true})
+
+// Creates a client
+func NewClient(config ...ClientConfig) (*Client, error) {
+	var c ClientConfig
+	if len(config) > 0 {
+		c = config[0]
+	}
+
+	base, err := c.transport()
+	if err != nil {
+		return nil, err
+	}
+
+	client := &Client{config: c, base: base, headers: map[string]string{}}
+	for k, v := range c.Headers {
+		client.headers[k] = v
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = constants.DefaultHttpTimeout
+	}
+	client.client = &http.Client{
+		Timeout:   timeout,
+		Transport: cassette.WrapTransport(clientTransport{client}),
+	}
+	if !c.DisableCookies {
+		// cookiejar.New only fails if the options are invalid
+		client.client.Jar, _ = cookiejar.New(nil)
+	}
+	return client, nil
+}
+
+func mustNewClient(c ClientConfig) *Client {
+	client, err := NewClient(c)
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+// Returns a new client with the same settings (including the proxy and default headers) and no cookies
+// The new client follows the proxy of c, so a proxy set later with c.SetProxy (e.g. ConnectToProxy) is used by both
+func (c *Client) Clone() *Client {
+	return c.copy(c.currentConfig())
+}
+
+// Returns a new client with the same settings as Clone and its own cookie jar, even if c does not keep cookies
+// This is how TD.HTTPClient starts a session from DefaultClient
+func (c *Client) NewSession() *Client {
+	config := c.currentConfig()
+	config.DisableCookies = false
+	return c.copy(config)
+}
+
+// Returns a new client with the same settings as Clone and a different timeout, which shares the cookie jar of c
+// so that requests sent with it belong to the same session (e.g. to fuzz an endpoint after logging in)
+func (c *Client) WithTimeout(timeout time.Duration) *Client {
+	config := c.currentConfig()
+	config.Timeout = timeout
+	client := c.copy(config)
+	client.client.Jar = c.client.Jar
+	return client
+}
+
+// Creates a client from the settings of c that sends the requests with the current transport of c
+func (c *Client) copy(config ClientConfig) *Client {
+	client := mustNewClient(config)
+	client.parent = c
+	return client
+}
+
+// Returns the settings of the client, including the current proxy and default headers
+func (c *Client) currentConfig() ClientConfig {
+	transport := c.transport()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	config := c.config
+	config.Transport = transport
+	config.Headers = map[string]string{}
+	for k, v := range c.headers {
+		config.Headers[k] = v
+	}
+	return config
+}
+
+// Sends the request through the proxy from now on (e.g. http://localhost:8080), or directly if proxyURL is empty
+func (c *Client) SetProxy(proxyURL string) error {
+	config := c.config
+	config.Proxy = proxyURL
+	config.Transport = nil
+	base, err := config.transport()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.config.Proxy = proxyURL
+	c.base = base
+	c.parent = nil
+	return nil
+}
+
+// Sets a header that is sent with every request
+func (c *Client) SetHeader(key, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.headers[key] = value
+}
+
+// Returns the cookies the client sends to the URL
+func (c *Client) Cookies(rawURL string) []*http.Cookie {
+	u, err := url.Parse(rawURL)
+	if err != nil || c.client.Jar == nil {
+		return nil
+	}
+	return c.client.Jar.Cookies(u)
+}
+
+// Returns the underlying http.Client, e.g. for libraries that need one
+func (c *Client) HTTPClient() *http.Client {
+	return c.client
+}
+
+// Sends the request and returns the response with its body, which has already been read and closed
+func (c *Client) Do(req *http.Request) (*http.Response, *bytes.Buffer, error) {
+	c.mu.RLock()
+	for k, v := range c.headers {
+		if req.Header.Get(k) == "" {
+			req.Header.Set(k, v)
+		}
+	}
+	c.mu.RUnlock()
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	responseBody := &bytes.Buffer{}
+	if _, err := responseBody.ReadFrom(resp.Body); err != nil {
+		return resp, nil, err
+	}
+	return resp, responseBody, nil
+}
+
+// Creates an HTTP request with the specified headers, http data, etc. and sends it (see SendHTTPRequest)
+func (c *Client) Send(method string, url string, body *bytes.Buffer, headers map[string]string, host ...string) (*http.Response, *bytes.Buffer, error) {
+	var req *http.Request
+	var err error
+	if body == nil {
+		req, err = http.NewRequest(method, url, nil)
+	} else {
+		req, err = http.NewRequest(method, url, body)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// if host param was passed in, add it to the request
+	if len(host) > 0 {
+		req.Host = host[0]
+	}
+
+	// add headers if specified
+	for key, element := range headers {
+		req.Header.Add(key, element)
+	}
+
+	return c.Do(req)
+}
+
+// Returns the current transport of the client, which is the one of the client it was copied from unless SetProxy was called on it
+func (c *Client) transport() http.RoundTripper {
+	c.mu.RLock()
+	base, parent := c.base, c.parent
+	c.mu.RUnlock()
+	if parent != nil {
+		return parent.transport()
+	}
+	return base
+}
+
+// Sends the requests with the current transport of the client, which can be changed by SetProxy
+type clientTransport struct {
+	client *Client
+}
+
+func (t clientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.client.transport().RoundTrip(req)
+}
+
+// Returns the transport for the config
+func (c ClientConfig) transport() (http.RoundTripper, error) {
+	if c.Transport != nil {
+		return c.Transport, nil
+	}
+
+	t := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if d, ok := http.DefaultTransport.(*http.Transport); ok {
+		t = d.Clone()
+	}
+
+	if c.Proxy != "" {
+		proxyURL, err := url.Parse(c.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %s", err.Error())
+		}
+		t.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if c.TLSConfig != nil {
+		t.TLSClientConfig = c.TLSConfig.Clone()
+	}
+	if c.CACertFile != "" || c.InsecureSkipVerify {
+		if t.TLSClientConfig == nil {
+			t.TLSClientConfig = &tls.Config{}
+		}
+		t.TLSClientConfig.InsecureSkipVerify = c.InsecureSkipVerify
+	}
+	if c.CACertFile != "" {
+		pem, err := ioutil.ReadFile(c.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %s", err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates were found in %s", c.CACertFile)
+		}
+		t.TLSClientConfig.RootCAs = pool
+	}
+	return t, nil
+}
@@ -0,0 +1,219 @@
+package httputils
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// a server that logs in with /login and returns the session cookie and the headers of the request otherwise
+func newSessionServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/"})
+			return
+		}
+		session := ""
+		if c, err := r.Cookie("session"); err == nil {
+			session = c.Value
+		}
+		fmt.Fprintf(w, "session=%s agent=%s", session, r.Header.Get("User-Agent"))
+	}))
+}
+
+func Test_Client_ShouldKeepCookiesAndSendDefaultHeaders(t *testing.T) {
+	// Arrange
+	server := newSessionServer()
+	defer server.Close()
+	client, err := NewClient(ClientConfig{Headers: map[string]string{"User-Agent": "testdeck"}})
+	require.Nil(t, err)
+
+	// Act
+	_, before, err := client.Send(http.MethodGet, server.URL+"/me", nil, nil)
+	require.Nil(t, err)
+	_, _, err = client.Send(http.MethodPost, server.URL+"/login", nil, nil)
+	require.Nil(t, err)
+	_, after, err := client.Send(http.MethodGet, server.URL+"/me", nil, map[string]string{"User-Agent": "other"})
+	require.Nil(t, err)
+	_, cloned, err := client.Clone().Send(http.MethodGet, server.URL+"/me", nil, nil)
+	require.Nil(t, err)
+
+	// Assert
+	assert.Equal(t, "session= agent=testdeck", before.String())
+	assert.Equal(t, "session=abc agent=other", after.String())
+	assert.Len(t, client.Cookies(server.URL), 1)
+	assert.Equal(t, "session= agent=testdeck", cloned.String())
+}
+
+func Test_Client_ShouldDisableCookies(t *testing.T) {
+	server := newSessionServer()
+	defer server.Close()
+	client, err := NewClient(ClientConfig{DisableCookies: true})
+	require.Nil(t, err)
+
+	_, _, err = client.Send(http.MethodPost, server.URL+"/login", nil, nil)
+	require.Nil(t, err)
+	_, body, err := client.Send(http.MethodGet, server.URL+"/me", nil, nil)
+	require.Nil(t, err)
+
+	assert.Contains(t, body.String(), "session= ")
+	assert.Nil(t, client.Cookies(server.URL))
+}
+
+func Test_DefaultClient_ShouldNotKeepCookies(t *testing.T) {
+	// Arrange
+	server := newSessionServer()
+	defer server.Close()
+
+	// Act
+	_, _, err := SendHTTPRequest(http.MethodPost, server.URL+"/login", nil, nil)
+	require.Nil(t, err)
+	_, body, err := SendHTTPRequest(http.MethodGet, server.URL+"/me", nil, nil)
+	require.Nil(t, err)
+
+	// Assert
+	assert.Contains(t, body.String(), "session= ")
+	assert.Nil(t, DefaultClient.Cookies(server.URL))
+}
+
+func Test_Client_NewSession_ShouldKeepCookies(t *testing.T) {
+	// Arrange
+	server := newSessionServer()
+	defer server.Close()
+	session := DefaultClient.NewSession()
+
+	// Act
+	_, _, err := session.Send(http.MethodPost, server.URL+"/login", nil, nil)
+	require.Nil(t, err)
+	_, body, err := session.Send(http.MethodGet, server.URL+"/me", nil, nil)
+	require.Nil(t, err)
+
+	// Assert
+	assert.Contains(t, body.String(), "session=abc")
+	assert.Nil(t, DefaultClient.Cookies(server.URL))
+}
+
+func Test_Client_ShouldTimeOut(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+	client, err := NewClient(ClientConfig{Timeout: 20 * time.Millisecond})
+	require.Nil(t, err)
+
+	_, _, err = client.Send(http.MethodGet, server.URL, nil, nil)
+
+	assert.NotNil(t, err)
+}
+
+func Test_Client_WithTimeout_ShouldKeepSession(t *testing.T) {
+	// Arrange
+	server := newSessionServer()
+	defer server.Close()
+	session, err := NewClient()
+	require.Nil(t, err)
+	_, _, err = session.Send(http.MethodPost, server.URL+"/login", nil, nil)
+	require.Nil(t, err)
+
+	// Act
+	client := session.WithTimeout(time.Second)
+	_, body, err := client.Send(http.MethodGet, server.URL+"/me", nil, nil)
+
+	// Assert
+	require.Nil(t, err)
+	assert.Contains(t, body.String(), "session=abc")
+	assert.Equal(t, time.Second, client.HTTPClient().Timeout)
+	assert.NotEqual(t, time.Second, session.HTTPClient().Timeout, "the timeout of the original client should not change")
+}
+
+func Test_Client_SetProxy_ShouldSendRequestsThroughProxy(t *testing.T) {
+	// Arrange
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "proxied %s", r.URL.String())
+	}))
+	defer proxy.Close()
+	client, err := NewClient()
+	require.Nil(t, err)
+
+	// Act
+	err = client.SetProxy(proxy.URL)
+	require.Nil(t, err)
+	_, body, sendErr := client.Send(http.MethodGet, "http://example.invalid/path", nil, nil)
+
+	// Assert
+	require.Nil(t, sendErr)
+	assert.Equal(t, "proxied http://example.invalid/path", body.String())
+	assert.NotNil(t, client.SetProxy("://invalid"))
+}
+
+func Test_Client_SetProxy_ShouldBeFollowedByCopiesMadeBefore(t *testing.T) {
+	// Arrange
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "proxied")
+	}))
+	defer proxy.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "direct")
+	}))
+	defer server.Close()
+	client, err := NewClient(ClientConfig{DisableCookies: true})
+	require.Nil(t, err)
+	session := client.NewSession()
+	clone := session.Clone()
+	timeout := client.WithTimeout(time.Second)
+
+	// Act
+	require.Nil(t, client.SetProxy(proxy.URL))
+	var bodies []string
+	for _, c := range []*Client{session, clone, timeout} {
+		_, body, err := c.Send(http.MethodGet, server.URL, nil, nil)
+		require.Nil(t, err)
+		bodies = append(bodies, body.String())
+	}
+	require.Nil(t, session.SetProxy(""))
+	_, own, err := session.Send(http.MethodGet, server.URL, nil, nil)
+	require.Nil(t, err)
+
+	// Assert
+	assert.Equal(t, []string{"proxied", "proxied", "proxied"}, bodies)
+	assert.Equal(t, "direct", own.String(), "a client should stop following the proxy of the original once it has its own")
+}
+
+func Test_Client_ShouldTrustCACertFile(t *testing.T) {
+	// Arrange
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	require.Nil(t, ioutil.WriteFile(caFile, caPEM, 0644))
+
+	untrusted, err := NewClient()
+	require.Nil(t, err)
+	trusted, err := NewClient(ClientConfig{CACertFile: caFile})
+	require.Nil(t, err)
+
+	// Act
+	_, _, untrustedErr := untrusted.Send(http.MethodGet, server.URL, nil, nil)
+	_, body, trustedErr := trusted.Send(http.MethodGet, server.URL, nil, nil)
+
+	// Assert
+	assert.NotNil(t, untrustedErr)
+	require.Nil(t, trustedErr)
+	assert.Equal(t, "ok", body.String())
+}
+
+func Test_NewClient_ShouldFailWithMissingCACertFile(t *testing.T) {
+	_, err := NewClient(ClientConfig{CACertFile: filepath.Join(t.TempDir(), "missing.pem")})
+
+	assert.NotNil(t, err)
+}
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"github.com/mercari/testdeck/constants"
 	"github.com/pkg/errors"
 	"net/http"
 	"net/url"
@@ -21,77 +20,48 @@ func ToBufferArray(form url.Values) *bytes.Buffer {
 	return &b
 }
 
-// Creates an HTTP request with the specified headers, http data, etc.
+// Creates an HTTP request with the specified headers, http data, etc. and sends it with DefaultClient
+// While a cassette is in use, the request and response are recorded or replayed (see the cassette package)
 func SendHTTPRequest(method string, url string, body *bytes.Buffer, headers map[string]string, host ...string) (*http.Response, *bytes.Buffer, error) {
-
-	client := &http.Client{
-		Timeout: constants.DefaultHttpTimeout,
-	}
-	req, err := http.NewRequest(method, url, body)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	// if host param was passed in, add it to the request
-	if len(host) > 0 {
-		req.Host = host[0]
-	}
-
-	// add headers if specified
-	for key, element := range headers {
-		req.Header.Add(key, element)
-	}
-
-	// send request
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	defer resp.Body.Close()
-
-	// export json response
-	responseBody := &bytes.Buffer{}
-	_, err = responseBody.ReadFrom(resp.Body)
-	if err != nil {
-		return resp, nil, err
-	}
-
-	return resp, responseBody, nil
+	return DefaultClient.Send(method, url, body, headers, host...)
 }
 
 // Returns the value of a specified field in the json data
+// The field is read from the "data" object if the body has one, otherwise from the top level; use GetJSONValue and the typed getters for other fields
 func GetJSONField(fieldName string, jsonBody *bytes.Buffer) (string, error) {
+	if jsonBody == nil {
+		return "", errors.New("the JSON body is nil")
+	}
 	body := make(map[string]interface{})
-	_ = json.Unmarshal(jsonBody.Bytes(), &body)
-	dataObject := body["data"]
+	if err := json.Unmarshal(jsonBody.Bytes(), &body); err != nil {
+		return "", errors.Wrap(err, "invalid JSON")
+	}
 
-	if dataObject != nil {
-		// return value in nested json http if it exists
-		if body, _ := dataObject.(map[string]interface{}); body != nil {
-			value, ok := body[fieldName].(string)
-			if ok {
-				return value, nil
-			}
-		}
-	} else {
-		// otherwise return value from http
-		value, ok := body[fieldName].(string)
-		if ok {
-			return value, nil
-		}
+	// return value in nested json http if it exists, otherwise return value from http
+	if dataObject, ok := body["data"].(map[string]interface{}); ok {
+		body = dataObject
+	} else if body["data"] != nil {
+		return "", errors.Errorf("data is %s, not an object", jsonType(body["data"]))
 	}
 
-	return "", errors.New("JSON does not contain the specified field")
+	value, ok := body[fieldName]
+	if !ok {
+		return "", errors.Wrapf(ErrJSONFieldNotFound, "%s", fieldName)
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", errors.Errorf("%s is %s, not a string", fieldName, jsonType(value))
+	}
+	return s, nil
 }
 
 // Connect to a debugging proxy (Burp Suite, Charles, etc.)
+// Only the requests sent by DefaultClient (e.g. with SendHTTPRequest) and the clients copied from it (e.g. TD.HTTPClient) go through the proxy,
+// use Client.SetProxy for other clients
 func ConnectToProxy(ip string) error {
-	proxyUrl, err := url.Parse(ip)
-	if err != nil {
+	if err := DefaultClient.SetProxy(ip); err != nil {
 		return err
 	}
-	http.DefaultTransport = &http.Transport{Proxy: http.ProxyURL(proxyUrl)}
 	fmt.Println("Connected to debugging proxy...")
 	return nil
 }
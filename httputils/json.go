@@ -0,0 +1,308 @@
+package httputils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+/*
+json.go: Path-based extraction of values from JSON response bodies (e.g. data.items[0].id)
+*/
+
+// ErrJSONFieldNotFound is returned when the JSON does not contain the field at a path
+var ErrJSONFieldNotFound = errors.New("JSON does not contain the specified field")
+
+// Returns the value at the path in the JSON body
+// Paths are keys separated by dots, with [n] for array elements (e.g. data.items[0].id, [-1] is the last element), and # for the length of an array (e.g. data.items.#)
+// A dot in a key is escaped with a backslash (e.g. metadata.app\.version)
+// Values are returned as decoded by encoding/json: string, float64, bool, nil, []interface{} or map[string]interface{}
+// An empty path returns the whole body
+func GetJSONValue(path string, jsonBody *bytes.Buffer) (interface{}, error) {
+	if jsonBody == nil {
+		return nil, errors.New("the JSON body is nil")
+	}
+	var body interface{}
+	if err := json.Unmarshal(jsonBody.Bytes(), &body); err != nil {
+		return nil, errors.Wrap(err, "invalid JSON")
+	}
+	return JSONPathValue(body, path)
+}
+
+// Decodes the JSON body like GetJSONValue, but with numbers as json.Number, so that large integers (e.g. 64-bit IDs) are not rounded to a float64
+// Use JSONPathValue to read values at paths of the decoded body
+func DecodeJSON(jsonBody *bytes.Buffer) (interface{}, error) {
+	if jsonBody == nil {
+		return nil, errors.New("the JSON body is nil")
+	}
+	d := json.NewDecoder(bytes.NewReader(jsonBody.Bytes()))
+	d.UseNumber()
+	var body interface{}
+	if err := d.Decode(&body); err != nil {
+		return nil, errors.Wrap(err, "invalid JSON")
+	}
+	if d.More() {
+		return nil, errors.New("invalid JSON: more than one value")
+	}
+	return body, nil
+}
+
+// Returns the value at the path in JSON that was already decoded into an interface{} (see GetJSONValue for the path syntax)
+func JSONPathValue(body interface{}, path string) (interface{}, error) {
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	value := body
+	walked := ""
+	for _, s := range steps {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if s.index != nil || s.length {
+				return nil, errors.Errorf("%s is an object, not an array", describePath(walked))
+			}
+			next, ok := v[s.key]
+			if !ok {
+				return nil, errors.Wrapf(ErrJSONFieldNotFound, "%s", joinPath(walked, s))
+			}
+			value = next
+		case []interface{}:
+			switch {
+			case s.length:
+				value = float64(len(v))
+			case s.index != nil:
+				i := *s.index
+				if i < 0 {
+					i += len(v)
+				}
+				if i < 0 || i >= len(v) {
+					return nil, errors.Wrapf(ErrJSONFieldNotFound, "%s (the array has %d elements)", joinPath(walked, s), len(v))
+				}
+				value = v[i]
+			default:
+				return nil, errors.Errorf("%s is an array, not an object", describePath(walked))
+			}
+		default:
+			return nil, errors.Wrapf(ErrJSONFieldNotFound, "%s (%s is %s)", joinPath(walked, s), describePath(walked), jsonType(value))
+		}
+		walked = joinPath(walked, s)
+	}
+	return value, nil
+}
+
+// Returns the string at the path in the JSON body
+func GetJSONString(path string, jsonBody *bytes.Buffer) (string, error) {
+	value, err := GetJSONValue(path, jsonBody)
+	if err != nil {
+		return "", err
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", errors.Errorf("%s is %s, not a string", path, jsonType(value))
+	}
+	return s, nil
+}
+
+// Returns the number at the path in the JSON body
+func GetJSONFloat(path string, jsonBody *bytes.Buffer) (float64, error) {
+	value, err := GetJSONValue(path, jsonBody)
+	if err != nil {
+		return 0, err
+	}
+	f, ok := value.(float64)
+	if !ok {
+		return 0, errors.Errorf("%s is %s, not a number", path, jsonType(value))
+	}
+	return f, nil
+}
+
+// Returns the integer at the path in the JSON body
+// The number is read exactly rather than through a float64, and an error is returned if it does not fit in an int64
+func GetJSONInt(path string, jsonBody *bytes.Buffer) (int64, error) {
+	body, err := DecodeJSON(jsonBody)
+	if err != nil {
+		return 0, err
+	}
+	value, err := JSONPathValue(body, path)
+	if err != nil {
+		return 0, err
+	}
+
+	var r big.Rat
+	switch v := value.(type) {
+	case json.Number:
+		r.SetString(v.String())
+	case float64: // the length of an array (#)
+		r.SetFloat64(v)
+	default:
+		return 0, errors.Errorf("%s is %s, not a number", path, jsonType(value))
+	}
+	if !r.IsInt() {
+		return 0, errors.Errorf("%s is %v, not an integer", path, value)
+	}
+	if !r.Num().IsInt64() {
+		return 0, errors.Errorf("%s is %v, which does not fit in an int64", path, value)
+	}
+	return r.Num().Int64(), nil
+}
+
+// Returns the boolean at the path in the JSON body
+func GetJSONBool(path string, jsonBody *bytes.Buffer) (bool, error) {
+	value, err := GetJSONValue(path, jsonBody)
+	if err != nil {
+		return false, err
+	}
+	b, ok := value.(bool)
+	if !ok {
+		return false, errors.Errorf("%s is %s, not a boolean", path, jsonType(value))
+	}
+	return b, nil
+}
+
+// Returns the array at the path in the JSON body
+func GetJSONArray(path string, jsonBody *bytes.Buffer) ([]interface{}, error) {
+	value, err := GetJSONValue(path, jsonBody)
+	if err != nil {
+		return nil, err
+	}
+	a, ok := value.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("%s is %s, not an array", path, jsonType(value))
+	}
+	return a, nil
+}
+
+// Returns true if the JSON body has a value (which can be null) at the path
+func HasJSONValue(path string, jsonBody *bytes.Buffer) bool {
+	_, err := GetJSONValue(path, jsonBody)
+	return err == nil
+}
+
+// ----------
+// paths
+// ----------
+
+// A step of a path: a key of an object, an index of an array or the length of an array
+type jsonPathStep struct {
+	key    string
+	index  *int
+	length bool
+}
+
+// Splits the path into steps
+func parseJSONPath(path string) ([]jsonPathStep, error) {
+	var steps []jsonPathStep
+	var key strings.Builder
+	keyStarted := false
+
+	addKey := func() {
+		if !keyStarted {
+			return
+		}
+		if key.String() == "#" {
+			steps = append(steps, jsonPathStep{length: true})
+		} else {
+			steps = append(steps, jsonPathStep{key: key.String()})
+		}
+		key.Reset()
+		keyStarted = false
+	}
+
+	for i := 0; i < len(path); i++ {
+		switch c := path[i]; c {
+		case '\\':
+			if i+1 < len(path) {
+				i++
+				key.WriteByte(path[i])
+				keyStarted = true
+			}
+		case '.':
+			if !keyStarted && (i == 0 || path[i-1] != ']') {
+				return nil, errors.Errorf("invalid JSON path %q: empty key at position %d", path, i)
+			}
+			addKey()
+		case '[':
+			addKey()
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, errors.Errorf("invalid JSON path %q: missing ]", path)
+			}
+			n, err := strconv.Atoi(path[i+1 : i+end])
+			if err != nil {
+				return nil, errors.Errorf("invalid JSON path %q: %q is not an index", path, path[i+1:i+end])
+			}
+			steps = append(steps, jsonPathStep{index: &n})
+			i += end
+		default:
+			key.WriteByte(c)
+			keyStarted = true
+		}
+	}
+	if strings.HasSuffix(path, ".") && !strings.HasSuffix(path, "\\.") {
+		return nil, errors.Errorf("invalid JSON path %q: empty key at the end", path)
+	}
+	addKey()
+	return steps, nil
+}
+
+// Returns the path with the step added
+func joinPath(path string, s jsonPathStep) string {
+	switch {
+	case s.index != nil:
+		return fmt.Sprintf("%s[%d]", path, *s.index)
+	case s.length:
+		return joinKey(path, "#")
+	default:
+		return joinKey(path, strings.Replace(s.key, ".", "\\.", -1))
+	}
+}
+
+func joinKey(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// Returns the path for error messages
+func describePath(path string) string {
+	if path == "" {
+		return "the root"
+	}
+	return path
+}
+
+// Returns the JSON type of a decoded value for error messages
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "a string"
+	case float64:
+		if v == math.Trunc(v) {
+			return "an integer"
+		}
+		return "a number"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "an integer"
+		}
+		return "a number"
+	case bool:
+		return "a boolean"
+	case []interface{}:
+		return "an array"
+	case map[string]interface{}:
+		return "an object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
@@ -0,0 +1,378 @@
+package httputils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+/*
+json_schema.go: Validates JSON response bodies against a subset of JSON Schema
+
+Supported keywords:
+	type, enum, const
+	properties, required, additionalProperties, minProperties, maxProperties
+	items, minItems, maxItems, uniqueItems
+	minLength, maxLength, pattern
+	minimum, maximum, exclusiveMinimum, exclusiveMaximum, multipleOf
+	allOf, anyOf, oneOf, not
+Other keywords (e.g. $ref and format) are ignored
+*/
+
+// SchemaError lists the parts of a JSON body that do not match a schema
+type SchemaError struct {
+	Violations []string // e.g. "data.items[0].id: expected a string, got an integer"
+}
+
+func (e *SchemaError) Error() string {
+	return "JSON does not match the schema:\n" + strings.Join(e.Violations, "\n")
+}
+
+// Validates the JSON body against the JSON Schema
+// Returns a *SchemaError if the body does not match the schema, or another error if the body or the schema is invalid
+func ValidateJSONSchema(schema []byte, jsonBody *bytes.Buffer) error {
+	if jsonBody == nil {
+		return errors.New("the JSON body is nil")
+	}
+	var body interface{}
+	if err := json.Unmarshal(jsonBody.Bytes(), &body); err != nil {
+		return errors.Wrap(err, "invalid JSON")
+	}
+	return ValidateJSONValue(schema, body)
+}
+
+// Validates JSON that was already decoded into an interface{} against the JSON Schema
+func ValidateJSONValue(schema []byte, value interface{}) error {
+	var s interface{}
+	if err := json.Unmarshal(schema, &s); err != nil {
+		return errors.Wrap(err, "invalid JSON schema")
+	}
+
+	v := &schemaValidator{}
+	if err := v.validate(s, value, ""); err != nil {
+		return err
+	}
+	if len(v.violations) > 0 {
+		return &SchemaError{Violations: v.violations}
+	}
+	return nil
+}
+
+type schemaValidator struct {
+	violations []string
+}
+
+func (v *schemaValidator) fail(path string, format string, args ...interface{}) {
+	if path == "" {
+		path = "(root)"
+	}
+	v.violations = append(v.violations, path+": "+fmt.Sprintf(format, args...))
+}
+
+// Returns true if the value matches the schema, without adding violations
+func (v *schemaValidator) matches(schema interface{}, value interface{}, path string) (bool, error) {
+	sub := &schemaValidator{}
+	if err := sub.validate(schema, value, path); err != nil {
+		return false, err
+	}
+	return len(sub.violations) == 0, nil
+}
+
+// Adds the violations of the value, returns an error if the schema is invalid
+func (v *schemaValidator) validate(schema interface{}, value interface{}, path string) error {
+	var s map[string]interface{}
+	switch schema := schema.(type) {
+	case bool:
+		// true matches everything, false matches nothing
+		if !schema {
+			v.fail(path, "no value is allowed")
+		}
+		return nil
+	case map[string]interface{}:
+		s = schema
+	default:
+		return errors.Errorf("invalid JSON schema at %s: a schema must be an object or a boolean", describePath(path))
+	}
+
+	if t, ok := s["type"]; ok {
+		types, err := schemaTypes(t)
+		if err != nil {
+			return err
+		}
+		if !hasType(types, value) {
+			v.fail(path, "expected %s, got %s", strings.Join(types, " or "), jsonType(value))
+			// the other keywords would only repeat the mismatch
+			return nil
+		}
+	}
+
+	if enum, ok := s["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if reflect.DeepEqual(e, value) {
+				found = true
+			}
+		}
+		if !found {
+			v.fail(path, "%s is not one of %s", compactJSON(value), compactJSON(enum))
+		}
+	}
+	if c, ok := s["const"]; ok && !reflect.DeepEqual(c, value) {
+		v.fail(path, "expected %s, got %s", compactJSON(c), compactJSON(value))
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if err := v.validateObject(s, value, path); err != nil {
+			return err
+		}
+	case []interface{}:
+		if err := v.validateArray(s, value, path); err != nil {
+			return err
+		}
+	case string:
+		if err := v.validateString(s, value, path); err != nil {
+			return err
+		}
+	case float64:
+		v.validateNumber(s, value, path)
+	}
+
+	return v.validateCombinations(s, value, path)
+}
+
+func (v *schemaValidator) validateObject(s map[string]interface{}, value map[string]interface{}, path string) error {
+	properties, _ := s["properties"].(map[string]interface{})
+
+	if required, ok := s["required"].([]interface{}); ok {
+		for _, r := range required {
+			name, _ := r.(string)
+			if _, ok := value[name]; !ok {
+				v.fail(path, "%s is required", name)
+			}
+		}
+	}
+
+	if n, ok := s["minProperties"].(float64); ok && float64(len(value)) < n {
+		v.fail(path, "expected at least %v properties, got %d", n, len(value))
+	}
+	if n, ok := s["maxProperties"].(float64); ok && float64(len(value)) > n {
+		v.fail(path, "expected at most %v properties, got %d", n, len(value))
+	}
+
+	// keys are sorted so that violations are reported in the same order every time
+	keys := make([]string, 0, len(value))
+	for k := range value {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		childPath := joinKey(path, strings.Replace(k, ".", "\\.", -1))
+		if p, ok := properties[k]; ok {
+			if err := v.validate(p, value[k], childPath); err != nil {
+				return err
+			}
+			continue
+		}
+		if additional, ok := s["additionalProperties"]; ok {
+			if b, ok := additional.(bool); ok && !b {
+				v.fail(path, "%s is not allowed", k)
+				continue
+			}
+			if err := v.validate(additional, value[k], childPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (v *schemaValidator) validateArray(s map[string]interface{}, value []interface{}, path string) error {
+	if n, ok := s["minItems"].(float64); ok && float64(len(value)) < n {
+		v.fail(path, "expected at least %v items, got %d", n, len(value))
+	}
+	if n, ok := s["maxItems"].(float64); ok && float64(len(value)) > n {
+		v.fail(path, "expected at most %v items, got %d", n, len(value))
+	}
+	if unique, ok := s["uniqueItems"].(bool); ok && unique {
+		for i := range value {
+			for j := 0; j < i; j++ {
+				if reflect.DeepEqual(value[i], value[j]) {
+					v.fail(path, "items %d and %d are equal", j, i)
+				}
+			}
+		}
+	}
+
+	if items, ok := s["items"]; ok {
+		for i, item := range value {
+			if err := v.validate(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (v *schemaValidator) validateString(s map[string]interface{}, value string, path string) error {
+	length := utf8.RuneCountInString(value)
+	if n, ok := s["minLength"].(float64); ok && float64(length) < n {
+		v.fail(path, "expected at least %v characters, got %d", n, length)
+	}
+	if n, ok := s["maxLength"].(float64); ok && float64(length) > n {
+		v.fail(path, "expected at most %v characters, got %d", n, length)
+	}
+	if pattern, ok := s["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return errors.Wrapf(err, "invalid pattern in JSON schema at %s", describePath(path))
+		}
+		if !re.MatchString(value) {
+			v.fail(path, "%q does not match %s", value, pattern)
+		}
+	}
+	return nil
+}
+
+func (v *schemaValidator) validateNumber(s map[string]interface{}, value float64, path string) {
+	if n, ok := s["minimum"].(float64); ok && value < n {
+		v.fail(path, "expected at least %v, got %v", n, value)
+	}
+	if n, ok := s["maximum"].(float64); ok && value > n {
+		v.fail(path, "expected at most %v, got %v", n, value)
+	}
+	if n, ok := s["exclusiveMinimum"].(float64); ok && value <= n {
+		v.fail(path, "expected more than %v, got %v", n, value)
+	}
+	if n, ok := s["exclusiveMaximum"].(float64); ok && value >= n {
+		v.fail(path, "expected less than %v, got %v", n, value)
+	}
+	if n, ok := s["multipleOf"].(float64); ok && n > 0 {
+		if q := value / n; q != math.Trunc(q) {
+			v.fail(path, "expected a multiple of %v, got %v", n, value)
+		}
+	}
+}
+
+func (v *schemaValidator) validateCombinations(s map[string]interface{}, value interface{}, path string) error {
+	if all, ok := s["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			if err := v.validate(sub, value, path); err != nil {
+				return err
+			}
+		}
+	}
+
+	if anyOf, ok := s["anyOf"].([]interface{}); ok {
+		matched := 0
+		for _, sub := range anyOf {
+			ok, err := v.matches(sub, value, path)
+			if err != nil {
+				return err
+			}
+			if ok {
+				matched++
+			}
+		}
+		if matched == 0 {
+			v.fail(path, "does not match any schema of anyOf")
+		}
+	}
+
+	if oneOf, ok := s["oneOf"].([]interface{}); ok {
+		matched := 0
+		for _, sub := range oneOf {
+			ok, err := v.matches(sub, value, path)
+			if err != nil {
+				return err
+			}
+			if ok {
+				matched++
+			}
+		}
+		if matched != 1 {
+			v.fail(path, "expected to match exactly one schema of oneOf, matched %d", matched)
+		}
+	}
+
+	if not, ok := s["not"]; ok {
+		matched, err := v.matches(not, value, path)
+		if err != nil {
+			return err
+		}
+		if matched {
+			v.fail(path, "must not match the schema of not")
+		}
+	}
+	return nil
+}
+
+// Returns the types allowed by the type keyword
+func schemaTypes(t interface{}) ([]string, error) {
+	switch t := t.(type) {
+	case string:
+		return []string{t}, nil
+	case []interface{}:
+		var types []string
+		for _, e := range t {
+			s, ok := e.(string)
+			if !ok {
+				return nil, errors.Errorf("invalid type in JSON schema: %v", e)
+			}
+			types = append(types, s)
+		}
+		return types, nil
+	}
+	return nil, errors.Errorf("invalid type in JSON schema: %v", t)
+}
+
+// Returns true if the value has one of the JSON Schema types
+func hasType(types []string, value interface{}) bool {
+	for _, t := range types {
+		switch v := value.(type) {
+		case nil:
+			if t == "null" {
+				return true
+			}
+		case string:
+			if t == "string" {
+				return true
+			}
+		case bool:
+			if t == "boolean" {
+				return true
+			}
+		case float64:
+			if t == "number" || (t == "integer" && v == math.Trunc(v)) {
+				return true
+			}
+		case []interface{}:
+			if t == "array" {
+				return true
+			}
+		case map[string]interface{}:
+			if t == "object" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the value in JSON for error messages
+func compactJSON(value interface{}) string {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(b)
+}
@@ -0,0 +1,85 @@
+package httputils
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSchema = `{
+	"type": "object",
+	"required": ["data"],
+	"properties": {
+		"data": {
+			"type": "object",
+			"required": ["id", "items"],
+			"properties": {
+				"id": {"type": "string", "pattern": "^user-[0-9]+$"},
+				"count": {"type": "integer", "minimum": 0, "maximum": 10},
+				"ratio": {"type": "number", "exclusiveMaximum": 1},
+				"active": {"type": "boolean"},
+				"deleted": {"type": ["string", "null"]},
+				"items": {
+					"type": "array",
+					"minItems": 1,
+					"items": {
+						"type": "object",
+						"required": ["id"],
+						"properties": {"id": {"enum": ["a", "b"]}, "tags": {"type": "array", "items": {"type": "string"}}},
+						"additionalProperties": false
+					}
+				}
+			}
+		}
+	}
+}`
+
+func Test_ValidateJSONSchema_ShouldAcceptMatchingBody(t *testing.T) {
+	err := ValidateJSONSchema([]byte(testSchema), bytes.NewBufferString(testJSON))
+
+	assert.Nil(t, err)
+}
+
+func Test_ValidateJSONSchema_ShouldListViolations(t *testing.T) {
+	body := bytes.NewBufferString(`{
+		"data": {
+			"id": "admin",
+			"count": 2.5,
+			"ratio": 1,
+			"items": [{"id": "c", "extra": 1}, {}]
+		}
+	}`)
+
+	err := ValidateJSONSchema([]byte(testSchema), body)
+
+	schemaErr, ok := err.(*SchemaError)
+	require.True(t, ok, err)
+	assert.Equal(t, []string{
+		`data.count: expected integer, got a number`,
+		`data.id: "admin" does not match ^user-[0-9]+$`,
+		`data.items[0]: extra is not allowed`,
+		`data.items[0].id: "c" is not one of ["a","b"]`,
+		`data.items[1]: id is required`,
+		`data.ratio: expected less than 1, got 1`,
+	}, schemaErr.Violations)
+}
+
+func Test_ValidateJSONSchema_ShouldSupportCombinations(t *testing.T) {
+	schema := []byte(`{"oneOf": [{"type": "string"}, {"type": "integer", "not": {"const": 0}}], "anyOf": [{"type": "string", "maxLength": 3}, {"type": "integer", "minimum": 5}]}`)
+
+	assert.Nil(t, ValidateJSONValue(schema, "abc"))
+	assert.Nil(t, ValidateJSONValue(schema, float64(7)))
+	assert.NotNil(t, ValidateJSONValue(schema, float64(0)))
+	assert.NotNil(t, ValidateJSONValue(schema, "abcd"))
+	assert.NotNil(t, ValidateJSONValue(schema, true))
+}
+
+func Test_ValidateJSONSchema_ShouldRejectInvalidSchema(t *testing.T) {
+	err := ValidateJSONSchema([]byte(`{"type": 1}`), bytes.NewBufferString(`{}`))
+	_, isSchemaErr := err.(*SchemaError)
+
+	assert.NotNil(t, err)
+	assert.False(t, isSchemaErr)
+}
@@ -0,0 +1,136 @@
+package httputils
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testJSON = `{
+	"data": {
+		"id": "user-1",
+		"count": 2,
+		"ratio": 0.5,
+		"active": true,
+		"deleted": null,
+		"items": [{"id": "a", "tags": ["x"]}, {"id": "b", "tags": []}],
+		"app.version": "1.0"
+	}
+}`
+
+func Test_GetJSONValue_ShouldFollowPaths(t *testing.T) {
+	body := bytes.NewBufferString(testJSON)
+
+	cases := map[string]interface{}{
+		"data.id":               "user-1",
+		"data.items[0].id":      "a",
+		"data.items[-1].id":     "b",
+		"data.items[0].tags[0]": "x",
+		"data.items.#":          float64(2),
+		"data.items[1].tags.#":  float64(0),
+		`data.app\.version`:     "1.0",
+		"data.deleted":          nil,
+	}
+	for path, want := range cases {
+		got, err := GetJSONValue(path, body)
+		require.Nil(t, err, path)
+		assert.Equal(t, want, got, path)
+	}
+
+	root, err := GetJSONValue("", body)
+	require.Nil(t, err)
+	assert.IsType(t, map[string]interface{}{}, root)
+}
+
+func Test_GetJSONValue_ShouldReturnErrors(t *testing.T) {
+	body := bytes.NewBufferString(testJSON)
+
+	_, missing := GetJSONValue("data.name", body)
+	_, outOfRange := GetJSONValue("data.items[2]", body)
+	_, notArray := GetJSONValue("data[0]", body)
+	_, notObject := GetJSONValue("data.id.value", body)
+	_, badPath := GetJSONValue("data.items[x]", body)
+	_, invalid := GetJSONValue("data", bytes.NewBufferString(`{"data":`))
+
+	assert.True(t, errors.Is(missing, ErrJSONFieldNotFound))
+	assert.True(t, errors.Is(outOfRange, ErrJSONFieldNotFound))
+	assert.NotNil(t, notArray)
+	assert.True(t, errors.Is(notObject, ErrJSONFieldNotFound))
+	assert.NotNil(t, badPath)
+	assert.Contains(t, invalid.Error(), "invalid JSON")
+}
+
+func Test_TypedGetters_ShouldReturnTypedValues(t *testing.T) {
+	body := bytes.NewBufferString(testJSON)
+
+	s, err := GetJSONString("data.id", body)
+	require.Nil(t, err)
+	assert.Equal(t, "user-1", s)
+
+	i, err := GetJSONInt("data.count", body)
+	require.Nil(t, err)
+	assert.Equal(t, int64(2), i)
+
+	f, err := GetJSONFloat("data.ratio", body)
+	require.Nil(t, err)
+	assert.Equal(t, 0.5, f)
+
+	b, err := GetJSONBool("data.active", body)
+	require.Nil(t, err)
+	assert.True(t, b)
+
+	a, err := GetJSONArray("data.items", body)
+	require.Nil(t, err)
+	assert.Len(t, a, 2)
+
+	_, err = GetJSONInt("data.ratio", body)
+	assert.NotNil(t, err)
+	_, err = GetJSONString("data.count", body)
+	assert.EqualError(t, err, "data.count is an integer, not a string")
+	assert.True(t, HasJSONValue("data.deleted", body))
+	assert.False(t, HasJSONValue("data.missing", body))
+}
+
+func Test_GetJSONInt_ShouldReadLargeIntegersExactly(t *testing.T) {
+	body := bytes.NewBufferString(`{"id": 9007199254740993, "exp": 1e3, "huge": 1e30, "min": -9223372036854775808, "items": [1, 2]}`)
+
+	id, err := GetJSONInt("id", body)
+	require.Nil(t, err)
+	assert.Equal(t, int64(9007199254740993), id)
+
+	exp, err := GetJSONInt("exp", body)
+	require.Nil(t, err)
+	assert.Equal(t, int64(1000), exp)
+
+	min, err := GetJSONInt("min", body)
+	require.Nil(t, err)
+	assert.Equal(t, int64(math.MinInt64), min)
+
+	length, err := GetJSONInt("items.#", body)
+	require.Nil(t, err)
+	assert.Equal(t, int64(2), length)
+
+	_, err = GetJSONInt("huge", body)
+	assert.EqualError(t, err, "huge is 1e30, which does not fit in an int64")
+}
+
+func Test_GetJSONField_ShouldReturnErrors(t *testing.T) {
+	value, err := GetJSONField("id", bytes.NewBufferString(testJSON))
+	require.Nil(t, err)
+	assert.Equal(t, "user-1", value)
+
+	value, err = GetJSONField("id", bytes.NewBufferString(`{"id": "top"}`))
+	require.Nil(t, err)
+	assert.Equal(t, "top", value)
+
+	_, err = GetJSONField("id", bytes.NewBufferString(`not json`))
+	assert.Contains(t, err.Error(), "invalid JSON")
+	_, err = GetJSONField("name", bytes.NewBufferString(testJSON))
+	assert.True(t, errors.Is(err, ErrJSONFieldNotFound))
+	_, err = GetJSONField("count", bytes.NewBufferString(testJSON))
+	assert.EqualError(t, err, "count is an integer, not a string")
+}
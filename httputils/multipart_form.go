@@ -2,9 +2,17 @@ package httputils
 
 import (
 	"bytes"
+	"encoding"
+	"encoding/json"
 	"fmt"
+	"io"
+	"mime"
 	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 
 	"github.com/pkg/errors"
 )
@@ -18,58 +26,276 @@ type MultipartFieldWriter interface {
 }
 
 const (
-	jsonTag      = "json"
-	multipartTag = "multipart"
-	optionalTag  = "optional"
+	jsonTag        = "json"
+	multipartTag   = "multipart"
+	optionalTag    = "optional"
+	contentTypeTag = "content_type"
+	filenameTag    = "filename"
+)
+
+// Types of the multipart tag
+const (
+	multipartField  = "field"  // form field, written once per element for slices and flattened for structs
+	multipartFile   = "file"   // file upload (see MultipartFile)
+	multipartJSON   = "json"   // value encoded as JSON in a single part
+	multipartCustom = "custom" // written by the MultipartFieldWriter of the value
 )
 
 type FieldProperties struct {
+	Name          string // name of the part
 	MultipartType string
+	ContentType   string // Content-Type of the parts, set by the content_type tag
+	FileName      string // file name of the parts, set by the filename tag
 	Value         string
+	Values        []string        // values of a slice or array field, written as one part each
+	Files         []MultipartFile // files of a file field
+	JSON          []byte          // encoded value of a json field
 	Optional      bool
 	IsZero        bool
 	Ref           MultipartFieldWriter
 }
 
+// A file to upload in a multipart:"file" field
+// A file field can also be a path (string), the content ([]byte), an io.Reader (e.g. *os.File) or a slice of any of these
+type MultipartFile struct {
+	Name        string    // file name of the part, the base name of Path (or of the name of the reader) is used if empty
+	ContentType string    // detected from the extension of the name if empty, application/octet-stream if it is unknown
+	Path        string    // path of the file to upload
+	Content     io.Reader // content of the file, used instead of Path if set
+}
+
+// Options for building multipart bodies
+type MultipartOptions struct {
+	Boundary string // boundary between the parts, a random boundary is used if empty (a fixed boundary makes the body the same on every run)
+}
+
 /*
 Converts a type struct into a multipart form for use in HTTP requests
+
+Fields are written according to their multipart tag:
+- field: a form field. Slices and arrays are written as one part per element, and nested structs are flattened (e.g. address.city, items[0].name)
+- file: a file upload (see MultipartFile)
+- json: the value encoded as JSON in a single part with the application/json content type
+- custom: written by the value itself, which must implement MultipartFieldWriter
+
+The name of a part is the json tag of the field, or the name of the field if it has no json tag
+Parts are written in the order the fields are declared in the struct
+Fields with optional:"true" are skipped if they have the zero value
+The content_type and filename tags set the Content-Type and the file name of the parts of a field (e.g. `multipart:"json" content_type:"application/vnd.api+json" filename:"metadata.json"`)
 */
-func CreateMultipartBody(it interface{}) (*bytes.Buffer, string, error) {
+func CreateMultipartBody(it interface{}, opts ...MultipartOptions) (*bytes.Buffer, string, error) {
 	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
+	contentType, err := WriteMultipartBody(body, it, opts...)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, contentType, nil
+}
+
+// Writes the multipart form of the struct to w (see CreateMultipartBody), and returns its content type
+func WriteMultipartBody(w io.Writer, it interface{}, opts ...MultipartOptions) (string, error) {
+	fields, err := structFields(it)
+	if err != nil {
+		return "", err
+	}
+	writer, err := newMultipartWriter(w, opts...)
+	if err != nil {
+		return "", err
+	}
+	if err := writeMultipartFields(writer, fields); err != nil {
+		return "", err
+	}
+	return writer.FormDataContentType(), nil
+}
+
+/*
+Returns a reader that streams the multipart form of the struct (see CreateMultipartBody), and its content type
+The form is written while the reader is read, so large files are not held in memory (e.g. use the reader as the body of an http.Request)
+Errors found in the struct are returned immediately, and errors while writing the parts (e.g. a file that cannot be read) are returned by Read
+*/
+func NewMultipartReader(it interface{}, opts ...MultipartOptions) (io.ReadCloser, string, error) {
+	fields, err := structFields(it)
+	if err != nil {
+		return nil, "", err
+	}
+	pr, pw := io.Pipe()
+	writer, err := newMultipartWriter(pw, opts...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	go func() {
+		pw.CloseWithError(writeMultipartFields(writer, fields))
+	}()
+	return pr, writer.FormDataContentType(), nil
+}
+
+// Returns a multipart writer with the boundary of the options
+func newMultipartWriter(w io.Writer, opts ...MultipartOptions) (*multipart.Writer, error) {
+	writer := multipart.NewWriter(w)
+	if len(opts) > 0 && opts[0].Boundary != "" {
+		if err := writer.SetBoundary(opts[0].Boundary); err != nil {
+			return nil, errors.Wrap(err, "Invalid multipart boundary.")
+		}
+	}
+	return writer, nil
+}
 
+// Writes the fields in order and closes the writer
+func writeMultipartFields(writer *multipart.Writer, fields []FieldProperties) error {
 	// for each field in the struct, write it to a multipart form
-	fields := structFieldsMap(it)
-	for key, val := range fields {
-		switch val.MultipartType {
-		case "field":
-			if !val.Optional || !val.IsZero {
-				writer.WriteField(key, val.Value)
+	for _, val := range fields {
+		if err := writeMultipartField(writer, val); err != nil {
+			return err
+		}
+	}
+
+	err := writer.Close()
+	if err != nil {
+		return errors.Wrap(err, "Failed to write multipart/form http.")
+	}
+	return nil
+}
+
+// Writes the parts of a field
+func writeMultipartField(writer *multipart.Writer, val FieldProperties) error {
+	if val.Optional && val.IsZero {
+		return nil
+	}
+	key := val.Name
+
+	switch val.MultipartType {
+	case multipartField:
+		values := val.Values
+		if values == nil {
+			values = []string{val.Value}
+		}
+		for _, v := range values {
+			if err := writePart(writer, partHeader(key, val.FileName, val.ContentType), []byte(v)); err != nil {
+				return errors.Wrapf(err, "Failed to write multipart field %s.", key)
+			}
+		}
+	case multipartFile:
+		for _, f := range val.Files {
+			if f.Name == "" {
+				f.Name = val.FileName
+			}
+			if f.ContentType == "" {
+				f.ContentType = val.ContentType
 			}
-		case "custom":
-			if val.Ref != nil {
-				err := val.Ref.WriteField(writer)
-				if err != nil {
-					return nil, "", errors.Wrap(err, "Failed during custom multipart field write.")
-				}
+			if err := writeMultipartFile(writer, key, f); err != nil {
+				return err
+			}
+		}
+	case multipartJSON:
+		contentType := val.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		if err := writePart(writer, partHeader(key, val.FileName, contentType), val.JSON); err != nil {
+			return errors.Wrapf(err, "Failed to write multipart field %s.", key)
+		}
+	case multipartCustom:
+		if val.Ref != nil {
+			err := val.Ref.WriteField(writer)
+			if err != nil {
+				return errors.Wrap(err, "Failed during custom multipart field write.")
 			}
 		}
 	}
+	return nil
+}
 
-	err := writer.Close()
+// Writes a file part, reading the file from its path if it has no content
+func writeMultipartFile(writer *multipart.Writer, key string, f MultipartFile) error {
+	content := f.Content
+	if content == nil {
+		file, err := os.Open(f.Path)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to open the file of multipart field %s.", key)
+		}
+		defer file.Close()
+		content = file
+	}
+
+	name := f.fileName(key)
+	contentType := f.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(name))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	part, err := writer.CreatePart(partHeader(key, name, contentType))
+	if err == nil {
+		_, err = io.Copy(part, content)
+	}
 	if err != nil {
-		return nil, "", errors.Wrap(err, "Failed to write multipart/form http.")
+		return errors.Wrapf(err, "Failed to write the file of multipart field %s.", key)
 	}
+	return nil
+}
 
-	return body, writer.FormDataContentType(), nil
+// Writes a part with the content
+func writePart(writer *multipart.Writer, header textproto.MIMEHeader, content []byte) error {
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	_, err = part.Write(content)
+	return err
+}
+
+// Returns the file name of the part
+func (f MultipartFile) fileName(key string) string {
+	if f.Name != "" {
+		return f.Name
+	}
+	if f.Content == nil && f.Path != "" {
+		return filepath.Base(f.Path)
+	}
+	if named, ok := f.Content.(interface{ Name() string }); ok && named.Name() != "" {
+		return filepath.Base(named.Name())
+	}
+	return key
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// Returns the header of a form-data part, without Content-Type if contentType is empty
+func partHeader(key, fileName, contentType string) textproto.MIMEHeader {
+	disposition := fmt.Sprintf(`form-data; name="%s"`, quoteEscaper.Replace(key))
+	if fileName != "" {
+		disposition += fmt.Sprintf(`; filename="%s"`, quoteEscaper.Replace(fileName))
+	}
+
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", disposition)
+	if contentType != "" {
+		h.Set("Content-Type", contentType)
+	}
+	return h
 }
 
 /*
-Helper method to convert type struct data into FieldProperties type
+Helper method to convert type struct data into FieldProperties type, in the order the fields are declared
 */
-func structFieldsMap(it interface{}) map[string]FieldProperties {
-	fmap := make(map[string]FieldProperties)
-	val := reflect.ValueOf(it).Elem()
+func structFields(it interface{}) ([]FieldProperties, error) {
+	val := reflect.ValueOf(it)
+	if val.Kind() != reflect.Ptr || val.IsNil() || val.Elem().Kind() != reflect.Struct {
+		return nil, errors.Errorf("a multipart body must be a pointer to a struct, got %T", it)
+	}
+
+	var fields []FieldProperties
+	if err := addStructFields(&fields, "", val.Elem()); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// Adds the tagged fields of the struct to the list, with the prefix added to their names
+func addStructFields(fields *[]FieldProperties, prefix string, val reflect.Value) error {
 	multipartFieldWriterType := reflect.TypeOf((*MultipartFieldWriter)(nil)).Elem()
 
 	for i := 0; i < val.NumField(); i++ {
@@ -78,46 +304,210 @@ func structFieldsMap(it interface{}) map[string]FieldProperties {
 		fp := FieldProperties{}
 
 		// property: MultipartType
-		fp.MultipartType, _ = typeField.Tag.Lookup(multipartTag)
+		multipartType, ok := typeField.Tag.Lookup(multipartTag)
+		if !ok {
+			continue
+		}
+		fp.MultipartType = multipartType
+
+		itemKey := joinKey(prefix, multipartFieldName(typeField))
+		if typeField.PkgPath != "" {
+			return errors.Errorf("multipart field %s is not exported", itemKey)
+		}
+		fp.Name = itemKey
+
+		// property: ContentType, FileName
+		fp.ContentType = typeField.Tag.Get(contentTypeTag)
+		fp.FileName = typeField.Tag.Get(filenameTag)
 
 		// property: Optional
 		optionalTag, _ := typeField.Tag.Lookup(optionalTag)
 		fp.Optional = optionalTag == "true"
 
 		// property: IsZero
-		// property: Value
-		if fp.MultipartType == "field" {
-			fp.IsZero = isZero(valField)
-			fp.Value = fmt.Sprint(valField.Interface())
+		fp.IsZero = valField.IsZero()
+		if fp.Optional && fp.IsZero {
+			continue
+		}
+
+		switch fp.MultipartType {
+		case multipartField:
+			// property: Value, Values
+			if err := addFieldValues(fields, fp, valField); err != nil {
+				return err
+			}
+			continue
+		case multipartFile:
+			// property: Files
+			files, err := multipartFiles(itemKey, valField)
+			if err != nil {
+				return err
+			}
+			fp.Files = files
+		case multipartJSON:
+			// property: JSON
+			b, err := json.Marshal(valField.Interface())
+			if err != nil {
+				return errors.Wrapf(err, "Failed to encode multipart field %s.", itemKey)
+			}
+			fp.JSON = b
+		case multipartCustom:
+			// property: Ref
+			if !typeField.Type.Implements(multipartFieldWriterType) {
+				return errors.Errorf("multipart field %s does not implement MultipartFieldWriter", itemKey)
+			}
+			if !isNil(valField) {
+				fp.Ref = valField.Interface().(MultipartFieldWriter)
+			}
+		default:
+			return errors.Errorf("multipart field %s has an unknown type %q", itemKey, fp.MultipartType)
+		}
+		*fields = append(*fields, fp)
+	}
+	return nil
+}
+
+// Adds a form field to the list: a single value, the values of a slice or the fields of a nested struct
+func addFieldValues(fields *[]FieldProperties, fp FieldProperties, v reflect.Value) error {
+	key := fp.Name
+	value, ok, err := formValue(v)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to convert multipart field %s.", key)
+	}
+	if ok {
+		fp.Value = value
+		*fields = append(*fields, fp)
+		return nil
+	}
+
+	v = indirect(v)
+	switch v.Kind() {
+	case reflect.Struct:
+		return addStructFields(fields, key, v)
+	case reflect.Slice, reflect.Array:
+		// the values are added before the fields of struct elements, which are added while looping
+		index := len(*fields)
+		*fields = append(*fields, fp)
+		values := []string{}
+		for i := 0; i < v.Len(); i++ {
+			elem := v.Index(i)
+			value, ok, err := formValue(elem)
+			if err != nil {
+				return errors.Wrapf(err, "Failed to convert multipart field %s[%d].", key, i)
+			}
+			if ok {
+				values = append(values, value)
+				continue
+			}
+			if elem = indirect(elem); elem.Kind() != reflect.Struct {
+				return errors.Errorf("multipart field %s[%d] has an unsupported type %s", key, i, elem.Type())
+			}
+			if err := addStructFields(fields, fmt.Sprintf("%s[%d]", key, i), elem); err != nil {
+				return err
+			}
 		}
+		if len(values) == 0 && v.Len() > 0 {
+			// a slice of structs, whose fields were added instead
+			*fields = append((*fields)[:index], (*fields)[index+1:]...)
+			return nil
+		}
+		(*fields)[index].Values = values
+		return nil
+	default:
+		return errors.Errorf("multipart field %s has an unsupported type %s", key, v.Type())
+	}
+}
+
+// Returns the value of a form field, or false if the value is a struct or a slice
+func formValue(v reflect.Value) (string, bool, error) {
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return "", true, nil
+	}
+
+	switch x := v.Interface().(type) {
+	case fmt.Stringer:
+		return x.String(), true, nil
+	case encoding.TextMarshaler:
+		b, err := x.MarshalText()
+		return string(b), true, err
+	case []byte:
+		return string(x), true, nil
+	}
 
-		// property: Ref
-		if typeField.Type.Implements(multipartFieldWriterType) && !valField.IsNil() {
-			fp.Ref = valField.Interface().(MultipartFieldWriter)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return formValue(v.Elem())
+	case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return fmt.Sprint(v.Interface()), true, nil
+	}
+	return "", false, nil
+}
+
+// Returns the files of a file field
+func multipartFiles(key string, v reflect.Value) ([]MultipartFile, error) {
+	if isNil(v) {
+		return nil, nil
+	}
+
+	switch x := v.Interface().(type) {
+	case MultipartFile:
+		return []MultipartFile{x}, nil
+	case *MultipartFile:
+		return []MultipartFile{*x}, nil
+	case string:
+		if x == "" {
+			return nil, nil
 		}
+		return []MultipartFile{{Path: x}}, nil
+	case []byte:
+		return []MultipartFile{{Content: bytes.NewReader(x)}}, nil
+	case io.Reader:
+		return []MultipartFile{{Content: x}}, nil
+	}
 
-		itemKey := typeField.Name
-		jsonKey, jsonTagAvailable := typeField.Tag.Lookup(jsonTag)
-		if jsonTagAvailable {
-			itemKey = jsonKey
+	switch v.Kind() {
+	case reflect.Interface:
+		return multipartFiles(key, v.Elem())
+	case reflect.Slice, reflect.Array:
+		var files []MultipartFile
+		for i := 0; i < v.Len(); i++ {
+			f, err := multipartFiles(fmt.Sprintf("%s[%d]", key, i), v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, f...)
 		}
-		fmap[itemKey] = fp
+		return files, nil
+	}
+	return nil, errors.Errorf("multipart field %s has an unsupported file type %s", key, v.Type())
+}
+
+// Returns the name of the part of a field: its json tag, or the name of the field
+func multipartFieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get(jsonTag), ",")[0]
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+// Returns the value that v points to, or v if it is not a pointer or an interface
+func indirect(v reflect.Value) reflect.Value {
+	for (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && !v.IsNil() {
+		v = v.Elem()
 	}
-	return fmap
+	return v
 }
 
-// Modified version of https://stackoverflow.com/a/23555352
-// This version avoids recursion by not supporting Array and Struct.
-// If is a struct or array, this will panic.
-func isZero(v reflect.Value) bool {
+// Returns true if the value is a nil pointer, interface, slice or map
+// Unlike reflect.Value.IsNil, it does not panic for other kinds
+func isNil(v reflect.Value) bool {
 	switch v.Kind() {
-	case reflect.Func, reflect.Map, reflect.Slice:
+	case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map, reflect.Func, reflect.Chan:
 		return v.IsNil()
-	case reflect.Array, reflect.Struct:
-		panic("isZero: Array and Struct is not supported!")
 	default:
-		// concrete types and interface
-		z := reflect.Zero(v.Type())
-		return v.Interface() == z.Interface()
+		return !v.IsValid()
 	}
 }
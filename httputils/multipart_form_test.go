@@ -1,12 +1,19 @@
 package httputils
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"mime"
 	"mime/multipart"
+	"net/textproto"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type DemoStruct struct {
@@ -154,3 +161,308 @@ func Test_MultipartMultipleCustomFields(t *testing.T) {
 		t.Errorf("StructParamNil was set when it should not be, got value: %v", val)
 	}
 }
+
+// Parses a multipart body and returns its parts in order
+func readMultipartBody(t *testing.T, body *bytes.Buffer, contentType string) []*multipartPart {
+	_, params, err := mime.ParseMediaType(contentType)
+	require.Nil(t, err)
+
+	var parts []*multipartPart
+	reader := multipart.NewReader(body, params["boundary"])
+	for {
+		p, err := reader.NextPart()
+		if err == io.EOF {
+			return parts
+		}
+		require.Nil(t, err)
+		content, err := ioutil.ReadAll(p)
+		require.Nil(t, err)
+		parts = append(parts, &multipartPart{name: p.FormName(), fileName: p.FileName(), header: p.Header, content: string(content)})
+	}
+}
+
+type multipartPart struct {
+	name     string
+	fileName string
+	header   textproto.MIMEHeader
+	content  string
+}
+
+// Returns the parts with the name, in order
+func partsNamed(parts []*multipartPart, name string) []*multipartPart {
+	var named []*multipartPart
+	for _, p := range parts {
+		if p.name == name {
+			named = append(named, p)
+		}
+	}
+	return named
+}
+
+func Test_Multipart_ShouldWriteSlicesAndNestedStructs(t *testing.T) {
+	// Arrange
+	type address struct {
+		City string `json:"city" multipart:"field"`
+		Zip  string `json:"zip" multipart:"field" optional:"true"`
+	}
+	type item struct {
+		Name  string   `json:"name" multipart:"field"`
+		Count int      `json:"count" multipart:"field"`
+		Tags  []string `json:"tags" multipart:"field"`
+	}
+	in := struct {
+		Tags     []string       `json:"tags" multipart:"field"`
+		Scores   [2]float64     `json:"scores" multipart:"field"`
+		Address  address        `json:"address" multipart:"field"`
+		Billing  *address       `json:"billing" multipart:"field" optional:"true"`
+		Items    []item         `json:"items" multipart:"field"`
+		Raw      []byte         `json:"raw" multipart:"field"`
+		Nickname *string        `json:"nickname,omitempty" multipart:"field"`
+		Stringer []fmt.Stringer `json:"stringers" multipart:"field"`
+		Ignored  string
+	}{
+		Tags:     []string{"a", "b", "c"},
+		Scores:   [2]float64{1.5, 2},
+		Address:  address{City: "Tokyo"},
+		Items:    []item{{Name: "x", Count: 1, Tags: []string{"new"}}, {Name: "y", Count: 2}},
+		Raw:      []byte("raw value"),
+		Stringer: []fmt.Stringer{DemoStringer("s")},
+		Ignored:  "ignored",
+	}
+
+	// Act
+	body, contentType, err := CreateMultipartBody(&in)
+
+	// Assert
+	require.Nil(t, err)
+	form := map[string][]string{}
+	for _, p := range readMultipartBody(t, body, contentType) {
+		form[p.name] = append(form[p.name], p.content)
+	}
+	assert.Equal(t, map[string][]string{
+		"tags":           {"a", "b", "c"},
+		"scores":         {"1.5", "2"},
+		"address.city":   {"Tokyo"},
+		"items[0].name":  {"x"},
+		"items[0].count": {"1"},
+		"items[0].tags":  {"new"},
+		"items[1].name":  {"y"},
+		"items[1].count": {"2"},
+		"raw":            {"raw value"},
+		"nickname":       {""},
+		"stringers":      {"s"},
+	}, form)
+}
+
+func Test_Multipart_ShouldWriteFilesAndJSON(t *testing.T) {
+	// Arrange
+	path := filepath.Join(t.TempDir(), "avatar.png")
+	require.Nil(t, ioutil.WriteFile(path, []byte("png"), 0644))
+	in := struct {
+		Avatar      string          `json:"avatar" multipart:"file"`
+		Attachments []MultipartFile `json:"attachments" multipart:"file"`
+		Data        []byte          `json:"data" multipart:"file"`
+		Reader      io.Reader       `json:"reader" multipart:"file"`
+		Missing     io.Reader       `json:"missing" multipart:"file" optional:"true"`
+		Metadata    interface{}     `json:"metadata" multipart:"json"`
+	}{
+		Avatar: path,
+		Attachments: []MultipartFile{
+			{Name: "a.txt", Content: strings.NewReader("text")},
+			{Name: "b", ContentType: "application/pdf", Content: strings.NewReader("pdf")},
+		},
+		Data:     []byte("data"),
+		Reader:   strings.NewReader("reader"),
+		Metadata: map[string]interface{}{"id": 1, "tags": []string{"a"}},
+	}
+
+	// Act
+	body, contentType, err := CreateMultipartBody(&in)
+
+	// Assert
+	require.Nil(t, err)
+	parts := readMultipartBody(t, body, contentType)
+	assert.Len(t, parts, 6)
+
+	avatar := partsNamed(parts, "avatar")
+	require.Len(t, avatar, 1)
+	assert.Equal(t, "avatar.png", avatar[0].fileName)
+	assert.Equal(t, "image/png", avatar[0].header.Get("Content-Type"))
+	assert.Equal(t, "png", avatar[0].content)
+
+	attachments := partsNamed(parts, "attachments")
+	require.Len(t, attachments, 2)
+	assert.Equal(t, "a.txt", attachments[0].fileName)
+	assert.Contains(t, attachments[0].header.Get("Content-Type"), "text/plain")
+	assert.Equal(t, "text", attachments[0].content)
+	assert.Equal(t, "b", attachments[1].fileName)
+	assert.Equal(t, "application/pdf", attachments[1].header.Get("Content-Type"))
+
+	data := partsNamed(parts, "data")
+	require.Len(t, data, 1)
+	assert.Equal(t, "data", data[0].fileName)
+	assert.Equal(t, "application/octet-stream", data[0].header.Get("Content-Type"))
+	assert.Equal(t, "data", data[0].content)
+	assert.Equal(t, "reader", partsNamed(parts, "reader")[0].content)
+
+	metadata := partsNamed(parts, "metadata")
+	require.Len(t, metadata, 1)
+	assert.Equal(t, "", metadata[0].fileName)
+	assert.Equal(t, "application/json", metadata[0].header.Get("Content-Type"))
+	assert.JSONEq(t, `{"id": 1, "tags": ["a"]}`, metadata[0].content)
+}
+
+func Test_Multipart_ShouldReturnErrors(t *testing.T) {
+	type unexported struct {
+		name string `multipart:"field"`
+	}
+	cases := map[string]interface{}{
+		"not a pointer": struct{}{},
+		"nil pointer":   (*DemoStruct)(nil),
+		"unknown type": &struct {
+			A string `multipart:"text"`
+		}{},
+		"unsupported field type": &struct {
+			A map[string]string `multipart:"field"`
+		}{A: map[string]string{}},
+		"unsupported element": &struct {
+			A [][]string `multipart:"field"`
+		}{A: [][]string{{"a"}}},
+		"unsupported file type": &struct {
+			A int `multipart:"file"`
+		}{A: 1},
+		"missing file": &struct {
+			A string `multipart:"file"`
+		}{A: filepath.Join(t.TempDir(), "missing.txt")},
+		"invalid json": &struct {
+			A func() `multipart:"json"`
+		}{A: func() {}},
+		"not a writer": &struct {
+			A string `multipart:"custom"`
+		}{},
+		"unexported": &unexported{name: "a"},
+	}
+
+	for name, in := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := CreateMultipartBody(in)
+
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func Test_Multipart_ShouldKeepDeclarationOrder(t *testing.T) {
+	// Arrange
+	type item struct {
+		Name string `json:"name" multipart:"field"`
+	}
+	in := struct {
+		Z     string      `json:"z" multipart:"field"`
+		A     []string    `json:"a" multipart:"field"`
+		M     string      `json:"m" multipart:"file"`
+		Items []item      `json:"items" multipart:"field"`
+		B     interface{} `json:"b" multipart:"json"`
+		C     *DemoStruct `json:"c" multipart:"custom"`
+		Y     int         `json:"y" multipart:"field"`
+	}{
+		Z:     "z",
+		A:     []string{"a1", "a2"},
+		Items: []item{{"i1"}, {"i2"}},
+		B:     true,
+		C:     &DemoStruct{Key: "c", Value: "c"},
+	}
+	opts := MultipartOptions{Boundary: "test-boundary"}
+
+	// Act
+	first, contentType, err := CreateMultipartBody(&in, opts)
+	require.Nil(t, err)
+	second, _, err := CreateMultipartBody(&in, opts)
+	require.Nil(t, err)
+
+	// Assert
+	assert.Equal(t, first.String(), second.String())
+	assert.Equal(t, "multipart/form-data; boundary=test-boundary", contentType)
+	var names []string
+	for _, p := range readMultipartBody(t, first, contentType) {
+		names = append(names, p.name)
+	}
+	assert.Equal(t, []string{"z", "a", "a", "items[0].name", "items[1].name", "b", "c", "y"}, names)
+}
+
+func Test_Multipart_ShouldSetPartHeadersFromTags(t *testing.T) {
+	// Arrange
+	in := struct {
+		Text     string          `json:"text" multipart:"field" content_type:"text/plain; charset=utf-8"`
+		CSV      string          `json:"csv" multipart:"field" filename:"data.csv"`
+		Image    []byte          `json:"image" multipart:"file" content_type:"image/jpeg" filename:"photo.jpg"`
+		Document MultipartFile   `json:"document" multipart:"file" content_type:"application/pdf"`
+		Metadata map[string]bool `json:"metadata" multipart:"json" content_type:"application/vnd.api+json" filename:"metadata.json"`
+	}{
+		Text:     "text",
+		CSV:      "a,b",
+		Image:    []byte("jpeg"),
+		Document: MultipartFile{Name: "doc.txt", ContentType: "text/plain", Content: strings.NewReader("doc")},
+		Metadata: map[string]bool{"public": true},
+	}
+
+	// Act
+	body, contentType, err := CreateMultipartBody(&in)
+
+	// Assert
+	require.Nil(t, err)
+	parts := readMultipartBody(t, body, contentType)
+	require.Len(t, parts, 5)
+	assert.Equal(t, "text/plain; charset=utf-8", parts[0].header.Get("Content-Type"))
+	assert.Equal(t, "", parts[0].fileName)
+	assert.Equal(t, "data.csv", parts[1].fileName)
+	assert.Equal(t, "", parts[1].header.Get("Content-Type"))
+	assert.Equal(t, "photo.jpg", parts[2].fileName)
+	assert.Equal(t, "image/jpeg", parts[2].header.Get("Content-Type"))
+	// the values of a MultipartFile take precedence over the tags
+	assert.Equal(t, "doc.txt", parts[3].fileName)
+	assert.Equal(t, "text/plain", parts[3].header.Get("Content-Type"))
+	assert.Equal(t, "metadata.json", parts[4].fileName)
+	assert.Equal(t, "application/vnd.api+json", parts[4].header.Get("Content-Type"))
+}
+
+func Test_NewMultipartReader_ShouldStreamBody(t *testing.T) {
+	// Arrange
+	large := bytes.Repeat([]byte("0123456789"), 100000)
+	in := struct {
+		Title string `json:"title" multipart:"field"`
+		File  []byte `json:"file" multipart:"file"`
+	}{Title: "large", File: large}
+	opts := MultipartOptions{Boundary: "test-boundary"}
+	expected, _, err := CreateMultipartBody(&in, opts)
+	require.Nil(t, err)
+
+	// Act
+	reader, contentType, err := NewMultipartReader(&in, opts)
+	require.Nil(t, err)
+	defer reader.Close()
+	body, err := ioutil.ReadAll(reader)
+
+	// Assert
+	require.Nil(t, err)
+	assert.Equal(t, "multipart/form-data; boundary=test-boundary", contentType)
+	assert.Equal(t, expected.Bytes(), body)
+}
+
+func Test_NewMultipartReader_ShouldReturnErrors(t *testing.T) {
+	// invalid structs are reported immediately
+	_, _, err := NewMultipartReader(&struct {
+		A string `multipart:"text"`
+	}{})
+	assert.NotNil(t, err)
+	_, _, err = NewMultipartReader(&struct{}{}, MultipartOptions{Boundary: "invalid boundary!"})
+	assert.NotNil(t, err)
+
+	// errors while writing are returned by Read
+	reader, _, err := NewMultipartReader(&struct {
+		A string `multipart:"file"`
+	}{A: filepath.Join(t.TempDir(), "missing.txt")})
+	require.Nil(t, err)
+	_, err = ioutil.ReadAll(reader)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,302 @@
+package intruder
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/mercari/testdeck"
+)
+
+/*
+attack.go: Attack modes that inject payloads into several fields of a request at the same time (similar to Burpsuite's attack types)
+*/
+
+// Attack modes of json data sets
+const (
+	ModeSniper       = "sniper"        // one field at a time, the other fields keep their sample values (default)
+	ModeBatteringRam = "battering ram" // the same payload in every field
+	ModePitchfork    = "pitchfork"     // the nth payload of each field's list in the nth request
+	ModeClusterBomb  = "cluster bomb"  // every combination of the payloads of each field
+)
+
+// The maximum number of requests sent by a battering ram, pitchfork or cluster bomb attack if maxRequests is not set
+const DefaultMaxRequests = 1000
+
+// returned by the send function of an attack if the request could not be sent (e.g. a header value with a line break)
+var errSkipped = errors.New("request was skipped")
+
+// Returns the attack mode of the data set (sniper if it is not set)
+func (s JsonDataSet) mode() string {
+	mode := strings.ToLower(strings.TrimSpace(s.Mode))
+	if mode == "" {
+		return ModeSniper
+	}
+	return mode
+}
+
+// Returns the maximum number of requests of the data set
+func (s JsonDataSet) maxRequests() int {
+	if s.MaxRequests <= 0 {
+		return DefaultMaxRequests
+	}
+	return s.MaxRequests
+}
+
+// Returns the payload files for the field
+func (s JsonDataSet) filesFor(field string) []string {
+	if files, ok := s.FieldFiles[field]; ok {
+		return files
+	}
+	return s.Files
+}
+
+// A data set that attacks several fields at the same time, and the type of the fields it is used for
+type attackSet struct {
+	kind reflect.Kind
+	set  JsonDataSet
+}
+
+// Splits the data sets into sniper data sets, which attack one field at a time, and data sets of the other attack modes
+func (d InputValidationTestData) splitModes() (InputValidationTestData, []attackSet) {
+	var sniper InputValidationTestData
+	var attacks []attackSet
+
+	split := func(sets []JsonDataSet, kind reflect.Kind) []JsonDataSet {
+		var sniperSets []JsonDataSet
+		for _, set := range sets {
+			if set.mode() == ModeSniper {
+				sniperSets = append(sniperSets, set)
+			} else {
+				attacks = append(attacks, attackSet{kind, set})
+			}
+		}
+		return sniperSets
+	}
+	sniper.Strings = split(d.Strings, reflect.String)
+	sniper.Ints = split(d.Ints, reflect.Int)
+	sniper.Floats = split(d.Floats, reflect.Float64)
+	sniper.Bools = split(d.Bools, reflect.Bool)
+
+	return sniper, attacks
+}
+
+// Returns true if there are no data sets
+func (d InputValidationTestData) empty() bool {
+	return len(d.Strings) == 0 && len(d.Ints) == 0 && len(d.Floats) == 0 && len(d.Bools) == 0
+}
+
+// Returns the kind of the data sets that are used for fields of this kind (e.g. int32 fields use the int data sets)
+func kindGroup(kind reflect.Kind) reflect.Kind {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return reflect.Int
+	case reflect.Float32, reflect.Float64:
+		return reflect.Float64
+	}
+	return kind
+}
+
+// Returns the payloads of each request of the attack
+// payloads are the lists of payloads for each field (battering ram only uses the first list)
+// At most maxRequests requests are returned; truncated is true if the attack has more requests than that
+// In cluster bomb mode, the payload of the last field changes fastest
+func AttackRequests(mode string, payloads [][]interface{}, maxRequests int) (requests [][]interface{}, truncated bool, err error) {
+	if len(payloads) == 0 {
+		return nil, false, nil
+	}
+
+	// adds a request, or returns false if the limit has been reached
+	add := func(r []interface{}) bool {
+		if maxRequests > 0 && len(requests) >= maxRequests {
+			return false
+		}
+		requests = append(requests, r)
+		return true
+	}
+
+	switch mode {
+	case ModeBatteringRam:
+		for _, p := range payloads[0] {
+			r := make([]interface{}, len(payloads))
+			for i := range r {
+				r[i] = p
+			}
+			if !add(r) {
+				return requests, true, nil
+			}
+		}
+	case ModePitchfork:
+		// stop at the end of the shortest list
+		n := len(payloads[0])
+		for _, list := range payloads {
+			if len(list) < n {
+				n = len(list)
+			}
+		}
+		for i := 0; i < n; i++ {
+			r := make([]interface{}, len(payloads))
+			for j := range payloads {
+				r[j] = payloads[j][i]
+			}
+			if !add(r) {
+				return requests, true, nil
+			}
+		}
+	case ModeClusterBomb:
+		for _, list := range payloads {
+			if len(list) == 0 {
+				return nil, false, nil
+			}
+		}
+		index := make([]int, len(payloads))
+		for {
+			r := make([]interface{}, len(payloads))
+			for j := range payloads {
+				r[j] = payloads[j][index[j]]
+			}
+			if !add(r) {
+				return requests, true, nil
+			}
+
+			// move on to the next combination, like an odometer
+			j := len(index) - 1
+			for ; j >= 0; j-- {
+				index[j]++
+				if index[j] < len(payloads[j]) {
+					break
+				}
+				index[j] = 0
+			}
+			if j < 0 {
+				break
+			}
+		}
+	default:
+		return nil, false, fmt.Errorf("unknown attack mode %q (expected %q, %q or %q)", mode, ModeBatteringRam, ModePitchfork, ModeClusterBomb)
+	}
+
+	return requests, false, nil
+}
+
+// Sends every request of a battering ram, pitchfork or cluster bomb attack and verifies the responses
+// names and kinds are the names and types of the fields that are attacked
+// send injects the payloads into the fields (in the same order as names) and sends the request
+func runAttack(t *testdeck.TD, set JsonDataSet, endpoint string, names []string, kinds []reflect.Kind, opts IntruderOptions, send func(payloads []interface{}) (interface{}, error)) {
+	if err := set.validateProcessors(); err != nil {
+		t.Errorf("Invalid processors in %s data set: %s", set.Type, err.Error())
+		return
+	}
+	// battering ram sends the same payload to every field, so the fields must all take payloads of the same type
+	if set.mode() == ModeBatteringRam {
+		for i := range kinds {
+			if kindGroup(kinds[i]) != kindGroup(kinds[0]) {
+				t.Errorf("The %s attack on %s needs fields of the same type, but %s is %s and %s is %s", set.mode(), strings.Join(names, ","), names[0], kinds[0], names[i], kinds[i])
+				return
+			}
+		}
+	}
+
+	var lists [][]interface{}
+	for i, name := range names {
+		var list []interface{}
+		for _, file := range set.filesFor(name) {
+			payloads, err := GetPayloadsFromTextFile(file, kinds[i])
+			if err != nil {
+				t.Errorf("Failed to read payloads from %s: %s", file, err.Error())
+				continue
+			}
+			list = append(list, payloads...)
+		}
+		lists = append(lists, list)
+	}
+
+	requests, truncated, err := AttackRequests(set.mode(), lists, set.maxRequests())
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if truncated {
+		t.Logf("The %s attack has more than %d requests, only the first %d will be sent", set.mode(), set.maxRequests(), set.maxRequests())
+	}
+
+	field := strings.Join(names, ",")
+	for _, payloads := range requests {
+		// point SSRF and out-of-band payloads to the canary with a unique token, so that callbacks can be linked to this request
+		var token string
+		if opts.Canary != nil && hasCanaryPlaceholder(payloads) {
+			token = opts.Canary.NewToken(TokenSource{Category: set.Type, Endpoint: endpoint, Field: field, Payload: describePayloads(names, payloads)})
+		}
+		raw := make([]interface{}, len(payloads))
+		processed := make([]interface{}, len(payloads))
+		for i, p := range payloads {
+			if s, ok := p.(string); ok && token != "" {
+				p = opts.Canary.Expand(s, token)
+			}
+			raw[i] = p
+			processed[i] = set.process(p)
+		}
+
+		t.Logf("Values: %s", describePayloads(names, processed))
+		start := time.Now()
+		res, err := send(processed)
+		if err == errSkipped {
+			continue
+		}
+
+		attempt := Attempt{
+			Endpoint: endpoint,
+			Field:    field,
+			Payload:  describePayloads(names, raw),
+			Payloads: distinctStrings(raw),
+			Response: res,
+			Duration: time.Since(start),
+			Err:      err,
+		}
+		if len(set.Processors) > 0 {
+			attempt.ProcessedPayloads = distinctStrings(processed)
+		}
+		if token != "" {
+			attempt.CanaryInteractions = opts.Canary.Claim(token)
+		}
+
+		for _, f := range VerifyAttempt(t, set, attempt) {
+			opts.Report.Add(f)
+		}
+	}
+}
+
+// Returns true if any of the payloads contains a canary placeholder
+func hasCanaryPlaceholder(payloads []interface{}) bool {
+	for _, p := range payloads {
+		if s, ok := p.(string); ok && HasCanaryPlaceholder(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the payloads of a request in a readable form (e.g. MessageId="1", MessageBody="<b>")
+func describePayloads(names []string, payloads []interface{}) string {
+	var parts []string
+	for i, name := range names {
+		parts = append(parts, fmt.Sprintf("%s=%q", name, fmt.Sprint(payloads[i])))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Returns the payloads as strings without duplicates
+func distinctStrings(payloads []interface{}) []string {
+	var distinct []string
+	seen := map[string]bool{}
+	for _, p := range payloads {
+		s := fmt.Sprint(p)
+		if !seen[s] {
+			seen[s] = true
+			distinct = append(distinct, s)
+		}
+	}
+	return distinct
+}
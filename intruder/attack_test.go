@@ -0,0 +1,188 @@
+package intruder
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/mercari/testdeck"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type demoSayRequest struct {
+	Id    string
+	Body  string
+	Count int32
+}
+
+type demoSayClient struct {
+	mu       sync.Mutex
+	requests []demoSayRequest
+}
+
+func (c *demoSayClient) Say(ctx context.Context, req *demoSayRequest) (*demoSayRequest, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests = append(c.requests, *req)
+	return &demoSayRequest{Id: "ok"}, nil
+}
+
+func Test_AttackRequests(t *testing.T) {
+	payloads := [][]interface{}{{"a", "b"}, {"1", "2", "3"}}
+
+	tests := []struct {
+		mode string
+		want [][]interface{}
+	}{
+		{ModeBatteringRam, [][]interface{}{{"a", "a"}, {"b", "b"}}},
+		{ModePitchfork, [][]interface{}{{"a", "1"}, {"b", "2"}}},
+		{ModeClusterBomb, [][]interface{}{{"a", "1"}, {"a", "2"}, {"a", "3"}, {"b", "1"}, {"b", "2"}, {"b", "3"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			// Act
+			got, truncated, err := AttackRequests(tt.mode, payloads, DefaultMaxRequests)
+
+			// Assert
+			require.Nil(t, err)
+			assert.False(t, truncated)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_AttackRequests_ShouldStopAtMaxRequests(t *testing.T) {
+	// Arrange
+	payloads := [][]interface{}{{1, 2, 3}, {4, 5, 6}, {7, 8, 9}}
+
+	// Act
+	got, truncated, err := AttackRequests(ModeClusterBomb, payloads, 4)
+
+	// Assert
+	require.Nil(t, err)
+	assert.True(t, truncated)
+	assert.Equal(t, [][]interface{}{{1, 4, 7}, {1, 4, 8}, {1, 4, 9}, {1, 5, 7}}, got)
+}
+
+func Test_AttackRequests_ShouldReturnErrorForUnknownMode(t *testing.T) {
+	_, _, err := AttackRequests("shotgun", [][]interface{}{{"a"}}, 0)
+
+	assert.NotNil(t, err)
+}
+
+func Test_ParseInputValidationJson_ShouldParseAttackMode(t *testing.T) {
+	// Arrange
+	file := filepath.Join(t.TempDir(), "testdata.json")
+	json := `{"string": [{"files": ["a.txt"], "type": "input validation", "mode": "Cluster Bomb", "fields": ["Id", "Body"], "fieldFiles": {"Id": ["b.txt"]}, "maxRequests": 50}]}`
+	require.Nil(t, ioutil.WriteFile(file, []byte(json), 0644))
+
+	// Act
+	data, err := ParseInputValidationTestDataFromJson(file)
+
+	// Assert
+	require.Nil(t, err)
+	set := data.Strings[0]
+	assert.Equal(t, ModeClusterBomb, set.mode())
+	assert.Equal(t, []string{"Id", "Body"}, set.Fields)
+	assert.Equal(t, []string{"b.txt"}, set.filesFor("Id"))
+	assert.Equal(t, []string{"a.txt"}, set.filesFor("Body"))
+	assert.Equal(t, 50, set.maxRequests())
+}
+
+func Test_RunIntruderTests_ClusterBombShouldSendEveryCombination(t *testing.T) {
+	// Arrange
+	client := &demoSayClient{}
+	sample := &demoSayRequest{Id: "1", Body: "hello", Count: 1}
+	data := InputValidationTestData{
+		Strings: []JsonDataSet{{
+			Type: TypeInputValidation,
+			Mode: ModeClusterBomb,
+			FieldFiles: map[string][]string{
+				"Id":   {"../payloads/input_validation/booleans.txt"},
+				"Body": {"../payloads/input_validation/integers.txt"},
+			},
+		}},
+	}
+	report := NewReport()
+
+	// Act
+	t.Run("Intruder", func(t *testing.T) {
+		RunIntruderTests(t, context.Background(), testdeck.TestCase{}, client, "Say", sample, data, IntruderOptions{Report: report})
+	})
+
+	// Assert
+	var got []demoSayRequest
+	for _, id := range []string{"true", "false"} {
+		for _, body := range []string{"0", "10", "-1", "9223372036854775807", "-9223372036854775808"} {
+			got = append(got, demoSayRequest{Id: id, Body: body, Count: 1})
+		}
+	}
+	assert.ElementsMatch(t, got, client.requests)
+	assert.Equal(t, &demoSayRequest{Id: "1", Body: "hello", Count: 1}, sample)
+	assert.Empty(t, report.Findings())
+}
+
+func Test_RunIntruderTests_ShouldNotAttackFieldsOneByOneInOtherModes(t *testing.T) {
+	// Arrange
+	client := &demoSayClient{}
+	data := InputValidationTestData{
+		Strings: []JsonDataSet{{
+			Files:  []string{"../payloads/input_validation/booleans.txt"},
+			Type:   TypeInputValidation,
+			Mode:   ModeBatteringRam,
+			Fields: []string{"Id", "Body"},
+		}},
+	}
+
+	// Act
+	t.Run("Intruder", func(t *testing.T) {
+		RunIntruderTests(t, context.Background(), testdeck.TestCase{}, client, "Say", &demoSayRequest{}, data, IntruderOptions{Report: NewReport()})
+	})
+
+	// Assert
+	assert.ElementsMatch(t, []demoSayRequest{{Id: "true", Body: "true"}, {Id: "false", Body: "false"}}, client.requests)
+}
+
+func Test_runAttack_BatteringRamShouldRejectFieldsOfDifferentTypes(t *testing.T) {
+	// Arrange
+	rt := &recordingT{T: t}
+	set := JsonDataSet{
+		Files: []string{"../payloads/input_validation/booleans.txt"},
+		Type:  TypeInputValidation,
+		Mode:  ModeBatteringRam,
+	}
+	sent := 0
+
+	// Act
+	runAttack(&testdeck.TD{T: rt}, set, "Say", []string{"Body", "Count"}, []reflect.Kind{reflect.String, reflect.Int32}, IntruderOptions{Report: NewReport()}, func(payloads []interface{}) (interface{}, error) {
+		sent++
+		return nil, nil
+	})
+
+	// Assert
+	assert.Equal(t, 0, sent)
+	assert.True(t, failedWith(rt.failures, "needs fields of the same type"))
+}
+
+func Test_injectPayload_ShouldRejectPayloadsOfAnotherKind(t *testing.T) {
+	// Arrange
+	req := &demoSayRequest{Id: "1", Body: "hello", Count: 1}
+
+	// Act
+	errString := injectPayload(req, "Count", "<script>")
+	errInt := injectPayload(req, "Body", 65)
+	errMissing := injectPayload(req, "Missing", "x")
+	errOK := injectPayload(req, "Count", 10)
+
+	// Assert
+	assert.NotNil(t, errString)
+	assert.NotNil(t, errInt, "an int payload should not be turned into a one-rune string")
+	assert.NotNil(t, errMissing)
+	assert.Nil(t, errOK)
+	assert.Equal(t, &demoSayRequest{Id: "1", Body: "hello", Count: 10}, req)
+}
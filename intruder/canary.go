@@ -0,0 +1,466 @@
+package intruder
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+canary.go: A local out-of-band interaction server (HTTP and DNS) for detecting SSRF and blind vulnerabilities
+
+Every payload that contains a canary placeholder gets a unique token. If the service under test calls the canary
+(e.g. fetches a URL or resolves a host name) the token in the callback tells us which field and payload triggered it.
+By default the canary only listens on the loopback interface, and it does not depend on any third-party service.
+Services in containers or on other hosts can reach it if it listens on another interface (CanaryOptions.ListenAddr)
+and placeholders are replaced with an address they can reach (CanaryOptions.PublicHost).
+*/
+
+// Placeholders in payload files that are replaced with the address of the canary
+const (
+	CanaryPlaceholder       = "{{canary}}"        // replaced with the public host:port of the HTTP listener (e.g. 127.0.0.1:45678)
+	CanaryPortPlaceholder   = "{{canary_port}}"   // replaced with the port of the HTTP listener
+	CanaryTokenPlaceholder  = "{{canary_token}}"  // replaced with the unique token of the payload
+	CanaryURLPlaceholder    = "{{canary_url}}"    // replaced with http://host:port/<token>
+	CanaryDomainPlaceholder = "{{canary_domain}}" // replaced with <token>.<domain>, which is resolved by the DNS listener
+)
+
+// DefaultCanaryDomain is the domain served by the DNS listener (.test is reserved for testing by RFC 2606)
+const DefaultCanaryDomain = "canary.test"
+
+// Protocols of interactions
+const (
+	ProtocolHTTP = "http"
+	ProtocolDNS  = "dns"
+)
+
+// the maximum number of bytes of a request body that is searched for tokens
+const maxCanaryBodySize = 64 * 1024
+
+var reCanaryToken = regexp.MustCompile(`td[0-9a-f]{12}`)
+
+// Represents configurable options for the canary
+type CanaryOptions struct {
+	Domain     string // the domain served by the DNS listener (DefaultCanaryDomain is used if empty)
+	DisableDNS bool   // do not start the DNS listener
+	ListenAddr string // the IP address that the listeners listen on (127.0.0.1 if empty), e.g. 0.0.0.0 for callbacks from containers or other hosts
+
+	// the host name or IP address that the service under test reaches the canary at, optionally with a port
+	// (e.g. host.docker.internal or a port-forwarded 10.0.0.5:8080), which placeholders are replaced with
+	// If it is an IPv4 address, the DNS listener also resolves names to it
+	// Required if ListenAddr is 0.0.0.0 or ::, the listen address is used if empty
+	PublicHost string
+}
+
+// TokenSource is where a canary token was injected
+type TokenSource struct {
+	Category string    // the json data set type (e.g. "ssrf")
+	Endpoint string    // the rpc method that was tested
+	Field    string    // the request field the payload was injected into
+	Payload  string    // the payload (before placeholders were replaced)
+	Issued   time.Time // when the token was created
+}
+
+// Interaction is a callback received by the canary
+type Interaction struct {
+	Protocol   string      // ProtocolHTTP or ProtocolDNS
+	Token      string      // the token found in the callback (empty if no known token was found)
+	Time       time.Time   // when the callback was received
+	RemoteAddr string      // the address the callback came from
+	Details    string      // e.g. "GET /td0123456789ab" or "A td0123456789ab.canary.test"
+	Source     TokenSource // where the token was injected (zero value if the token is unknown)
+}
+
+// Returns a short description of the interaction for use as evidence in findings
+func (i Interaction) String() string {
+	return fmt.Sprintf("%s callback %q from %s", strings.ToUpper(i.Protocol), i.Details, i.RemoteAddr)
+}
+
+// Canary is a local HTTP and DNS listener that records every callback it receives
+type Canary struct {
+	domain     string
+	publicAddr string // the host:port that placeholders are replaced with
+	dnsIP      net.IP // the IPv4 address that the DNS listener resolves names to
+	listener   net.Listener
+	server     *http.Server
+	dns        net.PacketConn
+
+	mu           sync.Mutex
+	tokens       map[string]TokenSource
+	interactions []Interaction
+	claimed      []bool // claimed[i] is true if interactions[i] has been added to a finding
+}
+
+// Starts a canary that listens on random ports of the loopback interface (or of CanaryOptions.ListenAddr)
+// Close the canary with t.Cleanup() so that it is still running while the intruder's subtests run
+// opts are configurations for the canary, if not included the default settings will be used
+func StartCanary(opts ...CanaryOptions) (*Canary, error) {
+	var o CanaryOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	if o.Domain == "" {
+		o.Domain = DefaultCanaryDomain
+	}
+
+	if o.ListenAddr == "" {
+		o.ListenAddr = "127.0.0.1"
+	}
+	listenIP := net.ParseIP(o.ListenAddr)
+	if listenIP == nil {
+		return nil, fmt.Errorf("invalid canary listen address %q, it must be an IP address", o.ListenAddr)
+	}
+	if listenIP.IsUnspecified() && o.PublicHost == "" {
+		return nil, fmt.Errorf("the canary listens on all interfaces (%s), so PublicHost must be set to the address the service under test reaches it at", o.ListenAddr)
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(o.ListenAddr, "0"))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Canary{
+		domain:     strings.ToLower(strings.TrimSuffix(o.Domain, ".")),
+		publicAddr: publicAddr(o.PublicHost, listener.Addr().String()),
+		dnsIP:      net.IPv4(127, 0, 0, 1).To4(),
+		listener:   listener,
+		tokens:     make(map[string]TokenSource),
+	}
+	publicHost, _, _ := net.SplitHostPort(c.publicAddr)
+	if ip := net.ParseIP(publicHost).To4(); ip != nil && !ip.IsUnspecified() {
+		c.dnsIP = ip
+	}
+	c.server = &http.Server{Handler: http.HandlerFunc(c.serveHTTP)}
+	go c.server.Serve(listener)
+
+	if !o.DisableDNS {
+		c.dns, err = net.ListenPacket("udp", net.JoinHostPort(o.ListenAddr, "0"))
+		if err != nil {
+			c.server.Close()
+			return nil, err
+		}
+		go c.serveDNS()
+	}
+
+	return c, nil
+}
+
+// Returns the public host:port of the HTTP listener, which the service under test reaches it at
+func (c *Canary) Addr() string {
+	return c.publicAddr
+}
+
+// Returns the host:port that the HTTP listener listens on (e.g. for calling the canary from the test itself)
+func (c *Canary) LocalAddr() string {
+	return c.listener.Addr().String()
+}
+
+// Returns the public port of the HTTP listener
+func (c *Canary) Port() string {
+	_, port, _ := net.SplitHostPort(c.Addr())
+	return port
+}
+
+// Returns the public base URL of the HTTP listener (e.g. http://127.0.0.1:45678)
+func (c *Canary) URL() string {
+	return "http://" + c.Addr()
+}
+
+// Returns the public host with the port of the listener if it has none, or the listen address if there is no public host
+func publicAddr(publicHost string, listenAddr string) string {
+	if publicHost == "" {
+		return listenAddr
+	}
+	if _, _, err := net.SplitHostPort(publicHost); err == nil {
+		return publicHost
+	}
+	_, port, _ := net.SplitHostPort(listenAddr)
+	return net.JoinHostPort(strings.Trim(publicHost, "[]"), port)
+}
+
+// Returns host:port of the DNS listener, or an empty string if DNS is disabled
+// The service under test must use this address as its DNS server for DNS callbacks to be detected
+func (c *Canary) DNSAddr() string {
+	if c.dns == nil {
+		return ""
+	}
+	return c.dns.LocalAddr().String()
+}
+
+// Returns the domain served by the DNS listener
+func (c *Canary) Domain() string {
+	return c.domain
+}
+
+// Creates a unique token and remembers where it will be injected
+func (c *Canary) NewToken(source TokenSource) string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand should never fail, but fall back to the time so that tokens are still unique enough
+		b = []byte(fmt.Sprintf("%012x", time.Now().UnixNano()))[:6]
+	}
+	token := "td" + hex.EncodeToString(b)
+
+	if source.Issued.IsZero() {
+		source.Issued = time.Now()
+	}
+
+	c.mu.Lock()
+	c.tokens[token] = source
+	c.mu.Unlock()
+
+	return token
+}
+
+// Returns true if the payload contains any canary placeholder
+func HasCanaryPlaceholder(payload string) bool {
+	return strings.Contains(payload, "{{canary")
+}
+
+// Replaces the canary placeholders in the payload with the address of the canary and the token
+func (c *Canary) Expand(payload string, token string) string {
+	return strings.NewReplacer(
+		CanaryURLPlaceholder, c.URL()+"/"+token,
+		CanaryDomainPlaceholder, token+"."+c.domain,
+		CanaryTokenPlaceholder, token,
+		CanaryPortPlaceholder, c.Port(),
+		CanaryPlaceholder, c.Addr(),
+	).Replace(payload)
+}
+
+// Returns all callbacks received by the canary
+func (c *Canary) Interactions() []Interaction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	interactions := make([]Interaction, len(c.interactions))
+	copy(interactions, c.interactions)
+	return interactions
+}
+
+// Returns the callbacks that contain the token and have not been claimed yet, and marks them as claimed
+// This is used to link callbacks to the request that triggered them
+func (c *Canary) Claim(token string) []Interaction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var interactions []Interaction
+	for i, interaction := range c.interactions {
+		if interaction.Token == token && !c.claimed[i] {
+			c.claimed[i] = true
+			interactions = append(interactions, interaction)
+		}
+	}
+	return interactions
+}
+
+// Waits for callbacks that arrive after the response was returned (e.g. from background jobs), then adds a finding
+// to the report for every callback with a known token that has not been claimed yet
+// Call this after all intruder tests have finished (e.g. in t.Cleanup())
+func (c *Canary) ReportLateInteractions(report *Report, wait time.Duration) []Finding {
+	time.Sleep(wait)
+
+	c.mu.Lock()
+	var findings []Finding
+	for i, interaction := range c.interactions {
+		if c.claimed[i] || interaction.Token == "" {
+			continue
+		}
+		c.claimed[i] = true
+		findings = append(findings, Finding{
+			Category:    interaction.Source.Category,
+			Endpoint:    interaction.Source.Endpoint,
+			Field:       interaction.Source.Field,
+			Payload:     interaction.Source.Payload,
+			Evidence:    fmt.Sprintf("canary received a late %s (%s after the payload was created)", interaction, interaction.Time.Sub(interaction.Source.Issued).Round(time.Millisecond)),
+			Severity:    SeverityHigh,
+			Occurrences: 1,
+		})
+	}
+	c.mu.Unlock()
+
+	for _, f := range findings {
+		report.Add(f)
+	}
+	return findings
+}
+
+// Stops the HTTP and DNS listeners
+func (c *Canary) Close() error {
+	err := c.server.Close()
+	if c.dns != nil {
+		if dnsErr := c.dns.Close(); err == nil {
+			err = dnsErr
+		}
+	}
+	return err
+}
+
+// Saves a callback and links it to the payload that contains its token
+// s is the text that is searched for tokens (URL, headers, body, DNS name, etc.)
+func (c *Canary) record(protocol string, remoteAddr string, details string, s string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	interaction := Interaction{
+		Protocol:   protocol,
+		Time:       time.Now(),
+		RemoteAddr: remoteAddr,
+		Details:    details,
+	}
+	for _, token := range reCanaryToken.FindAllString(strings.ToLower(s), -1) {
+		if source, ok := c.tokens[token]; ok {
+			interaction.Token = token
+			interaction.Source = source
+			break
+		}
+	}
+
+	c.interactions = append(c.interactions, interaction)
+	c.claimed = append(c.claimed, false)
+}
+
+// Records HTTP callbacks and responds with an empty body
+func (c *Canary) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(io.LimitReader(r.Body, maxCanaryBodySize))
+
+	var searched strings.Builder
+	searched.WriteString(r.Host + " " + r.URL.RequestURI() + " ")
+	for key, values := range r.Header {
+		searched.WriteString(key + ": " + strings.Join(values, ",") + " ")
+	}
+	searched.Write(body)
+
+	c.record(ProtocolHTTP, r.RemoteAddr, r.Method+" "+r.URL.RequestURI(), searched.String())
+	w.WriteHeader(http.StatusOK)
+}
+
+// ----------
+// DNS listener
+// A minimal DNS server (RFC 1035) that answers A queries for the canary domain with 127.0.0.1
+// ----------
+
+const (
+	dnsHeaderSize = 12
+	dnsTypeA      = 1
+	dnsClassIN    = 1
+	dnsRcodeOK    = 0
+	dnsRcodeNX    = 3
+	dnsRcodeError = 1
+)
+
+// Reads DNS queries until the listener is closed
+func (c *Canary) serveDNS() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := c.dns.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		res, name, qtype, ok := c.answerDNS(buf[:n])
+		if !ok {
+			continue
+		}
+		if name != "" {
+			c.record(ProtocolDNS, addr.String(), fmt.Sprintf("%s %s", dnsTypeName(qtype), name), name)
+		}
+		c.dns.WriteTo(res, addr)
+	}
+}
+
+// Builds the response to a DNS query
+// Returns the response, the queried name and type, and false if the query could not be parsed at all
+func (c *Canary) answerDNS(query []byte) ([]byte, string, uint16, bool) {
+	if len(query) < dnsHeaderSize {
+		return nil, "", 0, false
+	}
+
+	id := query[0:2]
+	rd := query[2] & 0x01 // recursion desired flag is copied to the response
+	qdcount := int(query[4])<<8 | int(query[5])
+
+	header := func(rcode byte, qdcount int, ancount int) []byte {
+		return []byte{
+			id[0], id[1],
+			0x84 | rd, 0x80 | rcode, // QR=1, AA=1, RA=1
+			byte(qdcount >> 8), byte(qdcount),
+			byte(ancount >> 8), byte(ancount),
+			0, 0, 0, 0,
+		}
+	}
+
+	if qdcount < 1 {
+		return header(dnsRcodeError, 0, 0), "", 0, true
+	}
+
+	// parse the first question
+	var labels []string
+	offset := dnsHeaderSize
+	for {
+		if offset >= len(query) {
+			return header(dnsRcodeError, 0, 0), "", 0, true
+		}
+		length := int(query[offset])
+		offset++
+		if length == 0 {
+			break
+		}
+		if length > 63 || offset+length > len(query) {
+			return header(dnsRcodeError, 0, 0), "", 0, true
+		}
+		labels = append(labels, string(query[offset:offset+length]))
+		offset += length
+	}
+	if offset+4 > len(query) {
+		return header(dnsRcodeError, 0, 0), "", 0, true
+	}
+	qtype := uint16(query[offset])<<8 | uint16(query[offset+1])
+	qclass := uint16(query[offset+2])<<8 | uint16(query[offset+3])
+	question := query[dnsHeaderSize : offset+4]
+	name := strings.ToLower(strings.Join(labels, "."))
+
+	// only names under the canary domain exist
+	if name != c.domain && !strings.HasSuffix(name, "."+c.domain) {
+		return append(header(dnsRcodeNX, 1, 0), question...), name, qtype, true
+	}
+
+	if qtype != dnsTypeA || qclass != dnsClassIN {
+		return append(header(dnsRcodeOK, 1, 0), question...), name, qtype, true
+	}
+
+	answer := []byte{
+		0xc0, dnsHeaderSize, // pointer to the name in the question
+		0, dnsTypeA,
+		0, dnsClassIN,
+		0, 0, 0, 0, // TTL 0 so that every lookup reaches the canary
+		0, 4,
+		c.dnsIP[0], c.dnsIP[1], c.dnsIP[2], c.dnsIP[3],
+	}
+	res := append(header(dnsRcodeOK, 1, 1), question...)
+	return append(res, answer...), name, qtype, true
+}
+
+// Returns the name of common DNS query types
+func dnsTypeName(qtype uint16) string {
+	switch qtype {
+	case 1:
+		return "A"
+	case 5:
+		return "CNAME"
+	case 16:
+		return "TXT"
+	case 28:
+		return "AAAA"
+	default:
+		return fmt.Sprintf("TYPE%d", qtype)
+	}
+}
@@ -0,0 +1,152 @@
+package intruder
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startTestCanary(t *testing.T) *Canary {
+	canary, err := StartCanary()
+	require.Nil(t, err)
+	t.Cleanup(func() { canary.Close() })
+	return canary
+}
+
+func Test_Canary_ShouldLinkHTTPCallbackToPayload(t *testing.T) {
+	// Arrange
+	canary := startTestCanary(t)
+	source := TokenSource{Category: TypeSSRF, Endpoint: "FetchImage", Field: "Url", Payload: "{{canary_url}}?x=1"}
+	token := canary.NewToken(source)
+
+	// Act
+	res, err := http.Get(canary.Expand(source.Payload, token))
+	require.Nil(t, err)
+	res.Body.Close()
+
+	// Assert
+	got := canary.Claim(token)
+	require.Equal(t, 1, len(got))
+	assert.Equal(t, ProtocolHTTP, got[0].Protocol)
+	assert.Equal(t, "GET /"+token+"?x=1", got[0].Details)
+	assert.Equal(t, "Url", got[0].Source.Field)
+	assert.Equal(t, source.Payload, got[0].Source.Payload)
+	assert.Empty(t, canary.Claim(token), "interactions should only be claimed once")
+}
+
+func Test_Canary_ShouldLinkDNSCallbackToPayload(t *testing.T) {
+	// Arrange
+	canary := startTestCanary(t)
+	token := canary.NewToken(TokenSource{Category: TypeXXE, Endpoint: "Upload", Field: "Document"})
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return net.Dial("udp", canary.DNSAddr())
+		},
+	}
+
+	// Act
+	addrs, err := resolver.LookupHost(context.Background(), canary.Expand("{{canary_domain}}", token))
+
+	// Assert
+	require.Nil(t, err)
+	assert.Contains(t, addrs, "127.0.0.1")
+	got := canary.Claim(token)
+	require.NotEmpty(t, got)
+	assert.Equal(t, ProtocolDNS, got[0].Protocol)
+	assert.Equal(t, "Document", got[0].Source.Field)
+
+	_, err = resolver.LookupHost(context.Background(), "example.com")
+	assert.NotNil(t, err, "names outside of the canary domain should not resolve")
+}
+
+func Test_Canary_ShouldReportLateInteractions(t *testing.T) {
+	// Arrange
+	canary := startTestCanary(t)
+	report := NewReport()
+	claimed := canary.NewToken(TokenSource{Category: TypeSSRF, Endpoint: "Fetch", Field: "A"})
+	late := canary.NewToken(TokenSource{Category: TypeSSRF, Endpoint: "Fetch", Field: "B", Payload: "{{canary_url}}"})
+	for _, token := range []string{claimed, late} {
+		res, err := http.Post(canary.URL()+"/callback", "text/plain", strings.NewReader("id="+token))
+		require.Nil(t, err)
+		res.Body.Close()
+	}
+	canary.Claim(claimed)
+
+	// Act
+	got := canary.ReportLateInteractions(report, 0)
+
+	// Assert
+	require.Equal(t, 1, len(got))
+	assert.Equal(t, "B", got[0].Field)
+	assert.Equal(t, got, report.Findings())
+	assert.Empty(t, canary.ReportLateInteractions(report, 0))
+}
+
+func Test_Canary_ShouldExpandPlaceholders(t *testing.T) {
+	canary := startTestCanary(t)
+	token := "td0123456789ab"
+
+	assert.Equal(t, "http://"+canary.Addr()+"/"+token, canary.Expand("{{canary_url}}", token))
+	assert.Equal(t, "http://localhost:"+canary.Port()+"/"+token, canary.Expand("http://localhost:{{canary_port}}/{{canary_token}}", token))
+	assert.Equal(t, token+"."+DefaultCanaryDomain, canary.Expand("{{canary_domain}}", token))
+	assert.True(t, HasCanaryPlaceholder("x{{canary}}"))
+	assert.False(t, HasCanaryPlaceholder("http://example.com"))
+}
+
+func Test_Canary_ShouldAdvertisePublicHost(t *testing.T) {
+	// Arrange
+	canary, err := StartCanary(CanaryOptions{ListenAddr: "0.0.0.0", PublicHost: "10.1.2.3"})
+	require.Nil(t, err)
+	t.Cleanup(func() { canary.Close() })
+	_, port, _ := net.SplitHostPort(canary.LocalAddr())
+	token := canary.NewToken(TokenSource{Category: TypeSSRF, Endpoint: "FetchImage", Field: "Url"})
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			_, dnsPort, _ := net.SplitHostPort(canary.DNSAddr())
+			return net.Dial("udp", "127.0.0.1:"+dnsPort)
+		},
+	}
+
+	// Act
+	expanded := canary.Expand("{{canary_url}} {{canary}}", token)
+	res, err := http.Get("http://127.0.0.1:" + port + "/" + token)
+	require.Nil(t, err)
+	res.Body.Close()
+	addrs, err := resolver.LookupHost(context.Background(), canary.Expand("{{canary_domain}}", token))
+
+	// Assert
+	assert.Equal(t, "http://10.1.2.3:"+port+"/"+token+" 10.1.2.3:"+port, expanded)
+	assert.Equal(t, port, canary.Port())
+	assert.NotEmpty(t, canary.Claim(token), "the canary should listen on every interface")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"10.1.2.3"}, addrs)
+}
+
+func Test_Canary_ShouldUsePortOfPublicHost(t *testing.T) {
+	// Arrange
+	canary, err := StartCanary(CanaryOptions{PublicHost: "host.docker.internal:8080", DisableDNS: true})
+	require.Nil(t, err)
+	t.Cleanup(func() { canary.Close() })
+
+	// Act
+	expanded := canary.Expand("{{canary_url}}", "td0123456789ab")
+
+	// Assert
+	assert.Equal(t, "http://host.docker.internal:8080/td0123456789ab", expanded)
+	assert.True(t, strings.HasPrefix(canary.LocalAddr(), "127.0.0.1:"))
+}
+
+func Test_StartCanary_ShouldRequirePublicHostOnAllInterfaces(t *testing.T) {
+	_, err := StartCanary(CanaryOptions{ListenAddr: "0.0.0.0"})
+	assert.NotNil(t, err)
+
+	_, err = StartCanary(CanaryOptions{ListenAddr: "localhost"})
+	assert.NotNil(t, err, "the listen address should be an IP address")
+}
@@ -0,0 +1,88 @@
+package intruder
+
+import (
+	"reflect"
+
+	"google.golang.org/protobuf/proto"
+)
+
+/*
+copy.go: Deep copies of requests, so that test cases generated by the intruder do not share any request state and can run in parallel
+*/
+
+// Returns a deep copy of the value (pointers, structs, slices, maps and interfaces are copied recursively)
+// Protobuf messages are copied with proto.Clone, so that their internal state (and the fields of dynamic messages) is not shared
+// Unexported fields of other structs cannot be set through reflection, so they are copied as-is
+func deepCopy(it interface{}) interface{} {
+	if it == nil {
+		return nil
+	}
+	return copyValue(reflect.ValueOf(it), map[uintptr]reflect.Value{}).Interface()
+}
+
+// Returns a deep copy of v
+// copied keeps track of the pointers that have already been copied, so that cyclic structures do not cause infinite recursion
+func copyValue(v reflect.Value, copied map[uintptr]reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		if c, ok := copied[v.Pointer()]; ok {
+			return c
+		}
+		if m, ok := v.Interface().(proto.Message); ok {
+			c := reflect.ValueOf(proto.Clone(m))
+			copied[v.Pointer()] = c
+			return c
+		}
+		c := reflect.New(v.Type().Elem())
+		copied[v.Pointer()] = c
+		c.Elem().Set(copyValue(v.Elem(), copied))
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if c.Field(i).CanSet() {
+				c.Field(i).Set(copyValue(v.Field(i), copied))
+			}
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(copyValue(v.Index(i), copied))
+		}
+		return c
+	case reflect.Array:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(copyValue(v.Index(i), copied))
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			c.SetMapIndex(iter.Key(), copyValue(iter.Value(), copied))
+		}
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(copyValue(v.Elem(), copied))
+		return c
+	}
+
+	// strings, numbers, bools, funcs and channels are values or cannot be copied
+	return v
+}
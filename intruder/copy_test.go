@@ -0,0 +1,121 @@
+package intruder
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/mercari/testdeck"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+type demoNode struct {
+	Name     string
+	Next     *demoNode
+	Tags     []string
+	Meta     map[string]*demoAuthor
+	Value    interface{}
+	internal *demoAuthor
+}
+
+type demoProfileRequest struct {
+	Id     string
+	Author *demoAuthor
+}
+
+// a client that changes the request it was given, like a careless middleware would
+type demoProfileClient struct {
+	mu      sync.Mutex
+	authors []string
+}
+
+func (c *demoProfileClient) Update(ctx context.Context, req *demoProfileRequest) (*demoProfileRequest, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.authors = append(c.authors, req.Author.Name)
+	req.Author.Name = "changed by " + req.Id
+	return req, nil
+}
+
+func Test_deepCopy_ShouldNotShareAnyState(t *testing.T) {
+	// Arrange
+	internal := &demoAuthor{Name: "internal"}
+	original := &demoNode{
+		Name:     "a",
+		Tags:     []string{"x"},
+		Meta:     map[string]*demoAuthor{"author": {Name: "b"}},
+		Value:    &demoAuthor{Name: "c"},
+		internal: internal,
+	}
+	original.Next = original
+
+	// Act
+	c := deepCopy(original).(*demoNode)
+	c.Name = "changed"
+	c.Tags[0] = "changed"
+	c.Meta["author"].Name = "changed"
+	c.Value.(*demoAuthor).Name = "changed"
+
+	// Assert
+	assert.Equal(t, "a", original.Name)
+	assert.Equal(t, "x", original.Tags[0])
+	assert.Equal(t, "b", original.Meta["author"].Name)
+	assert.Equal(t, "c", original.Value.(*demoAuthor).Name)
+	assert.True(t, c.Next == c, "cycles should point to the copy")
+	assert.True(t, c.internal == internal, "unexported fields should be copied as-is")
+}
+
+func Test_deepCopy_ShouldKeepNilValues(t *testing.T) {
+	c := deepCopy(&demoNode{}).(*demoNode)
+
+	assert.Nil(t, c.Next)
+	assert.Nil(t, c.Tags)
+	assert.Nil(t, c.Meta)
+	assert.Nil(t, c.Value)
+	assert.Nil(t, deepCopy(nil))
+}
+
+func Test_deepCopy_ShouldCloneProtobufMessages(t *testing.T) {
+	// Arrange
+	message, err := structpb.NewStruct(map[string]interface{}{"name": "a"})
+	require.Nil(t, err)
+	dynamic := dynamicpb.NewMessage(message.ProtoReflect().Descriptor())
+	proto.Merge(dynamic, message)
+	original := &demoNode{Value: message, Meta: map[string]*demoAuthor{}}
+
+	// Act
+	c := deepCopy(original).(*demoNode)
+	c.Value.(*structpb.Struct).Fields["name"] = structpb.NewStringValue("changed")
+	d := deepCopy(dynamic).(*dynamicpb.Message)
+	proto.Merge(d, c.Value.(*structpb.Struct))
+
+	// Assert
+	assert.Equal(t, "a", message.Fields["name"].GetStringValue())
+	assert.True(t, proto.Equal(message, dynamic), "the dynamic message should not share its fields with the copy")
+	assert.False(t, proto.Equal(d, dynamic))
+}
+
+func Test_RunIntruderTests_ShouldNotShareRequestsBetweenTestCases(t *testing.T) {
+	// Arrange
+	client := &demoProfileClient{}
+	sample := &demoProfileRequest{Id: "1", Author: &demoAuthor{Name: "sample"}}
+	data := InputValidationTestData{
+		Strings: []JsonDataSet{{Files: []string{"../payloads/input_validation/floats.txt"}, Type: TypeInputValidation}},
+	}
+
+	// Act
+	t.Run("Intruder", func(t *testing.T) {
+		RunIntruderTests(t, context.Background(), testdeck.TestCase{}, client, "Update", sample, data, IntruderOptions{Report: NewReport()})
+	})
+
+	// Assert
+	assert.Len(t, client.authors, 6)
+	for _, name := range client.authors {
+		assert.Equal(t, "sample", name)
+	}
+	assert.Equal(t, "sample", sample.Author.Name)
+}
@@ -0,0 +1,230 @@
+package intruder
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mercari/testdeck"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/*
+expectations.go: Checks the response of each payload against the expected status code, response fields, latency and payload rules of the json data set
+*/
+
+// Verifies the response of the attempt against the expectations that apply to every type of data set
+func verifyExpectations(t *testdeck.TD, e ExpectedResult, a Attempt) {
+	if res, ok := a.Response.(*HTTPResponse); ok && e.StatusCode != "" {
+		verifyHTTPStatusCode(t, e.StatusCode, res, a.Err)
+	} else if e.StatusCode != "" {
+		want, err := ParseStatusCode(e.StatusCode)
+		if err != nil {
+			assert.Fail(t, err.Error())
+		} else if got := status.Code(a.Err); got != want {
+			assert.Fail(t, fmt.Sprintf("FAIL: gRPC status code is %s but %s was expected", got, want))
+		}
+	}
+
+	for _, path := range e.PresentFields {
+		if v, ok := lookupField(a.Response, path); !ok || v.IsZero() {
+			assert.Fail(t, fmt.Sprintf("FAIL: Response field %s is not set", path))
+		}
+	}
+
+	for _, path := range e.AbsentFields {
+		if v, ok := lookupField(a.Response, path); ok && !v.IsZero() {
+			assert.Fail(t, fmt.Sprintf("FAIL: Response field %s should not be set but is %v", path, v.Interface()))
+		}
+	}
+
+	for path, pattern := range e.FieldPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			assert.Fail(t, fmt.Sprintf("Invalid pattern for response field %s: %s", path, err.Error()))
+			continue
+		}
+		v, ok := lookupField(a.Response, path)
+		if !ok {
+			assert.Fail(t, fmt.Sprintf("FAIL: Response field %s does not exist", path))
+			continue
+		}
+		if s := fmt.Sprint(v.Interface()); !re.MatchString(s) {
+			assert.Fail(t, fmt.Sprintf("FAIL: Response field %s (%q) does not match %s", path, s, pattern))
+		}
+	}
+
+	if e.MaxLatencyMs > 0 {
+		if max := time.Duration(e.MaxLatencyMs) * time.Millisecond; a.Duration > max {
+			assert.Fail(t, fmt.Sprintf("FAIL: Response took %s (more than the %s maximum latency)", a.Duration, max))
+		}
+	}
+
+	if e.MustNotContainPayload {
+		for _, payload := range a.reflectionPayloads() {
+			for _, r := range FindReflections(a.Response, payload) {
+				if !r.Encoded() {
+					assert.Fail(t, fmt.Sprintf("FAIL: Payload was echoed back in response field %s", r.Field))
+				}
+			}
+			if a.Err != nil && payload != "" && strings.Contains(a.Err.Error(), payload) {
+				assert.Fail(t, "FAIL: Payload was echoed back in the error message")
+			}
+		}
+	}
+}
+
+// Verifies the status code of the response of an HTTP endpoint
+// The HTTP intruder returns an error for every status code of 400 or higher, so the code is read from the response instead of the error
+func verifyHTTPStatusCode(t *testdeck.TD, want string, res *HTTPResponse, err error) {
+	if res == nil {
+		assert.Fail(t, fmt.Sprintf("FAIL: No HTTP response was returned but status code %s was expected: %v", want, err))
+		return
+	}
+	matched, parseErr := MatchHTTPStatusCode(want, res.StatusCode)
+	if parseErr != nil {
+		assert.Fail(t, parseErr.Error())
+	} else if !matched {
+		assert.Fail(t, fmt.Sprintf("FAIL: HTTP status code is %d but %s was expected", res.StatusCode, want))
+	}
+}
+
+// MatchHTTPStatusCode returns true if the HTTP status code matches the expected code (e.g. "400"),
+// class of codes (e.g. "4xx") or "OK" (any code under 400, i.e. the request was not rejected)
+func MatchHTTPStatusCode(want string, got int) (bool, error) {
+	if strings.EqualFold(want, "OK") {
+		return got < http.StatusBadRequest, nil
+	}
+	if len(want) == 3 && want[0] >= '1' && want[0] <= '5' && strings.EqualFold(want[1:], "xx") {
+		return got/100 == int(want[0]-'0'), nil
+	}
+	if n, err := strconv.Atoi(want); err == nil && n >= 100 && n <= 599 {
+		return got == n, nil
+	}
+	return false, fmt.Errorf("unknown HTTP status code %q", want)
+}
+
+// ParseStatusCode returns the gRPC status code with the name (e.g. "InvalidArgument", "INVALID_ARGUMENT" or "3")
+func ParseStatusCode(name string) (codes.Code, error) {
+	if n, err := strconv.Atoi(name); err == nil {
+		return codes.Code(n), nil
+	}
+
+	normalized := strings.ToLower(strings.ReplaceAll(name, "_", ""))
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		if strings.ToLower(c.String()) == normalized {
+			return c, nil
+		}
+	}
+	return codes.Unknown, fmt.Errorf("unknown gRPC status code %q", name)
+}
+
+// Returns the value of the response field at the path
+// Paths are in the same format as the fields of findings, e.g. Message.Body, Tags[0] or Meta[key]
+// Map keys can also be used as field names, so fields of decoded JSON can be found as well (e.g. Body.user.name)
+func lookupField(res interface{}, path string) (reflect.Value, bool) {
+	if res == nil {
+		return reflect.Value{}, false
+	}
+
+	v := reflect.ValueOf(res)
+	for _, part := range splitPath(path) {
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+
+		switch v.Kind() {
+		case reflect.Struct:
+			if part.index {
+				return reflect.Value{}, false
+			}
+			v = v.FieldByName(part.name)
+		case reflect.Slice, reflect.Array:
+			i, err := strconv.Atoi(part.name)
+			if !part.index || err != nil || i < 0 || i >= v.Len() {
+				return reflect.Value{}, false
+			}
+			v = v.Index(i)
+		case reflect.Map:
+			key, ok := mapKey(v.Type().Key(), part.name)
+			if !ok {
+				return reflect.Value{}, false
+			}
+			v = v.MapIndex(key)
+		default:
+			return reflect.Value{}, false
+		}
+
+		if !v.IsValid() || !v.CanInterface() {
+			return reflect.Value{}, false
+		}
+	}
+
+	return v, true
+}
+
+// A part of a field path, either a field name or an index in brackets
+type pathPart struct {
+	name  string
+	index bool
+}
+
+// Splits a field path such as Message.Tags[0] into its parts
+func splitPath(path string) []pathPart {
+	var parts []pathPart
+	for _, segment := range strings.Split(path, ".") {
+		name := segment
+		if i := strings.Index(segment, "["); i >= 0 {
+			name = segment[:i]
+		}
+		if name != "" {
+			parts = append(parts, pathPart{name: name})
+		}
+
+		for rest := segment[len(name):]; strings.HasPrefix(rest, "["); {
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				break
+			}
+			parts = append(parts, pathPart{name: rest[1:end], index: true})
+			rest = rest[end+1:]
+		}
+	}
+	return parts
+}
+
+// Converts the key in a field path to the key type of the map
+func mapKey(t reflect.Type, key string) (reflect.Value, bool) {
+	switch t.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(key).Convert(t), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			return reflect.Value{}, false
+		}
+		return reflect.ValueOf(i).Convert(t), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, err := strconv.ParseUint(key, 10, 64)
+		if err != nil {
+			return reflect.Value{}, false
+		}
+		return reflect.ValueOf(i).Convert(t), true
+	case reflect.Bool:
+		b, err := strconv.ParseBool(key)
+		if err != nil {
+			return reflect.Value{}, false
+		}
+		return reflect.ValueOf(b), true
+	}
+	return reflect.Value{}, false
+}
@@ -0,0 +1,206 @@
+package intruder
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mercari/testdeck"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// a testing.T that records failures instead of failing the test
+type recordingT struct {
+	*testing.T
+	mu       sync.Mutex
+	failures []string
+}
+
+func (r *recordingT) Errorf(format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func (r *recordingT) Error(args ...interface{}) {
+	r.Errorf("%s", fmt.Sprint(args...))
+}
+
+func (r *recordingT) Fail() {}
+
+// verifies the attempt and returns the failure messages
+func verifyAndRecord(t *testing.T, expected ExpectedResult, a Attempt) []string {
+	rt := &recordingT{T: t}
+	VerifyAttempt(&testdeck.TD{T: rt}, JsonDataSet{Type: TypeInputValidation, Expected: expected}, a)
+	return rt.failures
+}
+
+// returns true if one of the failure messages contains the string
+func failedWith(failures []string, s string) bool {
+	for _, f := range failures {
+		if strings.Contains(f, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func Test_VerifyAttempt_StatusCode(t *testing.T) {
+	// Arrange
+	a := Attempt{Err: status.Error(codes.InvalidArgument, "message_id is too long")}
+
+	// Act
+	matched := verifyAndRecord(t, ExpectedResult{StatusCode: "INVALID_ARGUMENT"}, a)
+	different := verifyAndRecord(t, ExpectedResult{StatusCode: "NotFound"}, a)
+	ok := verifyAndRecord(t, ExpectedResult{StatusCode: "OK"}, Attempt{Response: &demoComment{}})
+
+	// Assert
+	assert.Empty(t, matched)
+	assert.True(t, failedWith(different, "gRPC status code is InvalidArgument but NotFound was expected"), different)
+	assert.Empty(t, ok)
+}
+
+func Test_VerifyAttempt_HTTPStatusCode(t *testing.T) {
+	// Arrange
+	rejected := Attempt{Response: &HTTPResponse{StatusCode: 400}, Err: errors.New("HTTP 400: invalid")}
+	accepted := Attempt{Response: &HTTPResponse{StatusCode: 200}}
+
+	// Act
+	matched := verifyAndRecord(t, ExpectedResult{StatusCode: "400"}, rejected)
+	class := verifyAndRecord(t, ExpectedResult{StatusCode: "4xx"}, rejected)
+	different := verifyAndRecord(t, ExpectedResult{StatusCode: "422"}, rejected)
+	ok := verifyAndRecord(t, ExpectedResult{StatusCode: "OK"}, accepted)
+	notSent := verifyAndRecord(t, ExpectedResult{StatusCode: "400"}, Attempt{Response: (*HTTPResponse)(nil), Err: errors.New("connection refused")})
+	invalid := verifyAndRecord(t, ExpectedResult{StatusCode: "InvalidArgument"}, rejected)
+
+	// Assert
+	assert.Empty(t, matched)
+	assert.Empty(t, class)
+	assert.True(t, failedWith(different, "HTTP status code is 400 but 422 was expected"), different)
+	assert.Empty(t, ok)
+	assert.True(t, failedWith(notSent, "No HTTP response was returned"), notSent)
+	assert.True(t, failedWith(invalid, `unknown HTTP status code "InvalidArgument"`), invalid)
+}
+
+func Test_VerifyAttempt_ResponseFields(t *testing.T) {
+	// Arrange
+	a := Attempt{
+		Payload: "<b>",
+		Response: &demoComment{
+			ID:     "c-123",
+			Author: &demoAuthor{},
+			Tags:   []string{"new"},
+			Meta:   map[string]string{"debug": "stack trace"},
+		},
+	}
+	expected := ExpectedResult{
+		PresentFields: []string{"ID", "Tags[0]", "Author.Name", "Body"},
+		AbsentFields:  []string{"Meta[debug]", "Meta[trace]", "Author.Name"},
+		FieldPatterns: map[string]string{"ID": `^c-\d+$`, "Tags[0]": `^old$`, "Missing": `.*`},
+	}
+
+	// Act
+	failures := verifyAndRecord(t, expected, a)
+
+	// Assert
+	assert.Len(t, failures, 5, failures)
+	assert.True(t, failedWith(failures, "Response field Author.Name is not set"))
+	assert.True(t, failedWith(failures, "Response field Body is not set"))
+	assert.True(t, failedWith(failures, "Response field Meta[debug] should not be set"))
+	assert.True(t, failedWith(failures, `Response field Tags[0] ("new") does not match ^old$`))
+	assert.True(t, failedWith(failures, "Response field Missing does not exist"))
+}
+
+func Test_VerifyAttempt_MaxLatency(t *testing.T) {
+	expected := ExpectedResult{MaxLatencyMs: 100}
+
+	fast := verifyAndRecord(t, expected, Attempt{Response: &demoComment{}, Duration: 50 * time.Millisecond})
+	slow := verifyAndRecord(t, expected, Attempt{Response: &demoComment{}, Duration: 150 * time.Millisecond})
+
+	assert.Empty(t, fast)
+	assert.True(t, failedWith(slow, "more than the 100ms maximum latency"), slow)
+}
+
+func Test_VerifyAttempt_MustNotContainPayload(t *testing.T) {
+	expected := ExpectedResult{MustNotContainPayload: true, ErrorMessage: "invalid"}
+	payload := "<svg/onload=alert(1)>"
+
+	inResponse := verifyAndRecord(t, expected, Attempt{Payload: payload, Response: &demoComment{Body: payload}, Err: errors.New("invalid")})
+	inError := verifyAndRecord(t, expected, Attempt{Payload: payload, Err: errors.New("invalid value " + payload)})
+	encoded := verifyAndRecord(t, expected, Attempt{Payload: payload, Response: &demoComment{Body: "&lt;svg/onload=alert(1)&gt;"}, Err: errors.New("invalid")})
+
+	assert.True(t, failedWith(inResponse, "Payload was echoed back in response field Body"), inResponse)
+	assert.True(t, failedWith(inError, "Payload was echoed back in the error message"), inError)
+	assert.Empty(t, encoded)
+}
+
+func Test_VerifyAttempt_ShouldFindDecodedPayloads(t *testing.T) {
+	// Arrange
+	payload := "<svg/onload=alert(1)>"
+	a := Attempt{
+		Field:     "Body",
+		Payload:   payload,
+		Processed: "PHN2Zy9vbmxvYWQ9YWxlcnQoMSk+",
+		Response:  &demoComment{Body: payload}, // the service decoded the payload before echoing it back
+	}
+	rt := &recordingT{T: t}
+
+	sent := a
+	sent.Response = &demoComment{Body: a.Processed} // the service echoed back the payload as it was sent
+
+	// Act
+	findings := VerifyAttempt(&testdeck.TD{T: rt}, JsonDataSet{Type: TypeReflectedXSS}, a)
+	failures := verifyAndRecord(t, ExpectedResult{MustNotContainPayload: true}, a)
+	sentFailures := verifyAndRecord(t, ExpectedResult{MustNotContainPayload: true}, sent)
+
+	// Assert
+	require.Len(t, findings, 1)
+	assert.Equal(t, payload, findings[0].Payload)
+	assert.True(t, failedWith(failures, "Payload was echoed back in response field Body"), failures)
+	assert.True(t, failedWith(sentFailures, "Payload was echoed back in response field Body"), sentFailures)
+}
+
+func Test_ParseStatusCode(t *testing.T) {
+	for name, want := range map[string]codes.Code{
+		"OK":                  codes.OK,
+		"InvalidArgument":     codes.InvalidArgument,
+		"INVALID_ARGUMENT":    codes.InvalidArgument,
+		"permission_denied":   codes.PermissionDenied,
+		"16":                  codes.Unauthenticated,
+		"ResourceExhausted":   codes.ResourceExhausted,
+		"FAILED_PRECONDITION": codes.FailedPrecondition,
+	} {
+		got, err := ParseStatusCode(name)
+		require.Nil(t, err, name)
+		assert.Equal(t, want, got, name)
+	}
+
+	_, err := ParseStatusCode("Teapot")
+	assert.NotNil(t, err)
+}
+
+func Test_lookupField_ShouldFindFieldsOfDecodedJSON(t *testing.T) {
+	// Arrange
+	res := &HTTPResponse{Body: map[string]interface{}{
+		"user":  map[string]interface{}{"name": "a"},
+		"items": []interface{}{map[string]interface{}{"id": 1.0}},
+	}}
+
+	// Act
+	name, nameOK := lookupField(res, "Body.user.name")
+	id, idOK := lookupField(res, "Body.items[0].id")
+	_, missingOK := lookupField(res, "Body.items[1].id")
+
+	// Assert
+	require.True(t, nameOK)
+	assert.Equal(t, "a", name.Interface())
+	require.True(t, idOK)
+	assert.Equal(t, 1.0, id.Interface())
+	assert.False(t, missingOK)
+}
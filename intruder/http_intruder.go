@@ -0,0 +1,440 @@
+package intruder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mercari/testdeck"
+	"github.com/mercari/testdeck/httputils"
+)
+
+/*
+http_intruder.go: Runs the intruder on HTTP endpoints (e.g. REST gateways) by injecting payloads into query parameters, form fields, JSON body fields, headers, cookies and multipart fields
+*/
+
+// Locations in an HTTP request that payloads can be injected into
+const (
+	LocationQuery     = "query"
+	LocationForm      = "form"
+	LocationJSON      = "json"
+	LocationHeader    = "header"
+	LocationCookie    = "cookie"
+	LocationMultipart = "multipart"
+)
+
+// HTTPRequest is a sample, valid HTTP request (the intruder will inject payloads into every parameter of this request)
+// Only one of Form, JSON and Multipart should be set
+type HTTPRequest struct {
+	Method    string                 // the HTTP method (GET, POST, etc.)
+	URL       string                 // the URL without the query string
+	Query     url.Values             // query parameters
+	Form      url.Values             // x-www-form-urlencoded body fields
+	JSON      map[string]interface{} // JSON body (fields of nested objects are injected into as well)
+	Multipart interface{}            // pointer to a struct with multipart tags (see httputils.CreateMultipartBody)
+	Headers   map[string]string      // request headers
+	Cookies   map[string]string      // request cookies
+	Host      string                 // overrides the Host header (optional)
+	Client    *httputils.Client      // the client that sends the request (optional, httputils.DefaultClient is used if nil)
+}
+
+// HTTPResponse is the response to an HTTP request sent by the intruder
+type HTTPResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       interface{} // the decoded JSON body, or the body as a string if it is not JSON
+}
+
+// HTTPPosition is a parameter of an HTTP request that payloads are injected into
+type HTTPPosition struct {
+	Location string       // one of the Location constants
+	Name     string       // the name of the parameter (fields of nested JSON objects are separated by dots, e.g. user.name)
+	Kind     reflect.Kind // the type of the parameter, which decides the data sets that are used
+}
+
+// Returns the name of the position (e.g. query:q), which is also used as the name of the test case
+func (p HTTPPosition) String() string {
+	return p.Location + ":" + p.Name
+}
+
+// Returns the endpoint name used in findings (e.g. POST https://example.com/v1/echo)
+func (r HTTPRequest) endpoint() string {
+	return r.Method + " " + r.URL
+}
+
+// Returns every parameter of the request that payloads can be injected into, in a stable order
+func (r HTTPRequest) Positions() []HTTPPosition {
+	var positions []HTTPPosition
+
+	for _, name := range sortedKeys(r.Query) {
+		positions = append(positions, HTTPPosition{LocationQuery, name, reflect.String})
+	}
+	for _, name := range sortedKeys(r.Form) {
+		positions = append(positions, HTTPPosition{LocationForm, name, reflect.String})
+	}
+	positions = append(positions, jsonPositions("", r.JSON)...)
+	positions = append(positions, multipartPositions(r.Multipart)...)
+	for _, name := range sortedKeys(r.Headers) {
+		positions = append(positions, HTTPPosition{LocationHeader, name, reflect.String})
+	}
+	for _, name := range sortedKeys(r.Cookies) {
+		positions = append(positions, HTTPPosition{LocationCookie, name, reflect.String})
+	}
+
+	return positions
+}
+
+// Returns a copy of the request with the payload injected into the position
+// The sample request is not modified, so it can be shared by tests that run in parallel
+func (r HTTPRequest) With(p HTTPPosition, payload interface{}) (HTTPRequest, error) {
+	switch p.Location {
+	case LocationQuery:
+		r.Query = copyValues(r.Query)
+		r.Query.Set(p.Name, fmt.Sprint(payload))
+	case LocationForm:
+		r.Form = copyValues(r.Form)
+		r.Form.Set(p.Name, fmt.Sprint(payload))
+	case LocationJSON:
+		r.JSON = setJSONPath(r.JSON, strings.Split(p.Name, "."), payload)
+	case LocationMultipart:
+		multipart, err := setStructField(r.Multipart, p.Name, payload)
+		if err != nil {
+			return r, err
+		}
+		r.Multipart = multipart
+	case LocationHeader:
+		r.Headers = copyStrings(r.Headers)
+		r.Headers[p.Name] = fmt.Sprint(payload)
+	case LocationCookie:
+		r.Cookies = copyStrings(r.Cookies)
+		r.Cookies[p.Name] = fmt.Sprint(payload)
+	default:
+		return r, fmt.Errorf("unknown location %q", p.Location)
+	}
+	return r, nil
+}
+
+// Sends the request with its Client, or with httputils.SendHTTPRequest if it has none
+// An error is returned if the request could not be sent or if the response status code is 400 or higher,
+// so that the same json data set expectations can be used for gRPC and HTTP endpoints
+func (r HTTPRequest) Send() (*HTTPResponse, error) {
+	headers := copyStrings(r.Headers)
+	body := &bytes.Buffer{}
+	contentType := ""
+
+	switch {
+	case r.JSON != nil:
+		b, err := json.Marshal(r.JSON)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewBuffer(b)
+		contentType = "application/json"
+	case r.Form != nil:
+		body = httputils.ToBufferArray(r.Form)
+		contentType = "application/x-www-form-urlencoded"
+	case r.Multipart != nil:
+		var err error
+		body, contentType, err = httputils.CreateMultipartBody(r.Multipart)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := headers["Content-Type"]; !ok && contentType != "" {
+		headers["Content-Type"] = contentType
+	}
+
+	if len(r.Cookies) > 0 {
+		var cookies []string
+		for _, name := range sortedKeys(r.Cookies) {
+			cookies = append(cookies, name+"="+r.Cookies[name])
+		}
+		headers["Cookie"] = strings.Join(cookies, "; ")
+	}
+
+	u := r.URL
+	if len(r.Query) > 0 {
+		u += "?" + r.Query.Encode()
+	}
+
+	var host []string
+	if r.Host != "" {
+		host = append(host, r.Host)
+	}
+
+	client := r.Client
+	if client == nil {
+		client = httputils.DefaultClient
+	}
+	resp, resBody, err := client.Send(r.Method, u, body, headers, host...)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &HTTPResponse{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       resBody.String(),
+	}
+	var decoded interface{}
+	if json.Unmarshal(resBody.Bytes(), &decoded) == nil {
+		res.Body = decoded
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return res, fmt.Errorf("HTTP %d: %s", resp.StatusCode, resBody.String())
+	}
+	return res, nil
+}
+
+// Runs the intruder on all parameters of this HTTP request
+// req is a sample, valid request (the intruder will inject payloads into each parameter of this request)
+// data is the json data set (the same data sets as gRPC endpoints are used)
+// opts are configurations for the intruder, if not included the default settings will be used
+func RunHTTPIntruderTests(t *testing.T, td testdeck.TestCase, req HTTPRequest, data InputValidationTestData, opts ...IntruderOptions) {
+	// data sets in battering ram, pitchfork and cluster bomb mode attack several parameters at once, so they are run separately
+	sniper, attacks := data.splitModes()
+
+	positions := req.Positions()
+	for _, p := range positions {
+		if sniper.empty() {
+			break
+		}
+		TestThisHTTPPosition(t, td, req, p, sniper, opts...)
+	}
+
+	for _, a := range attacks {
+		// the fields of the data set are the names of the positions (e.g. query:q)
+		var attacked []HTTPPosition
+		for _, p := range positions {
+			if len(a.set.Fields) == 0 && kindGroup(p.Kind) == a.kind || contains(a.set.Fields, p.String()) {
+				attacked = append(attacked, p)
+			}
+		}
+		if len(attacked) == 0 {
+			continue
+		}
+
+		TestTheseHTTPPositions(t, td, req, attacked, a.set, opts...)
+	}
+}
+
+// This method generates the actual testdeck test cases to inject payloads into the specified parameter of the HTTP request
+// By default there is a test case for each payload, grouped under the name of the position (see IntruderOptions.Subtests)
+func TestThisHTTPPosition(t *testing.T, tc testdeck.TestCase, req HTTPRequest, p HTTPPosition, data InputValidationTestData, opts ...IntruderOptions) {
+	o := intruderOptions(opts)
+
+	// sends the request with the payload injected into the parameter and verifies the response
+	attack := func(t *testdeck.TD, set JsonDataSet, payload interface{}) {
+		// point SSRF and out-of-band payloads to the canary with a unique token, so that callbacks can be linked to this payload
+		var token string
+		if s, ok := payload.(string); ok && o.Canary != nil && HasCanaryPlaceholder(s) {
+			token = o.Canary.NewToken(TokenSource{Category: set.Type, Endpoint: req.endpoint(), Field: p.String(), Payload: s})
+			payload = o.Canary.Expand(s, token)
+		}
+		raw := payload
+		payload = set.process(payload)
+
+		// headers and cookies cannot contain line breaks, so the request cannot be sent at all
+		if s, ok := payload.(string); ok && (p.Location == LocationHeader || p.Location == LocationCookie) && strings.ContainsAny(s, "\r\n\x00") {
+			t.Logf("Skipping value that cannot be sent in a %s: %q", p.Location, s)
+			return
+		}
+
+		t.Logf("%s Value: %v", p, payload)
+		attack, err := req.With(p, payload)
+		if err != nil {
+			t.Errorf("Failed to inject %v into %s: %s", payload, p, err.Error())
+			return
+		}
+
+		start := time.Now()
+		res, err := attack.Send()
+		attempt := Attempt{
+			Endpoint: req.endpoint(),
+			Field:    p.String(),
+			Payload:  fmt.Sprint(raw),
+			Response: res,
+			Duration: time.Since(start),
+			Err:      err,
+		}
+		if processed := fmt.Sprint(payload); processed != attempt.Payload {
+			attempt.Processed = processed
+		}
+		if token != "" {
+			attempt.CanaryInteractions = o.Canary.Claim(token)
+		}
+
+		for _, f := range VerifyAttempt(t, set, attempt) {
+			o.Report.Add(f)
+		}
+	}
+
+	runPayloads(t, tc, p.String(), data.SetsFor(p.Kind), p.Kind, o, attack)
+}
+
+// This method generates an actual testdeck test case that injects payloads into several parameters of the HTTP request at the same time
+// set is a json data set in battering ram, pitchfork or cluster bomb mode
+func TestTheseHTTPPositions(t *testing.T, tc testdeck.TestCase, req HTTPRequest, positions []HTTPPosition, set JsonDataSet, opts ...IntruderOptions) {
+	o := intruderOptions(opts)
+
+	var names []string
+	var kinds []reflect.Kind
+	for _, p := range positions {
+		names = append(names, p.String())
+		kinds = append(kinds, p.Kind)
+	}
+	tc = newTestCase(tc)
+
+	// Act
+	tc.Act = func(t *testdeck.TD) {
+		runAttack(t, set, req.endpoint(), names, kinds, o, func(payloads []interface{}) (interface{}, error) {
+			attack := req
+			for i, p := range positions {
+				// headers and cookies cannot contain line breaks, so the request cannot be sent at all
+				if s, ok := payloads[i].(string); ok && (p.Location == LocationHeader || p.Location == LocationCookie) && strings.ContainsAny(s, "\r\n\x00") {
+					t.Logf("Skipping value that cannot be sent in a %s: %q", p.Location, s)
+					return nil, errSkipped
+				}
+
+				var err error
+				attack, err = attack.With(p, payloads[i])
+				if err != nil {
+					t.Errorf("Failed to inject %v into %s: %s", payloads[i], p, err.Error())
+					return nil, errSkipped
+				}
+			}
+			return attack.Send()
+		})
+	}
+
+	runTestCase(t, tc, set.mode()+":"+strings.Join(names, ","), timeBased(set))
+}
+
+// ----------
+// helper methods for copying and modifying requests
+// ----------
+
+// Returns the keys of a map in sorted order
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	for _, k := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Returns true if the list contains the string
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func copyValues(v url.Values) url.Values {
+	c := url.Values{}
+	for key, values := range v {
+		c[key] = append([]string(nil), values...)
+	}
+	return c
+}
+
+func copyStrings(m map[string]string) map[string]string {
+	c := make(map[string]string, len(m))
+	for key, value := range m {
+		c[key] = value
+	}
+	return c
+}
+
+// Returns the positions of all string, number and bool fields in the JSON object (including fields of nested objects)
+func jsonPositions(prefix string, m map[string]interface{}) []HTTPPosition {
+	var positions []HTTPPosition
+	for _, key := range sortedKeys(m) {
+		name := joinPath(prefix, key)
+		switch v := m[key].(type) {
+		case string:
+			positions = append(positions, HTTPPosition{LocationJSON, name, reflect.String})
+		case bool:
+			positions = append(positions, HTTPPosition{LocationJSON, name, reflect.Bool})
+		case int, int32, int64:
+			positions = append(positions, HTTPPosition{LocationJSON, name, reflect.Int})
+		case float32, float64:
+			// encoding/json decodes all numbers as float64, so whole numbers are treated as ints
+			kind := reflect.Float64
+			if f := reflect.ValueOf(v).Float(); f == float64(int64(f)) {
+				kind = reflect.Int
+			}
+			positions = append(positions, HTTPPosition{LocationJSON, name, kind})
+		case map[string]interface{}:
+			positions = append(positions, jsonPositions(name, v)...)
+		}
+	}
+	return positions
+}
+
+// Returns a copy of the JSON object with the value at the path replaced
+// Only the objects along the path are copied
+func setJSONPath(m map[string]interface{}, path []string, value interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(m))
+	for key, v := range m {
+		c[key] = v
+	}
+
+	if len(path) == 1 {
+		c[path[0]] = value
+	} else if nested, ok := c[path[0]].(map[string]interface{}); ok {
+		c[path[0]] = setJSONPath(nested, path[1:], value)
+	}
+	return c
+}
+
+// Returns the positions of all string, number and bool fields with the multipart:"field" tag
+func multipartPositions(it interface{}) []HTTPPosition {
+	if it == nil {
+		return nil
+	}
+
+	var positions []HTTPPosition
+	t := reflect.TypeOf(it).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Tag.Get("multipart") != "field" {
+			continue
+		}
+
+		switch kind := field.Type.Kind(); kind {
+		case reflect.String, reflect.Bool, reflect.Float32, reflect.Float64,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			positions = append(positions, HTTPPosition{LocationMultipart, field.Name, kind})
+		}
+	}
+	return positions
+}
+
+// Returns a copy of the struct that it points to with the value of the field replaced
+func setStructField(it interface{}, fieldName string, value interface{}) (interface{}, error) {
+	original := reflect.ValueOf(it).Elem()
+	c := reflect.New(original.Type())
+	c.Elem().Set(original)
+
+	field := c.Elem().FieldByName(fieldName)
+	v := reflect.ValueOf(value)
+	if !field.IsValid() || !field.CanSet() || !v.Type().ConvertibleTo(field.Type()) {
+		return nil, fmt.Errorf("cannot set field %s to %v", fieldName, value)
+	}
+	field.Set(v.Convert(field.Type()))
+
+	return c.Interface(), nil
+}
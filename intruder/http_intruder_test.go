@@ -0,0 +1,180 @@
+package intruder
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mercari/testdeck"
+	"github.com/mercari/testdeck/httputils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type demoUpload struct {
+	Title string `json:"title" multipart:"field"`
+	Count int    `json:"count" multipart:"field"`
+	Note  string `json:"note"`
+}
+
+func Test_HTTPRequest_Positions(t *testing.T) {
+	// Arrange
+	req := HTTPRequest{
+		Method:    http.MethodPost,
+		URL:       "http://localhost/echo",
+		Query:     url.Values{"q": {"a"}},
+		JSON:      map[string]interface{}{"name": "a", "age": 20.0, "price": 1.5, "user": map[string]interface{}{"admin": false}},
+		Multipart: &demoUpload{Title: "a", Count: 1},
+		Headers:   map[string]string{"X-Request-Id": "1"},
+		Cookies:   map[string]string{"session": "abc"},
+	}
+
+	// Act
+	got := req.Positions()
+
+	// Assert
+	var names []string
+	for _, p := range got {
+		names = append(names, p.String())
+	}
+	assert.Equal(t, []string{
+		"query:q",
+		"json:age",
+		"json:name",
+		"json:price",
+		"json:user.admin",
+		"multipart:Title",
+		"multipart:Count",
+		"header:X-Request-Id",
+		"cookie:session",
+	}, names)
+	assert.Equal(t, got[1].Kind.String(), "int")
+	assert.Equal(t, got[3].Kind.String(), "float64")
+}
+
+func Test_HTTPRequest_WithShouldNotModifySampleRequest(t *testing.T) {
+	// Arrange
+	sample := HTTPRequest{
+		Query:     url.Values{"q": {"a"}},
+		JSON:      map[string]interface{}{"user": map[string]interface{}{"name": "a"}},
+		Multipart: &demoUpload{Title: "a"},
+		Cookies:   map[string]string{"session": "abc"},
+	}
+
+	// Act
+	q, err := sample.With(HTTPPosition{LocationQuery, "q", 0}, "<b>")
+	require.Nil(t, err)
+	j, err := sample.With(HTTPPosition{LocationJSON, "user.name", 0}, "<b>")
+	require.Nil(t, err)
+	m, err := sample.With(HTTPPosition{LocationMultipart, "Count", 0}, 42)
+	require.Nil(t, err)
+	c, err := sample.With(HTTPPosition{LocationCookie, "session", 0}, "<b>")
+	require.Nil(t, err)
+
+	// Assert
+	assert.Equal(t, "<b>", q.Query.Get("q"))
+	assert.Equal(t, "<b>", j.JSON["user"].(map[string]interface{})["name"])
+	assert.Equal(t, 42, m.Multipart.(*demoUpload).Count)
+	assert.Equal(t, "<b>", c.Cookies["session"])
+
+	assert.Equal(t, "a", sample.Query.Get("q"))
+	assert.Equal(t, "a", sample.JSON["user"].(map[string]interface{})["name"])
+	assert.Equal(t, 0, sample.Multipart.(*demoUpload).Count)
+	assert.Equal(t, "abc", sample.Cookies["session"])
+}
+
+func Test_HTTPRequest_Send(t *testing.T) {
+	// Arrange
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		cookie, _ := r.Cookie("session")
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("q") == "bad" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"query":   r.URL.Query().Get("q"),
+			"body":    string(body),
+			"type":    r.Header.Get("Content-Type"),
+			"session": cookie.Value,
+		})
+	}))
+	defer server.Close()
+	req := HTTPRequest{
+		Method:  http.MethodPost,
+		URL:     server.URL,
+		Query:   url.Values{"q": {"hello"}},
+		JSON:    map[string]interface{}{"name": "a"},
+		Cookies: map[string]string{"session": "abc"},
+	}
+
+	// Act
+	res, err := req.Send()
+	bad, _ := req.With(HTTPPosition{LocationQuery, "q", 0}, "bad")
+	_, badErr := bad.Send()
+
+	// Assert
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, map[string]interface{}{
+		"query":   "hello",
+		"body":    `{"name":"a"}`,
+		"type":    "application/json",
+		"session": "abc",
+	}, res.Body)
+	require.NotNil(t, badErr)
+	assert.Contains(t, badErr.Error(), "HTTP 400")
+}
+
+func Test_HTTPRequest_SendShouldUseClient(t *testing.T) {
+	// Arrange
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer server.Close()
+	client, err := httputils.NewClient(httputils.ClientConfig{Headers: map[string]string{"Authorization": "Bearer token"}})
+	require.Nil(t, err)
+	req := HTTPRequest{Method: http.MethodGet, URL: server.URL, Client: client}
+
+	// Act
+	res, err := req.Send()
+
+	// Assert
+	require.Nil(t, err)
+	assert.Equal(t, "Bearer token", res.Body)
+}
+
+func Test_RunHTTPIntruderTests_ShouldSendEveryPayload(t *testing.T) {
+	// Arrange
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	data, err := ParseInputValidationTestDataFromJson("../payloads/xss/testdata.json")
+	require.Nil(t, err)
+	var want int
+	for _, file := range data.Strings[0].Files {
+		payloads, _ := GetStringArrayFromTextFile(file)
+		want += len(payloads)
+	}
+	report := NewReport()
+
+	// Act
+	t.Run("Intruder", func(t *testing.T) {
+		RunHTTPIntruderTests(t, testdeck.TestCase{}, HTTPRequest{
+			Method: http.MethodGet,
+			URL:    server.URL,
+			Query:  url.Values{"q": {"hello"}},
+		}, data, IntruderOptions{Report: report})
+	})
+
+	// Assert
+	assert.Equal(t, int32(want), atomic.LoadInt32(&requests))
+	assert.Empty(t, report.Findings())
+}
@@ -0,0 +1,42 @@
+package intruder
+
+import (
+	"reflect"
+	"strings"
+)
+
+/*
+injection.go: Helper methods for detecting command injection and path traversal from the contents of responses
+*/
+
+// SignatureMatch is a field of the response that contains a signature of a successful attack
+type SignatureMatch struct {
+	Field     string // path of the field in the response, or "error" if the signature was found in the error message
+	Signature string // the signature that was found (e.g. "root:x:0:0:")
+}
+
+// FindSignatures returns every field of the response (and the error message) that contains one of the signatures
+// Signatures are strings that only appear if the attack worked, such as the output of an injected command or the contents of /etc/passwd
+func FindSignatures(res interface{}, err error, signatures []string) []SignatureMatch {
+	if len(signatures) == 0 {
+		return nil
+	}
+
+	var matches []SignatureMatch
+	check := func(path string, s string) {
+		for _, sig := range signatures {
+			if sig != "" && strings.Contains(s, sig) {
+				matches = append(matches, SignatureMatch{Field: path, Signature: sig})
+			}
+		}
+	}
+
+	if res != nil {
+		walkStrings(reflect.ValueOf(res), "", 0, check)
+	}
+	if err != nil {
+		check("error", err.Error())
+	}
+
+	return matches
+}
@@ -0,0 +1,34 @@
+package intruder
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_FindSignatures_ShouldSearchResponseAndError(t *testing.T) {
+	// Arrange
+	res := &demoComment{
+		Body: "root:x:0:0:root:/root:/bin/bash",
+		Tags: []string{"tdcmd42319"},
+	}
+	err := errors.New("open ../../etc/hosts: [extensions] not found")
+
+	// Act
+	got := FindSignatures(res, err, []string{"root:x:0:0:", "tdcmd42319", "[extensions]"})
+
+	// Assert
+	assert.Equal(t, []SignatureMatch{
+		{Field: "Body", Signature: "root:x:0:0:"},
+		{Field: "Tags[0]", Signature: "tdcmd42319"},
+		{Field: "error", Signature: "[extensions]"},
+	}, got)
+}
+
+func Test_FindSignatures_ShouldIgnoreEmptySignatures(t *testing.T) {
+	res := &demoComment{Body: "hello"}
+
+	assert.Empty(t, FindSignatures(res, nil, nil))
+	assert.Empty(t, FindSignatures(res, nil, []string{""}))
+}
@@ -6,169 +6,553 @@ import (
 	"github.com/mercari/testdeck"
 	"github.com/mercari/testdeck/grpcutils"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
 	"time"
+	"unicode"
 )
 
+// Represents configurable options for the intruder
+type IntruderOptions struct {
+	Report *Report // the report that findings are added to (DefaultReport is used if nil)
+	Canary *Canary // the canary that SSRF and out-of-band payloads point to (they cannot be detected if nil)
+
+	// how the payloads of a field are split into test cases: SubtestPerPayload (default), SubtestPerFile or SubtestPerField
+	Subtests string
+}
+
+// Ways of splitting the payloads of a field into testdeck test cases
+const (
+	SubtestPerPayload = "payload" // a test case for each payload
+	SubtestPerFile    = "file"    // a test case for each intruder .txt file
+	SubtestPerField   = "field"   // a single test case for all payloads
+)
+
+// Returns the options that were passed in, or the default options if none were passed in
+func intruderOptions(opts []IntruderOptions) IntruderOptions {
+	var o IntruderOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	if o.Report == nil {
+		o.Report = DefaultReport
+	}
+	return o
+}
+
+// Attempt is a single request sent by the intruder and the response that was returned
+type Attempt struct {
+	Endpoint          string        // the rpc method that was called
+	Field             string        // the request field the payload was injected into
+	Payload           string        // the payload that was injected, before the processors of the data set were applied
+	Payloads          []string      // the payloads that were injected into each field (multi-field attack modes only)
+	Response          interface{}   // the response returned by the endpoint
+	Duration          time.Duration // how long the endpoint took to respond
+	Err               error         // the error returned by the endpoint
+	Processed         string        // the payload as it was sent, after the processors of the data set were applied (empty if they did not change it)
+	ProcessedPayloads []string      // the payloads as they were sent to each field (multi-field attack modes with processors only)
+
+	CanaryInteractions []Interaction // callbacks to the canary that contain the token of this payload
+}
+
+// Returns the payloads to look for in the response: the payloads as they were injected and as they were sent,
+// because a service may decode an encoded payload before echoing it back
+func (a Attempt) reflectionPayloads() []string {
+	payloads := a.Payloads
+	if len(payloads) == 0 {
+		payloads = []string{a.Payload}
+	}
+	payloads = append([]string(nil), payloads...)
+	if a.Processed != "" {
+		payloads = append(payloads, a.Processed)
+	}
+	for _, p := range a.ProcessedPayloads {
+		if !contains(payloads, p) {
+			payloads = append(payloads, p)
+		}
+	}
+	return payloads
+}
+
 // A helper function that verifies that the response matches the expected results fetched from the json test data file
+// Security findings are added to DefaultReport
 func VerifyIntruderTestResults(t *testdeck.TD, data JsonDataSet, res interface{}, duration time.Duration, input string, err error) {
+	attempt := Attempt{
+		Payload:  input,
+		Response: res,
+		Duration: duration,
+		Err:      err,
+	}
+	for _, f := range VerifyAttempt(t, data, attempt) {
+		DefaultReport.Add(f)
+	}
+}
+
+// Verifies that the response of the attempt matches the expected results fetched from the json test data file
+// Returns the security findings of the attempt so that they can be added to a report
+// Findings are only logged as warnings, so that they do not fail the test like the expectations of the data set do
+func VerifyAttempt(t *testdeck.TD, data JsonDataSet, a Attempt) []Finding {
+	var findings []Finding
+	newFinding := func(severity string, evidence string) Finding {
+		return Finding{
+			Category: data.Type,
+			Endpoint: a.Endpoint,
+			Field:    a.Field,
+			Payload:  a.Payload,
+			Evidence: evidence,
+			Severity: severity,
+		}
+	}
 
 	switch data.Type {
-	case "input validation":
+	case TypeInputValidation:
 		if data.Expected.ErrorMessage != "" {
 			// verify that an error was returned
-			assert.NotNil(t, err, "FAIL: Error was not returned as expected")
-			if err != nil {
+			assert.NotNil(t, a.Err, "FAIL: Error was not returned as expected")
+			if a.Err != nil {
 				// if an error was returned, verify that the error message is correct
-				assert.Contains(t, err.Error(), data.Expected.ErrorMessage, "FAIL: Error message is different from expected")
+				assert.Contains(t, a.Err.Error(), data.Expected.ErrorMessage, "FAIL: Error message is different from expected")
 			}
-		} else { // success case checks
-			assert.NotNil(t, res, "FAIL: Response is nil")
-			assert.Nil(t, err, "FAIL: Unexpected error was returned")
+		} else if data.Expected.StatusCode == "" { // success case checks (the status code is checked below if it was specified)
+			assert.NotNil(t, a.Response, "FAIL: Response is nil")
+			assert.Nil(t, a.Err, "FAIL: Unexpected error was returned")
+		}
+	case TypeSQLInjection:
+		if data.Expected.TimeDelay > 0 && a.Duration.Seconds() > float64(data.Expected.TimeDelay) {
+			findings = append(findings, newFinding(SeverityHigh, fmt.Sprintf("response took %.2fs (more than the %ds time delay)", a.Duration.Seconds(), data.Expected.TimeDelay)))
+			t.Logf("WARNING: Potential SQLi found")
 		}
-	case "sql injection":
-		assert.False(t, duration.Seconds() > float64(data.Expected.TimeDelay), "WARNING: Potential SQLi found")
-	case "reflected xss":
-		str := fmt.Sprintf("%v", res)
-		assert.NotContains(t, str, input, "WARNING: Potential reflected XSS found")
+	case TypeReflectedXSS, TypeStoredXSS:
+		severity := SeverityMedium
+		if data.Type == TypeStoredXSS {
+			severity = SeverityHigh
+		}
+
+		// check every field of the response so that we know where the payload was echoed back and if it was encoded
+		for _, payload := range a.reflectionPayloads() {
+			for _, r := range FindReflections(a.Response, payload) {
+				if r.Encoded() {
+					t.Logf("Payload was echoed back in %s with %s encoding", r.Field, r.Encoding)
+					continue
+				}
+				findings = append(findings, newFinding(severity, fmt.Sprintf("payload was echoed back in response field %s (encoding: %s)", r.Field, r.Encoding)))
+				t.Logf("WARNING: Potential %s found in field %s", data.Type, r.Field)
+			}
+		}
+	case TypeCommandInjection:
+		if data.Expected.TimeDelay > 0 && a.Duration.Seconds() > float64(data.Expected.TimeDelay) {
+			findings = append(findings, newFinding(SeverityHigh, fmt.Sprintf("response took %.2fs (more than the %ds time delay)", a.Duration.Seconds(), data.Expected.TimeDelay)))
+			t.Logf("WARNING: Potential command injection found")
+		}
+		for _, m := range FindSignatures(a.Response, a.Err, data.Expected.Signatures) {
+			findings = append(findings, newFinding(SeverityHigh, fmt.Sprintf("output of the injected command %q was found in %s", m.Signature, m.Field)))
+			t.Logf("WARNING: Potential command injection found in field %s", m.Field)
+		}
+	case TypePathTraversal:
+		for _, m := range FindSignatures(a.Response, a.Err, data.Expected.Signatures) {
+			findings = append(findings, newFinding(SeverityHigh, fmt.Sprintf("file content signature %q was found in %s", m.Signature, m.Field)))
+			t.Logf("WARNING: Potential path traversal found in field %s", m.Field)
+		}
+	}
+
+	verifyExpectations(t, data.Expected, a)
+
+	// a callback to the canary means that the payload was processed by the service, regardless of the type of the data set
+	for _, i := range a.CanaryInteractions {
+		findings = append(findings, newFinding(SeverityHigh, fmt.Sprintf("canary received %s", i)))
+		t.Logf("WARNING: Potential %s found (out-of-band %s callback)", data.Type, i.Protocol)
 	}
+
+	return findings
 }
 
 // Runs a fuzz test on all parameters of this request
 // req is a sample request struct specified in the protobuf file (e.g. pb.SayRequest)
 // function is the function to be called
 // dataFile is the json file where fuzzing data will come from
-func RunIntruderTests(t *testing.T, ctx context.Context, td testdeck.TestCase, client interface{}, methodName string, req interface{}, data InputValidationTestData) {
+// opts are configurations for the intruder, if not included the default settings will be used
+func RunIntruderTests(t *testing.T, ctx context.Context, td testdeck.TestCase, client interface{}, methodName string, req interface{}, data InputValidationTestData, opts ...IntruderOptions) {
 
 	// get parameters of the sample request using reflection because we do not know the protobuf type
-	fieldNames := reflect.TypeOf(req).Elem()
-	fieldValues := reflect.ValueOf(req).Elem()
+	params := requestFields(req)
 
-	// for each parameter field in this endpoint
-	for i := 0; i < fieldValues.NumField(); i++ {
-		fieldName := fieldNames.Field(i).Name
+	// data sets in battering ram, pitchfork and cluster bomb mode attack several fields at once, so they are run separately
+	sniper, attacks := data.splitModes()
 
-		// skip this parameter if it is an automatically-generated field (field name starts with XXX)
-		if strings.HasPrefix(fieldName, "XXX") {
+	// for each parameter field in this endpoint
+	for _, field := range params {
+		if sniper.empty() {
 			break
 		}
 
 		// run fuzz tests on this field
-		TestThisField(t, ctx, td, client, methodName, req, fieldName, data)
+		TestThisField(t, ctx, td, client, methodName, req, field.name, sniper, opts...)
+	}
+
+	for _, a := range attacks {
+		fields := a.set.Fields
+		if len(fields) == 0 {
+			// attack all fields of the data set's type
+			for _, field := range params {
+				if kindGroup(field.kind) == a.kind {
+					fields = append(fields, field.name)
+				}
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		TestTheseFields(t, ctx, td, client, methodName, req, fields, a.set, opts...)
 	}
 }
 
-// This method generates an actual testdeck test case to fuzz the specified field
+// Runs stored XSS tests on all string parameters of this request
+// Each payload is written using methodName and then read back using the target's read method
+// req is a sample request for the write method
+// target is the rpc method that returns the stored data
+// data is the json data set with "stored xss" type (e.g. ../payloads/xss/stored_testdata.json)
+// opts are configurations for the intruder, if not included the default settings will be used
+func RunStoredXSSTests(t *testing.T, ctx context.Context, td testdeck.TestCase, client interface{}, methodName string, req interface{}, target StoredXSSTarget, data InputValidationTestData, opts ...IntruderOptions) {
+
+	// only string fields can hold XSS payloads, and each field is tested on its own
+	sniper, _ := data.splitModes()
+	stringsOnly := InputValidationTestData{Strings: sniper.Strings}
+
+	// get parameters of the sample request using reflection because we do not know the protobuf type
+	for _, field := range requestFields(req) {
+		if field.kind != reflect.String {
+			continue
+		}
+		testField(t, ctx, td, client, methodName, req, field.name, stringsOnly, &target, intruderOptions(opts))
+	}
+}
+
+// This method generates the actual testdeck test cases to fuzz the specified field
+// By default there is a test case for each payload, grouped under the name of the field (see IntruderOptions.Subtests)
 // req is the sample request struct
 // fieldName is the current field to fuzz
 // function is the fuzzing function
 // dataFile is the json file where fuzzing data will come from
-func TestThisField(t *testing.T, ctx context.Context, tc testdeck.TestCase, client interface{}, methodName string, req interface{}, fieldName string, testDataSet InputValidationTestData) {
+// opts are configurations for the intruder, if not included the default settings will be used
+func TestThisField(t *testing.T, ctx context.Context, tc testdeck.TestCase, client interface{}, methodName string, req interface{}, fieldName string, testDataSet InputValidationTestData, opts ...IntruderOptions) {
+	testField(t, ctx, tc, client, methodName, req, fieldName, testDataSet, nil, intruderOptions(opts))
+}
+
+// This method generates an actual testdeck test case that injects payloads into several fields at the same time
+// fieldNames are the fields to attack
+// set is a json data set in battering ram, pitchfork or cluster bomb mode
+// opts are configurations for the intruder, if not included the default settings will be used
+func TestTheseFields(t *testing.T, ctx context.Context, tc testdeck.TestCase, client interface{}, methodName string, req interface{}, fieldNames []string, set JsonDataSet, opts ...IntruderOptions) {
+	o := intruderOptions(opts)
+	tc = newTestCase(tc)
+
+	// take a copy of the sample request now, so that changes made to it while the test cases are running do not affect them
+	sample := deepCopy(req)
 
-	var (
-		err error
-		res interface{}
-	)
 	// Act
 	tc.Act = func(t *testdeck.TD) {
-		// get the current field to fuzz
-		field := reflect.ValueOf(req).Elem().FieldByName(fieldName)
 
-		// fuzz with different input depending on the type of the field
-		switch field.Kind() {
+		var kinds []reflect.Kind
+		for _, name := range fieldNames {
+			kind := fieldKind(sample, name)
+			if kind == reflect.Invalid {
+				t.Errorf("%T does not have a field called %s that payloads can be injected into", sample, name)
+				return
+			}
+			kinds = append(kinds, kind)
+		}
 
-		// String type
-		case reflect.String:
-			// make a copy of the normal value of this field
-			copy := field.String()
+		runAttack(t, set, methodName, fieldNames, kinds, o, func(payloads []interface{}) (interface{}, error) {
+			// inject the payloads into a copy of the sample request
+			r := deepCopy(sample)
+			for i, name := range fieldNames {
+				if err := injectPayload(r, name, payloads[i]); err != nil {
+					t.Errorf("Failed to inject %v into %s: %s", payloads[i], name, err.Error())
+					return nil, errSkipped
+				}
+			}
+			return grpc.CallRpcMethod(ctx, client, methodName, r)
+		})
+	}
+
+	runTestCase(t, tc, set.mode()+":"+strings.Join(fieldNames, ","), timeBased(set))
+}
+
+// Same as TestThisField but if target is not nil, the stored data is read back with the target method after each payload is sent
+func testField(t *testing.T, ctx context.Context, tc testdeck.TestCase, client interface{}, methodName string, req interface{}, fieldName string, testDataSet InputValidationTestData, target *StoredXSSTarget, opts IntruderOptions) {
+	// take a copy of the sample request for this field, so that changes made to it while the test cases are running do not affect them
+	sample := deepCopy(req)
+	kind := fieldKind(sample, fieldName)
+
+	// sends the request with the payload injected into the field and verifies the response
+	attack := func(t *testdeck.TD, set JsonDataSet, payload interface{}) {
+		// point SSRF and out-of-band payloads to the canary with a unique token, so that callbacks can be linked to this payload
+		var token string
+		if s, ok := payload.(string); ok && opts.Canary != nil && HasCanaryPlaceholder(s) {
+			token = opts.Canary.NewToken(TokenSource{Category: set.Type, Endpoint: methodName, Field: fieldName, Payload: s})
+			payload = opts.Canary.Expand(s, token)
+		}
+		raw := payload
+		payload = set.process(payload)
+		t.Logf("%s Value: %v", fieldName, payload)
+
+		// inject the payload into a copy of the sample request, so that test cases running in parallel do not share any state
+		r := deepCopy(sample)
+		if err := injectPayload(r, fieldName, payload); err != nil {
+			t.Errorf("Failed to inject the payload into %s: %s", fieldName, err.Error())
+			return
+		}
+
+		start := time.Now()
+		res, err := grpc.CallRpcMethod(ctx, client, methodName, r)
+		duration := time.Since(start)
+		// read back the stored payload so that it can be checked instead of the write response
+		if target != nil && err == nil {
+			res, err = target.read(ctx, client, res)
+		}
+
+		attempt := Attempt{
+			Endpoint: methodName,
+			Field:    fieldName,
+			Payload:  fmt.Sprint(raw),
+			Response: res,
+			Duration: duration,
+			Err:      err,
+		}
+		if processed := fmt.Sprint(payload); processed != attempt.Payload {
+			attempt.Processed = processed
+		}
+		if token != "" {
+			attempt.CanaryInteractions = opts.Canary.Claim(token)
+		}
+		for _, f := range VerifyAttempt(t, set, attempt) {
+			opts.Report.Add(f)
+		}
+	}
+
+	runPayloads(t, tc, fieldName, testDataSet.SetsFor(kind), kind, opts, attack)
+}
+
+// Generates the testdeck test cases that send every payload of the data sets to one field
+// Depending on opts.Subtests, there is a test case for each payload (default), for each intruder .txt file, or one for the whole field
+// attack sends one payload and verifies the response
+func runPayloads(t *testing.T, tc testdeck.TestCase, name string, sets []JsonDataSet, kind reflect.Kind, opts IntruderOptions, attack func(t *testdeck.TD, set JsonDataSet, payload interface{})) {
+	if opts.Subtests == SubtestPerField {
+		tc = newTestCase(tc)
+
+		// Act
+		tc.Act = func(t *testdeck.TD) {
+			for _, set := range sets {
+				if err := set.validateProcessors(); err != nil {
+					t.Errorf("Invalid processors in %s data set: %s", set.Type, err.Error())
+					continue
+				}
 
-			for _, set := range testDataSet.Strings {
 				// loop through the intruder .txt files specified in the json file
 				for _, file := range set.Files {
-					strings, _ := GetStringArrayFromTextFile(file)
-					// loop through all the strings in the intruder .txt file
-					for _, s := range strings {
-						t.Logf("String Value: %v", s)
-						field.SetString(s)
-						start := time.Now()
-						res, err = grpc.CallRpcMethod(ctx, client, methodName, req)
-						duration := time.Since(start)
-						VerifyIntruderTestResults(t, set, res, duration, s, err)
+					payloads, err := GetPayloadsFromTextFile(file, kind)
+					if err != nil {
+						t.Errorf("Failed to read payloads from %s: %s", file, err.Error())
+						continue
+					}
+					for _, payload := range payloads {
+						attack(t, set, payload)
 					}
 				}
+			}
+		}
+
+		runTestCase(t, tc, name, timeBased(sets...))
+		return
+	}
 
-				// reset parameter back to the normal value before fuzzing the next field
-				field.SetString(copy)
+	// the test cases of the field are grouped under the name of the field (e.g. MessageBody/Generic_TimeBased:12:sleep(5)#)
+	t.Run(name, func(t *testing.T) {
+		for _, set := range sets {
+			set := set
+			if err := set.validateProcessors(); err != nil {
+				t.Errorf("Invalid processors in %s data set: %s", set.Type, err.Error())
+				continue
 			}
 
-			// Int type
-		case reflect.Int:
-			// make a copy of the normal value of this field
-			copy := field.Int()
+			// loop through the intruder .txt files specified in the json file
+			for _, file := range set.Files {
+				payloads, err := GetPayloadsFromTextFile(file, kind)
+				if err != nil {
+					t.Errorf("Failed to read payloads from %s: %s", file, err.Error())
+					continue
+				}
 
-			for _, set := range testDataSet.Ints {
-				// loop through the intruder .txt files specified in the json file
-				for _, file := range set.Files {
-					ints, _ := GetIntArrayFromTextFile(file)
-					// loop through all the ints in the intruder .txt file
-					for _, i := range ints {
-						t.Logf("Int Value: %v", i)
-						field.SetInt(int64(i))
-						res, err = grpc.CallRpcMethod(ctx, client, methodName, req)
-						VerifyIntruderTestResults(t, set, res, 0, "", err)
+				if opts.Subtests == SubtestPerFile {
+					fileCase := newTestCase(tc)
+					fileCase.Act = func(t *testdeck.TD) {
+						for _, payload := range payloads {
+							attack(t, set, payload)
+						}
 					}
+					runTestCase(t, fileCase, fileTestName(file), timeBased(set))
+					continue
 				}
-				// reset parameter back to the normal value before fuzzing the next field
-				field.SetInt(copy)
-			}
 
-			// Float type
-		case reflect.Float64:
-			// make a copy of the normal value of this field
-			copy := field.Float()
-
-			for _, set := range testDataSet.Floats {
-				// loop through all the intruder .txt files specified in the json file
-				for _, file := range set.Files {
-					floats, _ := GetFloatArrayFromTextFile(file)
-					// loop through all the floats in the intruder .txt file
-					for _, f := range floats {
-						t.Logf("Float Value: %v", f)
-						field.SetFloat(f)
-						//res, err = CallFunction(function, req, apiClient)
-						res, err = grpc.CallRpcMethod(ctx, client, methodName, req)
-						VerifyIntruderTestResults(t, set, res, 0, "", err)
+				for i, payload := range payloads {
+					payload := payload
+					payloadCase := newTestCase(tc)
+					payloadCase.Act = func(t *testdeck.TD) {
+						attack(t, set, payload)
 					}
+					runTestCase(t, payloadCase, payloadTestName(file, i+1, payload), timeBased(set))
 				}
-				// reset parameter back to a normal value before fuzzing the next field
-				field.SetFloat(copy)
 			}
+		}
+	})
+}
 
-			// Bool type
-		case reflect.Bool:
-			// make a copy of the normal value of this field
-			copy := field.Bool()
+// Runs the test case as a subtest, in parallel with the other test cases unless sequential is true
+func runTestCase(t *testing.T, tc testdeck.TestCase, name string, sequential bool) {
+	t.Run(name, func(t *testing.T) {
+		testdeck.Test(t, &tc, testdeck.TestConfig{ParallelOff: sequential})
+	})
+}
 
-			for _, set := range testDataSet.Bools {
-				// loop through all the strings in the intruder .txt file
-				for _, file := range set.Files {
-					bools, _ := GetBoolArrayFromTextFile(file)
-					// loop through all the bools in the intruder .txt file
-					for _, b := range bools {
-						t.Logf("Bool Value: %v", b)
-						field.SetBool(b)
-						//res, err = CallFunction(function, req, apiClient)
-						res, err = grpc.CallRpcMethod(ctx, client, methodName, req)
-						VerifyIntruderTestResults(t, set, res, 0, "", err)
-					}
-				}
-				// reset parameter back to a normal value before fuzzing the next field
-				field.SetBool(copy)
+// Returns true if any of the data sets checks response times (timeDelay or maxLatencyMs)
+// Their payloads are sent one at a time, otherwise the response times would be those of many requests at once
+func timeBased(sets ...JsonDataSet) bool {
+	for _, set := range sets {
+		if set.Expected.TimeDelay > 0 || set.Expected.MaxLatencyMs > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a copy of the test case with its own defer stack
+// Test cases that are generated from the same test case run in parallel, so they must not share the functions deferred by each other
+func newTestCase(tc testdeck.TestCase) testdeck.TestCase {
+	return testdeck.TestCase{
+		Arrange: tc.Arrange,
+		Act:     tc.Act,
+		Assert:  tc.Assert,
+		After:   tc.After,
+	}
+}
+
+// The maximum number of characters of a payload that are used in the name of its test case
+const maxPayloadNameLength = 40
+
+// Returns the name of the test case of an intruder .txt file (the file name without the extension)
+func fileTestName(file string) string {
+	return strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+}
+
+// Returns a readable name for the test case of a payload (e.g. Generic_TimeBased:12:sleep(5)#)
+// The name includes the line number so that the payload can be found in the file even if it was shortened
+func payloadTestName(file string, line int, payload interface{}) string {
+	var b strings.Builder
+	space := false
+	for _, r := range fmt.Sprint(payload) {
+		switch {
+		case unicode.IsSpace(r) || unicode.IsControl(r):
+			// go test replaces spaces with underscores anyway, and control characters break the test name
+			if !space {
+				b.WriteRune('_')
+			}
+			space = true
+			continue
+		case r == '/':
+			// slashes separate subtest names
+			b.WriteString("%2F")
+		default:
+			b.WriteRune(r)
+		}
+		space = false
+	}
+
+	name := b.String()
+	if runes := []rune(name); len(runes) > maxPayloadNameLength {
+		name = string(runes[:maxPayloadNameLength]) + "..."
+	}
+	if name == "" {
+		name = "(empty)"
+	}
+
+	return fmt.Sprintf("%s:%d:%s", fileTestName(file), line, name)
+}
+
+// a field of the sample request that payloads can be injected into
+type requestField struct {
+	name string
+	kind reflect.Kind
+}
+
+// Returns the fields of the request that payloads can be injected into: the exported fields of a generated message
+// (up to the automatically-generated XXX fields), or the singular scalar fields of a dynamic message (see grpc.DynamicClient)
+func requestFields(req interface{}) []requestField {
+	var fields []requestField
+	if m, ok := req.(*dynamicpb.Message); ok {
+		for _, f := range grpc.DynamicFields(m) {
+			fields = append(fields, requestField{name: f.Name, kind: f.Type.Kind()})
+		}
+		return fields
+	}
+
+	t := reflect.TypeOf(req).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		// skip this parameter if it is an automatically-generated field (field name starts with XXX)
+		if strings.HasPrefix(t.Field(i).Name, "XXX") {
+			break
+		}
+		// skip the internal fields of protobuf messages (state, sizeCache, etc.), which cannot be set
+		if t.Field(i).PkgPath != "" {
+			continue
+		}
+		fields = append(fields, requestField{name: t.Field(i).Name, kind: t.Field(i).Type.Kind()})
+	}
+	return fields
+}
+
+// Returns the kind of the field of the request, or reflect.Invalid if payloads cannot be injected into it
+func fieldKind(req interface{}, name string) reflect.Kind {
+	if m, ok := req.(*dynamicpb.Message); ok {
+		for _, f := range grpc.DynamicFields(m) {
+			if f.Name == name {
+				return f.Type.Kind()
 			}
 		}
+		return reflect.Invalid
 	}
 
-	tc.Run(t, fieldName)
+	field := reflect.ValueOf(req).Elem().FieldByName(name)
+	if !field.IsValid() || !field.CanSet() {
+		return reflect.Invalid
+	}
+	return field.Kind()
+}
+
+// Sets the field of the request to the payload, which is converted to the type of the field
+// Returns an error instead of converting payloads of another kind (e.g. an int payload would become a one-rune string)
+// Dynamic messages are set through protoreflect, because their fields are not Go struct fields
+func injectPayload(req interface{}, name string, payload interface{}) error {
+	kind := fieldKind(req, name)
+	if kind == reflect.Invalid {
+		return fmt.Errorf("%T does not have a field called %s that payloads can be injected into", req, name)
+	}
+	v := reflect.ValueOf(payload)
+	if !v.IsValid() || kindGroup(v.Kind()) != kindGroup(kind) {
+		return fmt.Errorf("%v (%T) cannot be injected into %s (%s)", payload, payload, name, kind)
+	}
+
+	if m, ok := req.(*dynamicpb.Message); ok {
+		return grpc.SetDynamicField(m, name, payload)
+	}
+
+	field := reflect.ValueOf(req).Elem().FieldByName(name)
+	if !v.Type().ConvertibleTo(field.Type()) {
+		return fmt.Errorf("%v (%T) cannot be injected into %s (%s)", payload, payload, name, field.Type())
+	}
+	field.Set(v.Convert(field.Type()))
+	return nil
 }
@@ -0,0 +1,306 @@
+package intruder
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mercari/testdeck"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// runs the intruder with a test case that records the names of the testdeck test cases it was run as
+func runIntruderAndCollectNames(t *testing.T, data InputValidationTestData, opts IntruderOptions) []string {
+	var mu sync.Mutex
+	var names []string
+	tc := testdeck.TestCase{
+		Arrange: func(t *testdeck.TD) {
+			mu.Lock()
+			defer mu.Unlock()
+			names = append(names, t.Name())
+		},
+	}
+
+	t.Run("Intruder", func(t *testing.T) {
+		RunIntruderTests(t, context.Background(), tc, &demoSayClient{}, "Say", &demoSayRequest{Id: "1", Body: "hello"}, data, opts)
+	})
+
+	return names
+}
+
+func Test_RunIntruderTests_ShouldCreateTestCasePerPayload(t *testing.T) {
+	// Arrange
+	data := InputValidationTestData{
+		Strings: []JsonDataSet{{Files: []string{"../payloads/input_validation/booleans.txt"}, Type: TypeInputValidation}},
+		Ints:    []JsonDataSet{{Files: []string{"../payloads/input_validation/integers.txt"}, Type: TypeInputValidation}},
+	}
+
+	// Act
+	names := runIntruderAndCollectNames(t, data, IntruderOptions{Report: NewReport()})
+
+	// Assert
+	prefix := t.Name() + "/Intruder/"
+	assert.ElementsMatch(t, []string{
+		prefix + "Id/booleans:1:true",
+		prefix + "Id/booleans:2:false",
+		prefix + "Body/booleans:1:true",
+		prefix + "Body/booleans:2:false",
+		prefix + "Count/integers:1:0",
+		prefix + "Count/integers:2:10",
+		prefix + "Count/integers:3:-1",
+		prefix + "Count/integers:4:9223372036854775807",
+		prefix + "Count/integers:5:-9223372036854775808",
+	}, names)
+}
+
+func Test_RunIntruderTests_ShouldCreateTestCasePerFile(t *testing.T) {
+	// Arrange
+	data := InputValidationTestData{
+		Strings: []JsonDataSet{{
+			Files: []string{"../payloads/input_validation/booleans.txt", "../payloads/input_validation/floats.txt"},
+			Type:  TypeInputValidation,
+		}},
+	}
+
+	// Act
+	names := runIntruderAndCollectNames(t, data, IntruderOptions{Report: NewReport(), Subtests: SubtestPerFile})
+
+	// Assert
+	prefix := t.Name() + "/Intruder/"
+	assert.ElementsMatch(t, []string{
+		prefix + "Id/booleans",
+		prefix + "Id/floats",
+		prefix + "Body/booleans",
+		prefix + "Body/floats",
+	}, names)
+}
+
+// a client that records how many calls were running at the same time
+type concurrencyClient struct {
+	mu      sync.Mutex
+	running int
+	max     int
+}
+
+func (c *concurrencyClient) Say(ctx context.Context, req *demoSayRequest) (*demoSayRequest, error) {
+	c.mu.Lock()
+	c.running++
+	if c.running > c.max {
+		c.max = c.running
+	}
+	c.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	c.mu.Lock()
+	c.running--
+	c.mu.Unlock()
+	return &demoSayRequest{Id: "ok"}, nil
+}
+
+func Test_RunIntruderTests_ShouldSendTimeBasedPayloadsSequentially(t *testing.T) {
+	// Arrange
+	client := &concurrencyClient{}
+	data := InputValidationTestData{
+		Strings: []JsonDataSet{{
+			Files:    []string{"../payloads/input_validation/booleans.txt", "../payloads/input_validation/floats.txt"},
+			Type:     TypeInputValidation,
+			Expected: ExpectedResult{MaxLatencyMs: 10000},
+		}},
+	}
+
+	// Act
+	t.Run("Intruder", func(t *testing.T) {
+		RunIntruderTests(t, context.Background(), testdeck.TestCase{}, client, "Say", &demoSayRequest{Id: "1"}, data, IntruderOptions{Report: NewReport()})
+	})
+
+	// Assert
+	assert.Equal(t, 1, client.max)
+}
+
+// a client that echoes the body back in two fields of the response, like a vulnerable service would
+type echoSayClient struct{}
+
+func (echoSayClient) Say(ctx context.Context, req *demoSayRequest) (*demoSayRequest, error) {
+	return &demoSayRequest{Id: req.Body, Body: req.Body}, nil
+}
+
+func Test_RunIntruderTests_ShouldReportFindingsWithoutFailing(t *testing.T) {
+	// Arrange
+	file := filepath.Join(t.TempDir(), "xss.txt")
+	require.Nil(t, ioutil.WriteFile(file, []byte("<script>alert(1)</script>\n<img src=x onerror=alert(1)>\n"), 0644))
+	data := InputValidationTestData{
+		Strings: []JsonDataSet{{Files: []string{file}, Type: TypeReflectedXSS}},
+	}
+	report := NewReport()
+
+	// Act
+	passed := t.Run("Intruder", func(t *testing.T) {
+		RunIntruderTests(t, context.Background(), testdeck.TestCase{}, echoSayClient{}, "Say", &demoSayRequest{Id: "1", Body: "hello"}, data, IntruderOptions{Report: report})
+	})
+
+	// Assert
+	assert.True(t, passed, "findings should not fail the test")
+	findings := report.Findings()
+	require.Len(t, findings, 1)
+	assert.Equal(t, "Body", findings[0].Field)
+	assert.Equal(t, 2, findings[0].Occurrences, "each payload should be counted once, although it was echoed back in two fields")
+}
+
+// a client that stores the body of each message and only returns it when the message is read back, like a vulnerable comment service would
+type storingSayClient struct {
+	mu     sync.Mutex
+	bodies map[string]string
+}
+
+func (c *storingSayClient) Post(ctx context.Context, req *demoSayRequest) (*demoSayRequest, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := fmt.Sprint(len(c.bodies) + 1)
+	c.bodies[id] = req.Body
+	return &demoSayRequest{Id: id}, nil
+}
+
+func (c *storingSayClient) Get(ctx context.Context, req *demoSayRequest) (*demoSayRequest, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &demoSayRequest{Id: req.Id, Body: c.bodies[req.Id]}, nil
+}
+
+func Test_RunStoredXSSTests_ShouldReportPayloadsReadBackThroughTarget(t *testing.T) {
+	// Arrange
+	file := filepath.Join(t.TempDir(), "xss.txt")
+	require.Nil(t, ioutil.WriteFile(file, []byte("<script>alert(1)</script>\n"), 0644))
+	data := InputValidationTestData{
+		Strings: []JsonDataSet{{Files: []string{file}, Type: TypeStoredXSS}},
+	}
+	client := &storingSayClient{bodies: make(map[string]string)}
+	target := StoredXSSTarget{
+		MethodName: "Get",
+		Request:    &demoSayRequest{},
+		RequestFrom: func(writeRes interface{}) interface{} {
+			return &demoSayRequest{Id: writeRes.(*demoSayRequest).Id}
+		},
+	}
+	report := NewReport()
+
+	// Act
+	t.Run("StoredXSS", func(t *testing.T) {
+		RunStoredXSSTests(t, context.Background(), testdeck.TestCase{}, client, "Post", &demoSayRequest{Id: "1", Body: "hello"}, target, data, IntruderOptions{Report: report})
+	})
+
+	// Assert
+	findings := report.Findings()
+	require.Len(t, findings, 1, "only the body is returned by the read method")
+	assert.Equal(t, TypeStoredXSS, findings[0].Category)
+	assert.Equal(t, SeverityHigh, findings[0].Severity)
+	assert.Equal(t, "Post", findings[0].Endpoint)
+	assert.Equal(t, "Body", findings[0].Field)
+}
+
+func Test_RunStoredXSSTests_ShouldOnlyTestStringFields(t *testing.T) {
+	// Arrange
+	data := InputValidationTestData{
+		Strings: []JsonDataSet{{Files: []string{"../payloads/xss/XSSDetection.txt"}, Type: TypeStoredXSS}},
+	}
+	var mu sync.Mutex
+	var names []string
+	tc := testdeck.TestCase{
+		Arrange: func(t *testdeck.TD) {
+			mu.Lock()
+			defer mu.Unlock()
+			names = append(names, t.Name())
+		},
+	}
+	target := StoredXSSTarget{MethodName: "Get", Request: &demoSayRequest{}}
+
+	// Act
+	t.Run("StoredXSS", func(t *testing.T) {
+		RunStoredXSSTests(t, context.Background(), tc, &storingSayClient{bodies: make(map[string]string)}, "Post", &demoSayRequest{Id: "1", Body: "hello", Count: 1}, target, data, IntruderOptions{Report: NewReport(), Subtests: SubtestPerField})
+	})
+
+	// Assert
+	assert.ElementsMatch(t, []string{
+		"Test_RunStoredXSSTests_ShouldOnlyTestStringFields/StoredXSS/Id",
+		"Test_RunStoredXSSTests_ShouldOnlyTestStringFields/StoredXSS/Body",
+	}, names, "Count is an int32 field, which cannot hold XSS payloads")
+}
+
+// a client like grpc.DynamicClient that records the requests it was called with
+type dynamicInvoker struct {
+	mu       sync.Mutex
+	requests []*testpb.SimpleRequest
+}
+
+func (c *dynamicInvoker) InvokeMethod(ctx context.Context, methodName string, req interface{}, opts ...grpc.CallOption) (interface{}, error) {
+	b, err := proto.Marshal(req.(*dynamicpb.Message))
+	if err != nil {
+		return nil, err
+	}
+	r := &testpb.SimpleRequest{}
+	if err := proto.Unmarshal(b, r); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests = append(c.requests, r)
+	return map[string]interface{}{}, nil
+}
+
+func Test_RunIntruderTests_ShouldInjectIntoDynamicMessages(t *testing.T) {
+	// Arrange
+	client := &dynamicInvoker{}
+	sample := dynamicpb.NewMessage((&testpb.SimpleRequest{}).ProtoReflect().Descriptor())
+	size := sample.Descriptor().Fields().ByName("response_size")
+	sample.Set(size, protoreflect.ValueOfInt32(7))
+	data := InputValidationTestData{
+		Ints: []JsonDataSet{{Files: []string{"../payloads/input_validation/integers.txt"}, Type: TypeInputValidation}},
+	}
+
+	// Act
+	t.Run("Intruder", func(t *testing.T) {
+		RunIntruderTests(t, context.Background(), testdeck.TestCase{}, client, "UnaryCall", sample, data, IntruderOptions{Report: NewReport()})
+	})
+
+	// Assert
+	// integers.txt has 5 payloads, which are injected into response_type and response_size
+	require.Len(t, client.requests, 10)
+	var sizes []int32
+	for _, r := range client.requests {
+		if r.ResponseType == 0 {
+			sizes = append(sizes, r.ResponseSize)
+		}
+	}
+	assert.Contains(t, sizes, int32(10))
+	assert.Contains(t, sizes, int32(-1))
+	assert.Equal(t, int64(7), sample.Get(size).Int(), "the sample request should not be modified")
+}
+
+func Test_payloadTestName(t *testing.T) {
+	tests := []struct {
+		payload interface{}
+		want    string
+	}{
+		{"sleep(5)#", "file:3:sleep(5)#"},
+		{"../../etc/passwd", "file:3:..%2F..%2Fetc%2Fpasswd"},
+		{"1 or\r\n\tsleep(5)\x00", "file:3:1_or_sleep(5)_"},
+		{"", "file:3:(empty)"},
+		{-1, "file:3:-1"},
+		{"<script>alert(document.cookie)</script><b>hello</b>", "file:3:<script>alert(document.cookie)<%2Fscript..."},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, payloadTestName("../payloads/file.txt", 3, tt.payload))
+	}
+}
@@ -0,0 +1,130 @@
+package intruder
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+/*
+processors.go: Payload processors that encode or transform payloads before they are injected into the request (e.g. to get past WAFs and validators)
+*/
+
+// Types of payload processors
+const (
+	ProcessorURLEncode     = "urlencode"     // URL-encodes the payload (e.g. %3Cscript%3E)
+	ProcessorBase64        = "base64"        // base64-encodes the payload
+	ProcessorHTMLEncode    = "htmlencode"    // escapes HTML special characters (e.g. &lt;script&gt;)
+	ProcessorUnicodeEncode = "unicodeencode" // escapes every character that is not a letter or digit as \uXXXX
+	ProcessorUpperCase     = "uppercase"     // changes the payload to upper case
+	ProcessorLowerCase     = "lowercase"     // changes the payload to lower case
+	ProcessorPrefix        = "prefix"        // adds Value to the start of the payload
+	ProcessorSuffix        = "suffix"        // adds Value to the end of the payload
+	ProcessorTruncate      = "truncate"      // cuts the payload down to Length characters
+)
+
+// Processor transforms the payloads of a data set before they are injected into the request
+// Processors of a data set are applied in order, e.g. [{"type": "prefix", "value": "'"}, {"type": "urlencode"}]
+type Processor struct {
+	Type   string `json:"type"`
+	Value  string `json:"value"`  // the string to add (prefix and suffix only)
+	Length int    `json:"length"` // the maximum number of characters (truncate only)
+}
+
+// Returns an error if the processor cannot be applied
+func (p Processor) Validate() error {
+	switch p.Type {
+	case ProcessorURLEncode, ProcessorBase64, ProcessorHTMLEncode, ProcessorUnicodeEncode,
+		ProcessorUpperCase, ProcessorLowerCase, ProcessorPrefix, ProcessorSuffix:
+		return nil
+	case ProcessorTruncate:
+		if p.Length <= 0 {
+			return fmt.Errorf("truncate processor needs a length greater than 0, got %d", p.Length)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown payload processor %q", p.Type)
+}
+
+// Returns the payload transformed by the processor
+// Payloads are returned as-is by invalid processors, so call Validate first
+func (p Processor) Apply(payload string) string {
+	switch p.Type {
+	case ProcessorURLEncode:
+		return url.QueryEscape(payload)
+	case ProcessorBase64:
+		return base64.StdEncoding.EncodeToString([]byte(payload))
+	case ProcessorHTMLEncode:
+		return html.EscapeString(payload)
+	case ProcessorUnicodeEncode:
+		return unicodeEscape(payload)
+	case ProcessorUpperCase:
+		return strings.ToUpper(payload)
+	case ProcessorLowerCase:
+		return strings.ToLower(payload)
+	case ProcessorPrefix:
+		return p.Value + payload
+	case ProcessorSuffix:
+		return payload + p.Value
+	case ProcessorTruncate:
+		if runes := []rune(payload); p.Length > 0 && len(runes) > p.Length {
+			return string(runes[:p.Length])
+		}
+	}
+	return payload
+}
+
+// Applies the processors to the payload in order
+func ProcessPayload(payload string, processors []Processor) (string, error) {
+	for _, p := range processors {
+		if err := p.Validate(); err != nil {
+			return payload, err
+		}
+		payload = p.Apply(payload)
+	}
+	return payload, nil
+}
+
+// Returns an error if any of the processors of the data set is invalid
+func (s JsonDataSet) validateProcessors() error {
+	for _, p := range s.Processors {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Applies the processors of the data set to the payload
+// Only string payloads are processed, other types are returned as-is
+func (s JsonDataSet) process(payload interface{}) interface{} {
+	str, ok := payload.(string)
+	if !ok {
+		return payload
+	}
+	for _, p := range s.Processors {
+		str = p.Apply(str)
+	}
+	return str
+}
+
+// Escapes every character that is not a letter or digit as \uXXXX (characters outside the BMP are escaped as surrogate pairs)
+func unicodeEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			continue
+		}
+		if r1, r2 := utf16.EncodeRune(r); r1 != unicode.ReplacementChar {
+			fmt.Fprintf(&b, `\u%04x\u%04x`, r1, r2)
+			continue
+		}
+		fmt.Fprintf(&b, `\u%04x`, r)
+	}
+	return b.String()
+}
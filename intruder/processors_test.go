@@ -0,0 +1,90 @@
+package intruder
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mercari/testdeck"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Processor_Apply(t *testing.T) {
+	payload := `<img src=x onerror="alert(1)">`
+
+	tests := []struct {
+		processor Processor
+		want      string
+	}{
+		{Processor{Type: ProcessorURLEncode}, "%3Cimg+src%3Dx+onerror%3D%22alert%281%29%22%3E"},
+		{Processor{Type: ProcessorBase64}, "PGltZyBzcmM9eCBvbmVycm9yPSJhbGVydCgxKSI+"},
+		{Processor{Type: ProcessorHTMLEncode}, "&lt;img src=x onerror=&#34;alert(1)&#34;&gt;"},
+		{Processor{Type: ProcessorUnicodeEncode}, `\u003cimg\u0020src\u003dx\u0020onerror\u003d\u0022alert\u00281\u0029\u0022\u003e`},
+		{Processor{Type: ProcessorUpperCase}, `<IMG SRC=X ONERROR="ALERT(1)">`},
+		{Processor{Type: ProcessorLowerCase}, payload},
+		{Processor{Type: ProcessorPrefix, Value: `">`}, `"><img src=x onerror="alert(1)">`},
+		{Processor{Type: ProcessorSuffix, Value: "//"}, `<img src=x onerror="alert(1)">//`},
+		{Processor{Type: ProcessorTruncate, Length: 8}, "<img src"},
+		{Processor{Type: ProcessorTruncate, Length: 100}, payload},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.processor.Type, func(t *testing.T) {
+			require.Nil(t, tt.processor.Validate())
+			assert.Equal(t, tt.want, tt.processor.Apply(payload))
+		})
+	}
+}
+
+func Test_ProcessPayload_ShouldApplyProcessorsInOrder(t *testing.T) {
+	// Arrange
+	processors := []Processor{
+		{Type: ProcessorPrefix, Value: "'"},
+		{Type: ProcessorURLEncode},
+		{Type: ProcessorTruncate, Length: 10},
+	}
+
+	// Act
+	got, err := ProcessPayload("or 1=1--", processors)
+
+	// Assert
+	require.Nil(t, err)
+	assert.Equal(t, "%27or+1%3D", got)
+}
+
+func Test_ProcessPayload_ShouldReturnErrorForInvalidProcessor(t *testing.T) {
+	_, err := ProcessPayload("a", []Processor{{Type: "rot13"}})
+	assert.NotNil(t, err)
+
+	_, err = ProcessPayload("a", []Processor{{Type: ProcessorTruncate}})
+	assert.NotNil(t, err)
+}
+
+func Test_RunIntruderTests_ShouldProcessPayloadsBeforeSending(t *testing.T) {
+	// Arrange
+	client := &demoSayClient{}
+	data := InputValidationTestData{
+		Strings: []JsonDataSet{{
+			Files: []string{"../payloads/input_validation/booleans.txt"},
+			Type:  TypeInputValidation,
+			Processors: []Processor{
+				{Type: ProcessorUpperCase},
+				{Type: ProcessorSuffix, Value: "=="},
+				{Type: ProcessorBase64},
+			},
+		}},
+	}
+
+	// Act
+	t.Run("Intruder", func(t *testing.T) {
+		RunIntruderTests(t, context.Background(), testdeck.TestCase{}, client, "Say", &demoSayRequest{Id: "1"}, data, IntruderOptions{Report: NewReport()})
+	})
+
+	// Assert
+	assert.ElementsMatch(t, []demoSayRequest{
+		{Id: "VFJVRT09"},
+		{Id: "RkFMU0U9PQ=="},
+		{Id: "1", Body: "VFJVRT09"},
+		{Id: "1", Body: "RkFMU0U9PQ=="},
+	}, client.requests)
+}
@@ -0,0 +1,272 @@
+package intruder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+/*
+report.go: Security findings produced by the intruder and helper methods for exporting them as JSON or SARIF
+*/
+
+// Severity of a finding
+const (
+	SeverityHigh   = "high"
+	SeverityMedium = "medium"
+	SeverityLow    = "low"
+	SeverityInfo   = "info"
+)
+
+// used for comparing severities when the same finding is reported more than once
+var severityRank = map[string]int{
+	SeverityInfo:   0,
+	SeverityLow:    1,
+	SeverityMedium: 2,
+	SeverityHigh:   3,
+}
+
+// Finding is a potential vulnerability found by the intruder
+type Finding struct {
+	Category    string `json:"category"`    // the type of vulnerability (same as the json data set type, e.g. "sql injection")
+	Endpoint    string `json:"endpoint"`    // the rpc method that was tested
+	Field       string `json:"field"`       // the request field the payload was injected into
+	Payload     string `json:"payload"`     // the first payload that triggered the finding
+	Evidence    string `json:"evidence"`    // why the response is considered vulnerable
+	Severity    string `json:"severity"`    // one of SeverityHigh, SeverityMedium, SeverityLow, SeverityInfo
+	Occurrences int    `json:"occurrences"` // the number of distinct payloads that triggered the same finding (counted by the report)
+}
+
+// Returns the key used for deduplicating findings; payloads that hit the same category, endpoint and field are the same finding
+func (f Finding) key() string {
+	return strings.Join([]string{f.Category, f.Endpoint, f.Field}, "\x00")
+}
+
+// Report collects the findings of intruder tests
+// It is safe to add findings from tests that run in parallel
+type Report struct {
+	mu       sync.Mutex
+	findings []Finding
+	index    map[string]int             // finding key -> index in findings
+	payloads map[string]map[string]bool // finding key -> payloads that have been counted in Occurrences
+}
+
+// DefaultReport is the report that findings are added to when no report is specified in IntruderOptions
+var DefaultReport = NewReport()
+
+// Creates an empty report
+func NewReport() *Report {
+	return &Report{
+		index:    make(map[string]int),
+		payloads: make(map[string]map[string]bool),
+	}
+}
+
+// Adds a finding to the report
+// If the same finding was already reported, only the number of occurrences (and the severity, if it is higher) is updated
+// Occurrences counts payloads, so a payload that is found in several response fields or reported again is only counted once
+func (r *Report) Add(f Finding) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := f.key()
+	counted := r.payloads[key]
+	if counted == nil {
+		counted = make(map[string]bool)
+		r.payloads[key] = counted
+	}
+	newPayload := !counted[f.Payload]
+	counted[f.Payload] = true
+
+	if i, ok := r.index[key]; ok {
+		existing := &r.findings[i]
+		if newPayload {
+			existing.Occurrences++
+		}
+		if severityRank[f.Severity] > severityRank[existing.Severity] {
+			existing.Severity = f.Severity
+			existing.Payload = f.Payload
+			existing.Evidence = f.Evidence
+		}
+		return
+	}
+
+	f.Occurrences = 1
+	r.index[key] = len(r.findings)
+	r.findings = append(r.findings, f)
+}
+
+// Returns a copy of all findings in the order they were first reported
+func (r *Report) Findings() []Finding {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	findings := make([]Finding, len(r.findings))
+	copy(findings, r.findings)
+	return findings
+}
+
+// Writes the findings as a json array
+func (r *Report) WriteJSON(w io.Writer) error {
+	findings := r.Findings()
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(findings)
+}
+
+// Writes the findings as a SARIF 2.1.0 log (https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html)
+func (r *Report) WriteSARIF(w io.Writer) error {
+	findings := r.Findings()
+
+	run := sarifRun{
+		Tool: sarifTool{
+			Driver: sarifDriver{
+				Name:           "testdeck-intruder",
+				InformationURI: "https://github.com/mercari/testdeck",
+				Rules:          []sarifRule{},
+			},
+		},
+		Results: []sarifResult{},
+	}
+
+	rules := make(map[string]bool)
+	for _, f := range findings {
+		id := ruleID(f.Category)
+		if !rules[id] {
+			rules[id] = true
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+				ID:               id,
+				Name:             f.Category,
+				ShortDescription: sarifMessage{Text: fmt.Sprintf("Potential %s", f.Category)},
+			})
+		}
+
+		run.Results = append(run.Results, sarifResult{
+			RuleID:  id,
+			Level:   sarifLevel(f.Severity),
+			Message: sarifMessage{Text: fmt.Sprintf("Potential %s in %s > %s: %s", f.Category, f.Endpoint, f.Field, f.Evidence)},
+			Locations: []sarifLocation{{
+				LogicalLocations: []sarifLogicalLocation{{
+					Name:               f.Field,
+					FullyQualifiedName: f.Endpoint + "/" + f.Field,
+					Kind:               "member",
+				}},
+			}},
+			PartialFingerprints: map[string]string{
+				"testdeck/v1": strings.Join([]string{id, f.Endpoint, f.Field}, "/"),
+			},
+			Properties: map[string]interface{}{
+				"payload":     f.Payload,
+				"severity":    f.Severity,
+				"occurrences": f.Occurrences,
+			},
+		})
+	}
+
+	log := sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(log)
+}
+
+// Saves the findings to a json file
+func (r *Report) SaveJSON(filename string) error {
+	return saveReport(filename, r.WriteJSON)
+}
+
+// Saves the findings to a SARIF file
+func (r *Report) SaveSARIF(filename string) error {
+	return saveReport(filename, r.WriteSARIF)
+}
+
+func saveReport(filename string, write func(w io.Writer) error) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Converts a finding category into a SARIF rule ID (e.g. "sql injection" -> "sql-injection")
+func ruleID(category string) string {
+	return strings.ReplaceAll(strings.ToLower(category), " ", "-")
+}
+
+// Converts a severity into a SARIF result level
+func sarifLevel(severity string) string {
+	switch severity {
+	case SeverityHigh:
+		return "error"
+	case SeverityMedium:
+		return "warning"
+	default:
+		return "note"
+	}
+}
+
+// ----------
+// structs representing the parts of a SARIF log that are used by the report
+// ----------
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	Name             string       `json:"name"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID              string                 `json:"ruleId"`
+	Level               string                 `json:"level"`
+	Message             sarifMessage           `json:"message"`
+	Locations           []sarifLocation        `json:"locations"`
+	PartialFingerprints map[string]string      `json:"partialFingerprints"`
+	Properties          map[string]interface{} `json:"properties"`
+}
+
+type sarifLocation struct {
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations"`
+}
+
+type sarifLogicalLocation struct {
+	Name               string `json:"name"`
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
@@ -0,0 +1,103 @@
+package intruder
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Report_ShouldDeduplicateFindingsAcrossPayloads(t *testing.T) {
+	// Arrange
+	r := NewReport()
+	first := Finding{Category: TypeReflectedXSS, Endpoint: "Say", Field: "MessageBody", Payload: "<b>", Severity: SeverityMedium}
+	second := Finding{Category: TypeReflectedXSS, Endpoint: "Say", Field: "MessageBody", Payload: "<i>", Severity: SeverityMedium}
+	other := Finding{Category: TypeSQLInjection, Endpoint: "Say", Field: "MessageBody", Payload: "sleep(5)#", Severity: SeverityHigh}
+
+	// Act
+	r.Add(first)
+	r.Add(second)
+	r.Add(other)
+
+	// Assert
+	got := r.Findings()
+	require.Equal(t, 2, len(got))
+	assert.Equal(t, "<b>", got[0].Payload)
+	assert.Equal(t, 2, got[0].Occurrences)
+	assert.Equal(t, 1, got[1].Occurrences)
+}
+
+func Test_Report_ShouldCountEachPayloadOnce(t *testing.T) {
+	// Arrange
+	r := NewReport()
+	inBody := Finding{Category: TypeReflectedXSS, Endpoint: "Say", Field: "MessageBody", Payload: "<b>", Evidence: "payload was echoed back in response field Body", Severity: SeverityMedium}
+	inTitle := inBody
+	inTitle.Evidence = "payload was echoed back in response field Title"
+
+	// Act
+	r.Add(inBody)
+	r.Add(inTitle)
+	r.Add(inBody)
+
+	// Assert
+	got := r.Findings()
+	require.Equal(t, 1, len(got))
+	assert.Equal(t, 1, got[0].Occurrences, "a payload found in several response fields is one occurrence")
+}
+
+func Test_Report_ShouldKeepHighestSeverity(t *testing.T) {
+	// Arrange
+	r := NewReport()
+
+	// Act
+	r.Add(Finding{Category: TypeStoredXSS, Endpoint: "Post", Field: "Body", Payload: "a", Severity: SeverityLow})
+	r.Add(Finding{Category: TypeStoredXSS, Endpoint: "Post", Field: "Body", Payload: "b", Severity: SeverityHigh})
+
+	// Assert
+	got := r.Findings()
+	require.Equal(t, 1, len(got))
+	assert.Equal(t, SeverityHigh, got[0].Severity)
+	assert.Equal(t, "b", got[0].Payload)
+}
+
+func Test_Report_WriteJSON(t *testing.T) {
+	// Arrange
+	r := NewReport()
+	r.Add(Finding{Category: TypeSQLInjection, Endpoint: "Say", Field: "MessageId", Payload: "sleep(5)#", Severity: SeverityHigh})
+	var buf bytes.Buffer
+
+	// Act
+	err := r.WriteJSON(&buf)
+
+	// Assert
+	require.Nil(t, err)
+	var got []Finding
+	require.Nil(t, json.Unmarshal(buf.Bytes(), &got))
+	assert.Equal(t, r.Findings(), got)
+}
+
+func Test_Report_WriteSARIF(t *testing.T) {
+	// Arrange
+	r := NewReport()
+	r.Add(Finding{Category: TypeSQLInjection, Endpoint: "Say", Field: "MessageId", Payload: "sleep(5)#", Severity: SeverityHigh})
+	r.Add(Finding{Category: TypeReflectedXSS, Endpoint: "Say", Field: "MessageBody", Payload: "<b>", Severity: SeverityMedium})
+	var buf bytes.Buffer
+
+	// Act
+	err := r.WriteSARIF(&buf)
+
+	// Assert
+	require.Nil(t, err)
+	var got sarifLog
+	require.Nil(t, json.Unmarshal(buf.Bytes(), &got))
+	assert.Equal(t, "2.1.0", got.Version)
+	require.Equal(t, 1, len(got.Runs))
+	assert.Equal(t, 2, len(got.Runs[0].Tool.Driver.Rules))
+	require.Equal(t, 2, len(got.Runs[0].Results))
+	assert.Equal(t, "sql-injection", got.Runs[0].Results[0].RuleID)
+	assert.Equal(t, "error", got.Runs[0].Results[0].Level)
+	assert.Equal(t, "warning", got.Runs[0].Results[1].Level)
+	assert.Equal(t, "Say/MessageBody", got.Runs[0].Results[1].Locations[0].LogicalLocations[0].FullyQualifiedName)
+}
@@ -3,8 +3,10 @@ package intruder
 import (
 	"bufio"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"reflect"
 	"strconv"
 )
 
@@ -25,19 +27,48 @@ type InputValidationTestData struct {
 	Bools   []JsonDataSet `json:"bool"`
 }
 
+// types of json data sets
+const (
+	TypeInputValidation  = "input validation"
+	TypeSQLInjection     = "sql injection"
+	TypeReflectedXSS     = "reflected xss"
+	TypeStoredXSS        = "stored xss"
+	TypeCommandInjection = "command injection"
+	TypePathTraversal    = "path traversal"
+	TypeSSRF             = "ssrf"
+	TypeXXE              = "xxe"
+)
+
 // represents a json data set
 // Files is the list of intruder .txt files to use
 // Expected is the expected result
+// Mode is the attack mode (see attack.go), the default sniper mode injects payloads into one field at a time
 type JsonDataSet struct {
 	Files    []string       `json:"files"`
 	Type     string         `json:"type"`
 	Expected ExpectedResult `json:"expected"`
+
+	Processors []Processor `json:"processors"` // applied to string payloads in order before they are injected (see processors.go)
+
+	Mode        string              `json:"mode"`
+	Fields      []string            `json:"fields"`      // the fields to attack at the same time (all fields of the data set's type if empty)
+	FieldFiles  map[string][]string `json:"fieldFiles"`  // intruder .txt files for each field (Files is used for fields that are not listed)
+	MaxRequests int                 `json:"maxRequests"` // the maximum number of requests to send (DefaultMaxRequests if 0)
 }
 
+// the expected result of each payload
+// StatusCode, PresentFields, AbsentFields, FieldPatterns, MaxLatencyMs and MustNotContainPayload are checked for every type of data set
 type ExpectedResult struct {
-	// TODO: Clarify what else needs to be checked in the response
-	ErrorMessage string `json:"errorMessage"`
-	TimeDelay    int    `json:"timeDelay"`
+	ErrorMessage string   `json:"errorMessage"`
+	TimeDelay    int      `json:"timeDelay"`
+	Signatures   []string `json:"signatures"` // strings that are only found in the response if the attack worked (e.g. "root:x:0:0:")
+
+	StatusCode            string            `json:"statusCode"`            // the expected gRPC status code (e.g. "InvalidArgument") or HTTP status code (e.g. "400" or "4xx"), "OK" means that no error should be returned
+	PresentFields         []string          `json:"presentFields"`         // response fields that must be set (e.g. "Message.Id")
+	AbsentFields          []string          `json:"absentFields"`          // response fields that must not be set (e.g. "Debug.StackTrace")
+	FieldPatterns         map[string]string `json:"fieldPatterns"`         // regular expressions that response fields must match
+	MaxLatencyMs          int               `json:"maxLatencyMs"`          // the maximum response time in milliseconds (unlike TimeDelay, which is in seconds)
+	MustNotContainPayload bool              `json:"mustNotContainPayload"` // the payload must not be echoed back in the response or the error message
 }
 
 // Parse input validation json testdata data into a struct
@@ -48,6 +79,56 @@ func ParseInputValidationTestDataFromJson(file string) (InputValidationTestData,
 	return data, err
 }
 
+// Returns the data sets for fields of the specified type
+func (d InputValidationTestData) SetsFor(kind reflect.Kind) []JsonDataSet {
+	switch kind {
+	case reflect.String:
+		return d.Strings
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return d.Ints
+	case reflect.Float32, reflect.Float64:
+		return d.Floats
+	case reflect.Bool:
+		return d.Bools
+	}
+	return nil
+}
+
+// Parses an intruder .txt file into an array of payloads for fields of the specified type
+// The payloads are string, int, float64 or bool depending on the type
+func GetPayloadsFromTextFile(filename string, kind reflect.Kind) ([]interface{}, error) {
+	var payloads []interface{}
+
+	switch kind {
+	case reflect.String:
+		values, err := GetStringArrayFromTextFile(filename)
+		for _, v := range values {
+			payloads = append(payloads, v)
+		}
+		return payloads, err
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		values, err := GetIntArrayFromTextFile(filename)
+		for _, v := range values {
+			payloads = append(payloads, v)
+		}
+		return payloads, err
+	case reflect.Float32, reflect.Float64:
+		values, err := GetFloatArrayFromTextFile(filename)
+		for _, v := range values {
+			payloads = append(payloads, v)
+		}
+		return payloads, err
+	case reflect.Bool:
+		values, err := GetBoolArrayFromTextFile(filename)
+		for _, v := range values {
+			payloads = append(payloads, v)
+		}
+		return payloads, err
+	}
+
+	return nil, fmt.Errorf("payloads of type %s are not supported", kind)
+}
+
 // Parses an intruder .txt file into a string array
 func GetStringArrayFromTextFile(filename string) ([]string, error) {
 	var array []string
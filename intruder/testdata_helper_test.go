@@ -49,3 +49,32 @@ func Test_ReadBoolsFromTextFile(t *testing.T) {
 
 	assert.NotNil(t, ints, "Failed to create bool array from text file")
 }
+
+func Test_ParsePayloadTestDataFromJson(t *testing.T) {
+	files := []string{
+		"../payloads/command_injection/testdata.json",
+		"../payloads/out_of_band/testdata.json",
+		"../payloads/path_traversal/testdata.json",
+		"../payloads/ssrf/testdata.json",
+		"../payloads/sql_injection/testdata.json",
+		"../payloads/xss/testdata.json",
+		"../payloads/xss/stored_testdata.json",
+	}
+
+	for _, file := range files {
+		data, err := ParseInputValidationTestDataFromJson(file)
+		if err != nil {
+			t.Fatalf("Failed to parse %s, got %s", file, err.Error())
+		}
+
+		for _, set := range data.Strings {
+			for _, payloadFile := range set.Files {
+				payloads, err := GetStringArrayFromTextFile(payloadFile)
+				if err != nil {
+					t.Fatalf("Failed to read %s, got %s", payloadFile, err.Error())
+				}
+				assert.NotEmpty(t, payloads, "Payload file %s is empty", payloadFile)
+			}
+		}
+	}
+}
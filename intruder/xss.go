@@ -0,0 +1,181 @@
+package intruder
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/mercari/testdeck/grpcutils"
+)
+
+/*
+xss.go: Helper methods for finding reflected and stored XSS payloads in responses
+*/
+
+// Encodings that a reflected payload can be found in
+const (
+	EncodingNone    = "none"    // the payload was echoed back as-is
+	EncodingCase    = "case"    // the payload was echoed back with different letter case
+	EncodingHTML    = "html"    // the payload was HTML-escaped
+	EncodingURL     = "url"     // the payload was URL-encoded
+	EncodingUnicode = "unicode" // the payload was escaped with \uXXXX sequences (e.g. by a JSON encoder)
+)
+
+// the deepest level of nesting that will be searched in a response
+const maxReflectionDepth = 32
+
+// Reflection represents a field of the response that echoed the payload back
+type Reflection struct {
+	Field    string // path of the field in the response (e.g. Items[0].Name)
+	Encoding string // how the payload was changed before it was echoed back
+}
+
+// Encoded returns true if the payload was escaped in a way that makes it safe to render
+func (r Reflection) Encoded() bool {
+	return r.Encoding != EncodingNone && r.Encoding != EncodingCase
+}
+
+// StoredXSSTarget is the rpc method that reads back the payloads written by the method under test
+type StoredXSSTarget struct {
+	Client     interface{} // the grpc client for the read method (optional, the client of the write method is used if nil)
+	MethodName string      // the rpc method that returns the stored data (e.g. "GetComment")
+	Request    interface{} // a sample, valid request for the read method
+
+	// builds the read request from the write response, e.g. to look up the ID of a created resource (optional)
+	RequestFrom func(writeRes interface{}) interface{}
+}
+
+// Calls the read method of the target and returns its response
+func (s *StoredXSSTarget) read(ctx context.Context, client interface{}, writeRes interface{}) (interface{}, error) {
+	if s.Client != nil {
+		client = s.Client
+	}
+
+	req := deepCopy(s.Request)
+	if s.RequestFrom != nil {
+		req = s.RequestFrom(writeRes)
+	}
+
+	return grpc.CallRpcMethod(ctx, client, s.MethodName, req)
+}
+
+// FindReflections walks through all fields of the response and returns every field that contains the payload
+// res is the response returned by the endpoint
+// payload is the value that was injected into the request
+func FindReflections(res interface{}, payload string) []Reflection {
+	if payload == "" || res == nil {
+		return nil
+	}
+
+	var reflections []Reflection
+	variants := encodedVariants(payload)
+	walkStrings(reflect.ValueOf(res), "", 0, func(path string, s string) {
+		if encoding, found := matchVariant(s, payload, variants); found {
+			reflections = append(reflections, Reflection{Field: path, Encoding: encoding})
+		}
+	})
+
+	return reflections
+}
+
+// a payload after it has been changed by an encoder
+type variant struct {
+	encoding string
+	value    string
+}
+
+// Returns the encoded forms of the payload that are different from the payload itself
+func encodedVariants(payload string) []variant {
+	candidates := []variant{
+		{EncodingHTML, html.EscapeString(payload)},
+		{EncodingHTML, strings.NewReplacer("<", "&lt;", ">", "&gt;").Replace(payload)},
+		{EncodingURL, url.QueryEscape(payload)},
+		{EncodingURL, url.PathEscape(payload)},
+		{EncodingUnicode, strings.NewReplacer("<", `\u003c`, ">", `\u003e`, "&", `\u0026`).Replace(payload)},
+	}
+
+	var variants []variant
+	for _, c := range candidates {
+		if c.value != payload {
+			variants = append(variants, c)
+		}
+	}
+	return variants
+}
+
+// Checks if the string contains the payload as-is or in one of its encoded forms
+func matchVariant(s string, payload string, variants []variant) (string, bool) {
+	if strings.Contains(s, payload) {
+		return EncodingNone, true
+	}
+
+	for _, v := range variants {
+		if strings.Contains(s, v.value) {
+			return v.encoding, true
+		}
+	}
+
+	if strings.Contains(strings.ToLower(s), strings.ToLower(payload)) {
+		return EncodingCase, true
+	}
+
+	return "", false
+}
+
+// Calls fn for every string value that can be reached from v
+// path is the path of v in the response and depth is the current level of nesting
+func walkStrings(v reflect.Value, path string, depth int, fn func(path string, s string)) {
+	if !v.IsValid() || depth > maxReflectionDepth {
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			walkStrings(v.Elem(), path, depth+1, fn)
+		}
+	case reflect.String:
+		fn(path, v.String())
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			// skip unexported fields and automatically-generated fields (field name starts with XXX)
+			if field.PkgPath != "" || strings.HasPrefix(field.Name, "XXX") {
+				continue
+			}
+			walkStrings(v.Field(i), joinPath(path, field.Name), depth+1, fn)
+		}
+	case reflect.Slice, reflect.Array:
+		// byte slices are checked as strings
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if v.Kind() == reflect.Slice {
+				fn(path, string(v.Bytes()))
+			}
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			walkStrings(v.Index(i), fmt.Sprintf("%s[%d]", path, i), depth+1, fn)
+		}
+	case reflect.Map:
+		// sort the keys so that reflections are always reported in the same order
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		for _, key := range keys {
+			walkStrings(v.MapIndex(key), fmt.Sprintf("%s[%v]", path, key.Interface()), depth+1, fn)
+		}
+	}
+}
+
+// Appends the name of a field to the path of its parent
+func joinPath(parent string, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
@@ -0,0 +1,56 @@
+package intruder
+
+import (
+	"html"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type demoComment struct {
+	ID     string
+	Body   string
+	Author *demoAuthor
+	Tags   []string
+	Meta   map[string]string
+	secret string
+}
+
+type demoAuthor struct {
+	Name string
+}
+
+func Test_FindReflections_ShouldReportFieldAndEncoding(t *testing.T) {
+	// Arrange
+	payload := `<script>alert("xss")</script>`
+	res := &demoComment{
+		ID:     "1",
+		Body:   "comment: " + payload,
+		Author: &demoAuthor{Name: html.EscapeString(payload)},
+		Tags:   []string{"ok", "<SCRIPT>ALERT(\"XSS\")</SCRIPT>"},
+		Meta:   map[string]string{"preview": `\u003cscript\u003ealert("xss")\u003c/script\u003e`},
+		secret: payload,
+	}
+
+	// Act
+	got := FindReflections(res, payload)
+
+	// Assert
+	assert.Equal(t, []Reflection{
+		{Field: "Body", Encoding: EncodingNone},
+		{Field: "Author.Name", Encoding: EncodingHTML},
+		{Field: "Tags[1]", Encoding: EncodingCase},
+		{Field: "Meta[preview]", Encoding: EncodingUnicode},
+	}, got)
+	assert.False(t, got[0].Encoded())
+	assert.True(t, got[1].Encoded())
+	assert.False(t, got[2].Encoded())
+}
+
+func Test_FindReflections_ShouldIgnoreResponseWithoutPayload(t *testing.T) {
+	res := &demoComment{ID: "1", Body: "hello"}
+
+	assert.Empty(t, FindReflections(res, "<img src=x>"))
+	assert.Empty(t, FindReflections(nil, "<img src=x>"))
+	assert.Empty(t, FindReflections(res, ""))
+}
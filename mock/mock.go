@@ -0,0 +1,420 @@
+package mock
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mercari/testdeck/deferrer"
+	"github.com/mercari/testdeck/recorder"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+/*
+mock.go: An in-process gRPC server that stands in for a dependency of the service under test and returns stubbed responses
+*/
+
+// Server is a mock of a gRPC service
+// Stubs are registered for its methods (usually in Arrange), and the calls it received can be checked afterwards (usually in Assert)
+type Server struct {
+	service protoreflect.ServiceDescriptor
+	server  *grpc.Server
+	addr    string
+
+	mu    sync.Mutex
+	stubs map[string][]*stubState // by method name
+	calls []*Call                 // bidirectional calls are recorded when they start and get their requests as they arrive
+	conn  *grpc.ClientConn
+}
+
+// Stub is the behavior of a method for the requests that match it
+type Stub struct {
+	Match     Matcher         // the requests the stub applies to, all requests if nil
+	Response  proto.Message   // the response of unary and client-streaming methods
+	Responses []proto.Message // the responses of server-streaming methods, and of each request of bidirectional methods (Response is sent if empty)
+	Error     error           // returned instead of the response (e.g. status.Error(codes.NotFound, "not found"))
+	Delay     time.Duration   // how long to wait before responding
+	Header    metadata.MD     // headers sent to the client
+	Trailer   metadata.MD     // trailers sent to the client
+	Times     int             // how many calls (requests of bidirectional methods) the stub applies to, unlimited if 0
+}
+
+// Call is a call received by the mock
+type Call struct {
+	Method   string          // the name of the method (e.g. "Say")
+	Request  proto.Message   // the first request, nil if the client sent none
+	Requests []proto.Message // all requests (more than one for client-streaming and bidirectional methods)
+	Metadata metadata.MD     // the metadata sent by the client
+	Time     time.Time       // when the call was received
+	Stubbed  bool            // a stub matched the call (every request of bidirectional calls)
+}
+
+type stubState struct {
+	Stub
+	used int
+}
+
+// Starts a mock of the service on a local port
+// The mock is stopped by d (e.g. the TestCase, so that it stops after the After stage); if d is nil, call Stop yourself
+func Start(d deferrer.Deferrer, service protoreflect.ServiceDescriptor) (*Server, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start the mock of %s: %s", service.FullName(), err.Error())
+	}
+
+	s := &Server{
+		service: service,
+		server:  grpc.NewServer(),
+		addr:    lis.Addr().String(),
+		stubs:   map[string][]*stubState{},
+	}
+	s.server.RegisterService(s.serviceDesc(), nil)
+	go s.server.Serve(lis)
+
+	if d != nil {
+		d.Defer(s.Stop)
+	}
+	return s, nil
+}
+
+// Starts a mock of a service whose generated code is imported by the test (e.g. "echo.EchoService")
+func StartService(d deferrer.Deferrer, name string) (*Server, error) {
+	desc, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("service %s was not found: %s", name, err.Error())
+	}
+	service, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", name)
+	}
+	return Start(d, service)
+}
+
+// Stops the mock and closes the connection returned by Conn
+func (s *Server) Stop() {
+	s.mu.Lock()
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	s.mu.Unlock()
+	s.server.Stop()
+}
+
+// Returns the address of the mock (e.g. to pass to the service under test)
+func (s *Server) Addr() string {
+	return s.addr
+}
+
+// Returns a connection to the mock, for calling it directly from the test
+// The connection records the calls of test cases (see TD.Context) and is closed when the mock stops
+func (s *Server) Conn() (*grpc.ClientConn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		conn, err := grpc.Dial(s.addr, append(recorder.DialOptions(), grpc.WithInsecure())...)
+		if err != nil {
+			return nil, err
+		}
+		s.conn = conn
+	}
+	return s.conn, nil
+}
+
+// ----------
+// stubs
+// ----------
+
+// Adds a stub to the method (e.g. "Say")
+// When several stubs match a request, the one that was added first is used
+func (s *Server) Stub(method string, stub Stub) error {
+	m := s.service.Methods().ByName(protoreflect.Name(method))
+	if m == nil {
+		return fmt.Errorf("method %s was not found in %s", method, s.service.FullName())
+	}
+
+	responses := append([]proto.Message(nil), stub.Responses...)
+	if stub.Response != nil {
+		responses = append(responses, stub.Response)
+	}
+	for _, resp := range responses {
+		if got := resp.ProtoReflect().Descriptor().FullName(); got != m.Output().FullName() {
+			return fmt.Errorf("the response of %s must be %s, not %s", method, m.Output().FullName(), got)
+		}
+	}
+	if len(responses) > 1 && !m.IsStreamingServer() {
+		return fmt.Errorf("%s returns a single response", method)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stubs[method] = append(s.stubs[method], &stubState{Stub: stub})
+	return nil
+}
+
+// Removes all stubs and calls
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stubs = map[string][]*stubState{}
+	s.calls = nil
+}
+
+// Returns the calls received by the method (e.g. "Say"), or the calls of all methods if method is empty, in the order they were received
+func (s *Server) Calls(method string) []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var calls []Call
+	for _, c := range s.calls {
+		if method == "" || c.Method == method {
+			call := *c
+			call.Requests = append([]proto.Message(nil), c.Requests...)
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Returns the number of calls received by the method (e.g. "Say")
+func (s *Server) CallCount(method string) int {
+	return len(s.Calls(method))
+}
+
+// Records the call and returns the stub that matches it, or nil if none matches
+func (s *Server) match(call Call) *Stub {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matched := s.findStub(call.Method, call.Request)
+	call.Stubbed = matched != nil
+	s.calls = append(s.calls, &call)
+	return matched
+}
+
+// Records a bidirectional call whose requests are added by matchRequest as they arrive
+func (s *Server) record(call *Call) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, call)
+}
+
+// Adds the request to the bidirectional call and returns the stub that matches it, or nil if none matches
+// req is nil if the client closed the stream without sending any request
+func (s *Server) matchRequest(call *Call, req proto.Message) *Stub {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	first := len(call.Requests) == 0
+	if req != nil {
+		call.Requests = append(call.Requests, req)
+		call.Request = call.Requests[0]
+	}
+	matched := s.findStub(call.Method, req)
+	call.Stubbed = matched != nil && (first || call.Stubbed)
+	return matched
+}
+
+// Returns the first stub of the method that matches the request and uses it once, or nil if none matches
+// s.mu must be held
+func (s *Server) findStub(method string, req proto.Message) *Stub {
+	for _, st := range s.stubs[method] {
+		if st.Times > 0 && st.used >= st.Times {
+			continue
+		}
+		if st.Match == nil || st.Match(req) {
+			st.used++
+			return &st.Stub
+		}
+	}
+	return nil
+}
+
+// ----------
+// matchers
+// ----------
+
+// Matcher reports whether a stub applies to a request
+// For client-streaming methods the first request is matched, which is nil if the client sent none
+// For bidirectional methods each request is matched as it arrives (nil if the client closed the stream without sending any)
+type Matcher func(req proto.Message) bool
+
+// Matches requests that are equal to the message
+func Equal(want proto.Message) Matcher {
+	return func(req proto.Message) bool {
+		return req != nil && proto.Equal(req, want)
+	}
+}
+
+// Matches requests that have the same values as the fields set in the message, other fields are ignored
+func Partial(want proto.Message) Matcher {
+	return func(req proto.Message) bool {
+		if req == nil {
+			return false
+		}
+		got := req.ProtoReflect()
+		if got.Descriptor().FullName() != want.ProtoReflect().Descriptor().FullName() {
+			return false
+		}
+		matched := true
+		want.ProtoReflect().Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+			matched = got.Has(fd) && equalValues(got, fd, v)
+			return matched
+		})
+		return matched
+	}
+}
+
+// Compares the value of the field in the message with v
+func equalValues(m protoreflect.Message, fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+	// copying the values into empty messages lets proto.Equal compare lists, maps and nested messages
+	x := m.New()
+	y := m.New()
+	x.Set(fd, m.Get(fd))
+	y.Set(fd, v)
+	return proto.Equal(x.Interface(), y.Interface())
+}
+
+// ----------
+// server
+// ----------
+
+// Returns a description of the service whose methods are all handled by the mock
+func (s *Server) serviceDesc() *grpc.ServiceDesc {
+	desc := &grpc.ServiceDesc{
+		ServiceName: string(s.service.FullName()),
+		HandlerType: (*interface{})(nil),
+		Metadata:    s.service.ParentFile().Path(),
+	}
+
+	methods := s.service.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		m := methods.Get(i)
+		// unary methods are handled as streams as well, which is the same on the wire
+		desc.Streams = append(desc.Streams, grpc.StreamDesc{
+			StreamName:    string(m.Name()),
+			Handler:       s.handler(m),
+			ServerStreams: m.IsStreamingServer(),
+			ClientStreams: m.IsStreamingClient(),
+		})
+	}
+	return desc
+}
+
+// Returns the handler of the method
+// Client streams are answered after the client has sent all of its requests, bidirectional streams after each request
+func (s *Server) handler(m protoreflect.MethodDescriptor) grpc.StreamHandler {
+	return func(_ interface{}, stream grpc.ServerStream) error {
+		call := Call{Method: string(m.Name()), Time: time.Now()}
+		call.Metadata, _ = metadata.FromIncomingContext(stream.Context())
+		if m.IsStreamingClient() && m.IsStreamingServer() {
+			return s.handleBidi(m, stream, &call)
+		}
+
+		for {
+			req := newMessage(m.Input())
+			err := stream.RecvMsg(req)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			call.Requests = append(call.Requests, req)
+			if !m.IsStreamingClient() {
+				break
+			}
+		}
+		if len(call.Requests) > 0 {
+			call.Request = call.Requests[0]
+		}
+
+		stub := s.match(call)
+		if stub == nil {
+			return status.Errorf(codes.Unimplemented, "mock: no stub of %s matches the request", m.FullName())
+		}
+		return respond(m, stream, stub, true)
+	}
+}
+
+// Handles a bidirectional stream: every request is matched and answered as soon as it arrives,
+// so that clients that wait for a response before sending the next request (ping-pong) do not block
+func (s *Server) handleBidi(m protoreflect.MethodDescriptor, stream grpc.ServerStream, call *Call) error {
+	s.record(call)
+	for first := true; ; first = false {
+		req := newMessage(m.Input())
+		err := stream.RecvMsg(req)
+		if err == io.EOF && !first {
+			return nil
+		}
+		if err == io.EOF {
+			// the stubs still apply to streams without requests (e.g. to return an error)
+			req = nil
+		} else if err != nil {
+			return err
+		}
+
+		stub := s.matchRequest(call, req)
+		if stub == nil {
+			return status.Errorf(codes.Unimplemented, "mock: no stub of %s matches the request", m.FullName())
+		}
+		if err := respond(m, stream, stub, first); err != nil || req == nil {
+			return err
+		}
+	}
+}
+
+// Sends the stubbed responses (or error) of a call, or of a request of a bidirectional call
+// Headers can only be sent before the first response, so they are only set if sendHeader is true
+func respond(m protoreflect.MethodDescriptor, stream grpc.ServerStream, stub *Stub, sendHeader bool) error {
+	if stub.Delay > 0 {
+		select {
+		case <-time.After(stub.Delay):
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		}
+	}
+
+	if sendHeader && len(stub.Header) > 0 {
+		if err := stream.SetHeader(stub.Header); err != nil {
+			return err
+		}
+	}
+	stream.SetTrailer(stub.Trailer)
+	if stub.Error != nil {
+		return stub.Error
+	}
+
+	responses := stub.Responses
+	if len(responses) == 0 && stub.Response != nil {
+		responses = []proto.Message{stub.Response}
+	}
+	if len(responses) == 0 && !m.IsStreamingServer() {
+		// unary methods must return a response, so an empty one is sent
+		responses = []proto.Message{newMessage(m.Output())}
+	}
+	for _, resp := range responses {
+		if err := stream.SendMsg(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns an empty message of the type, using the generated type if the test imports it
+func newMessage(desc protoreflect.MessageDescriptor) proto.Message {
+	if mt, err := protoregistry.GlobalTypes.FindMessageByName(desc.FullName()); err == nil {
+		return mt.New().Interface()
+	}
+	return dynamicpb.NewMessage(desc)
+}
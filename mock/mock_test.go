@@ -0,0 +1,212 @@
+package mock
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/mercari/testdeck/deferrer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// starts a mock of the test service that is stopped at the end of the test
+func startTestService(t *testing.T) (*Server, testpb.TestServiceClient) {
+	d := &deferrer.DefaultDeferrer{}
+	t.Cleanup(d.RunDeferred)
+
+	s, err := StartService(d, "grpc.testing.TestService")
+	require.Nil(t, err)
+	conn, err := s.Conn()
+	require.Nil(t, err)
+	return s, testpb.NewTestServiceClient(conn)
+}
+
+func Test_Server_ShouldReturnStubbedResponse(t *testing.T) {
+	// Arrange
+	s, client := startTestService(t)
+	require.Nil(t, s.Stub("UnaryCall", Stub{
+		Match:    Partial(&testpb.SimpleRequest{Payload: &testpb.Payload{Body: []byte("a")}}),
+		Response: &testpb.SimpleResponse{Username: "a"},
+		Header:   metadata.Pairs("x-request-id", "1"),
+	}))
+	require.Nil(t, s.Stub("UnaryCall", Stub{
+		Response: &testpb.SimpleResponse{Username: "other"},
+	}))
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "bearer token")
+
+	// Act
+	var header metadata.MD
+	a, errA := client.UnaryCall(ctx, &testpb.SimpleRequest{ResponseSize: 1, Payload: &testpb.Payload{Body: []byte("a")}}, grpc.Header(&header))
+	b, errB := client.UnaryCall(ctx, &testpb.SimpleRequest{Payload: &testpb.Payload{Body: []byte("b")}})
+
+	// Assert
+	require.Nil(t, errA)
+	require.Nil(t, errB)
+	assert.Equal(t, "a", a.Username)
+	assert.Equal(t, []string{"1"}, header.Get("x-request-id"))
+	assert.Equal(t, "other", b.Username)
+
+	calls := s.Calls("UnaryCall")
+	require.Len(t, calls, 2)
+	assert.True(t, calls[0].Stubbed)
+	assert.Equal(t, int32(1), calls[0].Request.(*testpb.SimpleRequest).ResponseSize)
+	assert.Equal(t, []string{"bearer token"}, calls[0].Metadata.Get("authorization"))
+	assert.Equal(t, 0, s.CallCount("EmptyCall"))
+}
+
+func Test_Server_ShouldReturnStubbedErrors(t *testing.T) {
+	// Arrange
+	s, client := startTestService(t)
+	require.Nil(t, s.Stub("UnaryCall", Stub{
+		Error: status.Error(codes.Unavailable, "try again"),
+		Times: 1,
+	}))
+	require.Nil(t, s.Stub("UnaryCall", Stub{
+		Response: &testpb.SimpleResponse{Username: "a"},
+	}))
+	require.Nil(t, s.Stub("EmptyCall", Stub{
+		Delay: time.Second,
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// Act
+	_, first := client.UnaryCall(context.Background(), &testpb.SimpleRequest{})
+	second, err := client.UnaryCall(context.Background(), &testpb.SimpleRequest{})
+	_, delayed := client.EmptyCall(ctx, &testpb.Empty{})
+
+	// Assert
+	assert.Equal(t, codes.Unavailable, status.Code(first))
+	require.Nil(t, err)
+	assert.Equal(t, "a", second.Username)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(delayed))
+}
+
+func Test_Server_ShouldHandleStreams(t *testing.T) {
+	// Arrange
+	s, client := startTestService(t)
+	require.Nil(t, s.Stub("StreamingOutputCall", Stub{
+		Responses: []proto.Message{
+			&testpb.StreamingOutputCallResponse{Payload: &testpb.Payload{Body: []byte("a")}},
+			&testpb.StreamingOutputCallResponse{Payload: &testpb.Payload{Body: []byte("b")}},
+		},
+	}))
+	require.Nil(t, s.Stub("StreamingInputCall", Stub{
+		Response: &testpb.StreamingInputCallResponse{AggregatedPayloadSize: 3},
+	}))
+
+	// Act
+	download, err := client.StreamingOutputCall(context.Background(), &testpb.StreamingOutputCallRequest{})
+	require.Nil(t, err)
+	var bodies []string
+	for {
+		resp, err := download.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.Nil(t, err)
+		bodies = append(bodies, string(resp.Payload.Body))
+	}
+
+	upload, err := client.StreamingInputCall(context.Background())
+	require.Nil(t, err)
+	upload.Send(&testpb.StreamingInputCallRequest{Payload: &testpb.Payload{Body: []byte("a")}})
+	upload.Send(&testpb.StreamingInputCallRequest{Payload: &testpb.Payload{Body: []byte("bc")}})
+	resp, err := upload.CloseAndRecv()
+
+	chat, chatErr := client.FullDuplexCall(context.Background())
+	require.Nil(t, chatErr)
+	chat.CloseSend()
+	_, chatErr = chat.Recv()
+
+	// Assert
+	assert.Equal(t, []string{"a", "b"}, bodies)
+	require.Nil(t, err)
+	assert.Equal(t, int32(3), resp.AggregatedPayloadSize)
+	require.Equal(t, 1, s.CallCount("StreamingInputCall"))
+	assert.Len(t, s.Calls("StreamingInputCall")[0].Requests, 2)
+	assert.Equal(t, codes.Unimplemented, status.Code(chatErr))
+	assert.False(t, s.Calls("FullDuplexCall")[0].Stubbed)
+	assert.Nil(t, s.Calls("FullDuplexCall")[0].Request)
+}
+
+func Test_Server_Stub_ShouldRejectInvalidStubs(t *testing.T) {
+	s, _ := startTestService(t)
+
+	assert.NotNil(t, s.Stub("Missing", Stub{}))
+	assert.NotNil(t, s.Stub("UnaryCall", Stub{Response: &testpb.Empty{}}))
+	assert.NotNil(t, s.Stub("UnaryCall", Stub{Responses: []proto.Message{&testpb.SimpleResponse{}, &testpb.SimpleResponse{}}}))
+}
+
+func Test_Start_ShouldStopWithDeferrer(t *testing.T) {
+	// Arrange
+	d := &deferrer.DefaultDeferrer{}
+	s, err := StartService(d, "grpc.testing.TestService")
+	require.Nil(t, err)
+	require.Nil(t, s.Stub("EmptyCall", Stub{}))
+	conn, err := grpc.Dial(s.Addr(), grpc.WithInsecure())
+	require.Nil(t, err)
+	defer conn.Close()
+	client := testpb.NewTestServiceClient(conn)
+	_, before := client.EmptyCall(context.Background(), &testpb.Empty{})
+
+	// Act
+	d.RunDeferred()
+	_, after := client.EmptyCall(context.Background(), &testpb.Empty{})
+
+	// Assert
+	assert.Nil(t, before)
+	assert.Equal(t, codes.Unavailable, status.Code(after))
+}
+
+func Test_StartService_ShouldFailWithUnknownService(t *testing.T) {
+	_, err := StartService(nil, "grpc.testing.Missing")
+
+	assert.NotNil(t, err)
+}
+
+func Test_Server_ShouldAnswerEachRequestOfBidirectionalStreams(t *testing.T) {
+	// Arrange
+	s, client := startTestService(t)
+	for _, body := range []string{"ping", "ping again"} {
+		require.Nil(t, s.Stub("FullDuplexCall", Stub{
+			Match:    Partial(&testpb.StreamingOutputCallRequest{Payload: &testpb.Payload{Body: []byte(body)}}),
+			Response: &testpb.StreamingOutputCallResponse{Payload: &testpb.Payload{Body: []byte("pong to " + body)}},
+			Header:   metadata.Pairs("x-request-id", body),
+		}))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	chat, err := client.FullDuplexCall(ctx)
+	require.Nil(t, err)
+
+	// Act
+	var bodies []string
+	for _, body := range []string{"ping", "ping again"} {
+		// each request is only sent after the response to the previous one was received
+		require.Nil(t, chat.Send(&testpb.StreamingOutputCallRequest{Payload: &testpb.Payload{Body: []byte(body)}}))
+		resp, err := chat.Recv()
+		require.Nil(t, err)
+		bodies = append(bodies, string(resp.Payload.Body))
+	}
+	chat.Send(&testpb.StreamingOutputCallRequest{Payload: &testpb.Payload{Body: []byte("unknown")}})
+	_, err = chat.Recv()
+	header, _ := chat.Header()
+
+	// Assert
+	assert.Equal(t, []string{"pong to ping", "pong to ping again"}, bodies)
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+	assert.Equal(t, []string{"ping"}, header.Get("x-request-id"))
+	calls := s.Calls("FullDuplexCall")
+	require.Len(t, calls, 1)
+	assert.Len(t, calls[0].Requests, 3)
+	assert.False(t, calls[0].Stubbed, "the last request did not match any stub")
+}
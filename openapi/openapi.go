@@ -0,0 +1,283 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+openapi.go: Reads OpenAPI 3 specs (JSON or YAML) and lists their operations
+*/
+
+// Spec is an OpenAPI 3 spec
+// Only the parts that are used to generate test cases are decoded, $ref values are resolved when they are used
+type Spec struct {
+	OpenAPI string              `json:"openapi"`
+	Servers []Server            `json:"servers"`
+	Paths   map[string]PathItem `json:"paths"`
+
+	raw interface{} // the whole document, for resolving $ref
+}
+
+// Server is a server that the API is served from
+type Server struct {
+	URL       string                    `json:"url"`
+	Variables map[string]ServerVariable `json:"variables"`
+}
+
+// ServerVariable is a variable in the URL of a server (e.g. {version})
+type ServerVariable struct {
+	Default string `json:"default"`
+}
+
+// PathItem contains the operations of a path
+type PathItem struct {
+	Parameters []Parameter `json:"parameters"` // parameters shared by all operations of the path
+	Get        *Operation  `json:"get"`
+	Put        *Operation  `json:"put"`
+	Post       *Operation  `json:"post"`
+	Delete     *Operation  `json:"delete"`
+	Options    *Operation  `json:"options"`
+	Head       *Operation  `json:"head"`
+	Patch      *Operation  `json:"patch"`
+}
+
+// Operation is an HTTP method of a path
+type Operation struct {
+	Method      string              `json:"-"` // e.g. GET
+	Path        string              `json:"-"` // e.g. /pets/{id}
+	OperationID string              `json:"operationId"`
+	Summary     string              `json:"summary"`
+	Parameters  []Parameter         `json:"parameters"` // the parameters of the operation, including the ones of the path
+	RequestBody *RequestBody        `json:"requestBody"`
+	Responses   map[string]Response `json:"responses"` // by status code (e.g. 200, 2XX or default)
+}
+
+// Returns the name of the operation: its operationId, or the method and the path (e.g. GET /pets/{id})
+func (o *Operation) Name() string {
+	if o.OperationID != "" {
+		return o.OperationID
+	}
+	return o.Method + " " + o.Path
+}
+
+// Parameter is a path, query, header or cookie parameter of an operation
+type Parameter struct {
+	Ref      string      `json:"$ref"`
+	Name     string      `json:"name"`
+	In       string      `json:"in"` // path, query, header or cookie
+	Required bool        `json:"required"`
+	Schema   interface{} `json:"schema"`
+	Example  interface{} `json:"example"`
+}
+
+// RequestBody is the body of a request, by media type
+type RequestBody struct {
+	Ref      string               `json:"$ref"`
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// Response is a response of an operation, by media type
+type Response struct {
+	Ref         string               `json:"$ref"`
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content"`
+}
+
+// MediaType is the schema of a body with a media type
+type MediaType struct {
+	Schema  interface{} `json:"schema"`
+	Example interface{} `json:"example"`
+}
+
+// Reads an OpenAPI 3 spec from a JSON or YAML file
+func Load(path string) (*Spec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the OpenAPI spec")
+	}
+	return Parse(data)
+}
+
+// Reads an OpenAPI 3 spec in JSON or YAML
+func Parse(data []byte) (*Spec, error) {
+	var raw interface{}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, errors.Wrap(err, "invalid OpenAPI spec")
+		}
+	} else {
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, errors.Wrap(err, "invalid OpenAPI spec")
+		}
+		// numbers are decoded as float64 like in JSON specs
+		var normalized interface{}
+		if err := decode(fromYAML(raw), &normalized); err != nil {
+			return nil, errors.Wrap(err, "invalid OpenAPI spec")
+		}
+		raw = normalized
+	}
+
+	spec := &Spec{raw: raw}
+	if err := decode(raw, spec); err != nil {
+		return nil, errors.Wrap(err, "invalid OpenAPI spec")
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		return nil, errors.Errorf("unsupported OpenAPI version %q, only OpenAPI 3 specs are supported", spec.OpenAPI)
+	}
+	return spec, nil
+}
+
+// Returns every operation of the spec, sorted by path and method
+// The parameters of the path are added to the parameters of each operation, and $ref values of parameters, request bodies and responses are resolved
+func (s *Spec) Operations() ([]*Operation, error) {
+	var paths []string
+	for path := range s.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var operations []*Operation
+	for _, path := range paths {
+		item := s.Paths[path]
+		methods := []struct {
+			method    string
+			operation *Operation
+		}{
+			{http.MethodGet, item.Get},
+			{http.MethodPut, item.Put},
+			{http.MethodPost, item.Post},
+			{http.MethodDelete, item.Delete},
+			{http.MethodOptions, item.Options},
+			{http.MethodHead, item.Head},
+			{http.MethodPatch, item.Patch},
+		}
+
+		for _, m := range methods {
+			if m.operation == nil {
+				continue
+			}
+			o, err := s.resolveOperation(*m.operation, item.Parameters)
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s %s", m.method, path)
+			}
+			o.Method = m.method
+			o.Path = path
+			operations = append(operations, o)
+		}
+	}
+	return operations, nil
+}
+
+// Returns a copy of the operation with the $ref values resolved and the parameters of the path added
+func (s *Spec) resolveOperation(o Operation, pathParameters []Parameter) (*Operation, error) {
+	var parameters []Parameter
+	seen := map[string]bool{}
+	// parameters of the operation override the parameters of the path with the same name and location
+	for _, p := range append(append([]Parameter(nil), o.Parameters...), pathParameters...) {
+		if p.Ref != "" {
+			if err := s.resolveRef(p.Ref, &p); err != nil {
+				return nil, err
+			}
+		}
+		key := p.In + ":" + p.Name
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		parameters = append(parameters, p)
+	}
+	o.Parameters = parameters
+
+	if o.RequestBody != nil && o.RequestBody.Ref != "" {
+		body := &RequestBody{}
+		if err := s.resolveRef(o.RequestBody.Ref, body); err != nil {
+			return nil, err
+		}
+		o.RequestBody = body
+	}
+
+	responses := map[string]Response{}
+	for code, r := range o.Responses {
+		if r.Ref != "" {
+			if err := s.resolveRef(r.Ref, &r); err != nil {
+				return nil, err
+			}
+		}
+		responses[strings.ToUpper(code)] = r
+	}
+	o.Responses = responses
+	return &o, nil
+}
+
+// Decodes the value that the $ref points to into out
+func (s *Spec) resolveRef(ref string, out interface{}) error {
+	v, err := s.lookup(ref)
+	if err != nil {
+		return err
+	}
+	return decode(v, out)
+}
+
+// Returns the value that a local $ref (e.g. #/components/schemas/Pet) points to
+func (s *Spec) lookup(ref string) (interface{}, error) {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, errors.Errorf("unsupported $ref %q, only references within the spec are supported", ref)
+	}
+
+	v := s.raw
+	for _, token := range strings.Split(ref[2:], "/") {
+		token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("$ref %q does not exist", ref)
+		}
+		if v, ok = m[token]; !ok {
+			return nil, errors.Errorf("$ref %q does not exist", ref)
+		}
+	}
+	return v, nil
+}
+
+// Decodes a value that was decoded into an interface{} into out
+func decode(v interface{}, out interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
+
+// Converts the maps decoded by yaml.v3 to map[string]interface{}, so the document can be handled as JSON
+// Keys that are not strings (e.g. status codes such as 200) are converted to strings
+func fromYAML(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = fromYAML(value)
+		}
+		return v
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = fromYAML(value)
+		}
+		return m
+	case []interface{}:
+		for i, value := range v {
+			v[i] = fromYAML(value)
+		}
+		return v
+	default:
+		return v
+	}
+}
@@ -0,0 +1,186 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/mercari/testdeck/httputils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadPetstore(t *testing.T) *Spec {
+	spec, err := Load("testdata/petstore.yaml")
+	require.Nil(t, err)
+	return spec
+}
+
+func Test_Parse_ShouldReadJSONAndYAML(t *testing.T) {
+	// Arrange
+	yamlSpec := loadPetstore(t)
+	jsonData, err := json.Marshal(yamlSpec.raw)
+	require.Nil(t, err)
+
+	// Act
+	jsonSpec, err := Parse(jsonData)
+
+	// Assert
+	require.Nil(t, err)
+	assert.Equal(t, yamlSpec, jsonSpec)
+	assert.Equal(t, "3.0.3", jsonSpec.OpenAPI)
+}
+
+func Test_Parse_ShouldRejectOtherVersions(t *testing.T) {
+	_, err := Parse([]byte(`{"swagger": "2.0", "paths": {}}`))
+
+	assert.NotNil(t, err)
+}
+
+func Test_Operations_ShouldResolveReferences(t *testing.T) {
+	// Arrange
+	spec := loadPetstore(t)
+
+	// Act
+	operations, err := spec.Operations()
+
+	// Assert
+	require.Nil(t, err)
+	var names []string
+	for _, o := range operations {
+		names = append(names, o.Name())
+	}
+	assert.Equal(t, []string{"listPets", "createPet", "getPet", "DELETE /pets/{id}", "uploadPhoto"}, names)
+
+	listPets := operations[0]
+	require.Len(t, listPets.Parameters, 2)
+	assert.Equal(t, "limit", listPets.Parameters[0].Name)
+	assert.Equal(t, "query", listPets.Parameters[0].In)
+
+	createPet := operations[1]
+	assert.Equal(t, http.MethodPost, createPet.Method)
+	require.NotNil(t, createPet.RequestBody)
+	assert.True(t, createPet.RequestBody.Required)
+	assert.Equal(t, "a pet", createPet.Responses["201"].Description)
+	assert.Contains(t, createPet.Responses, "DEFAULT")
+
+	getPet := operations[2]
+	require.Len(t, getPet.Parameters, 1, "the parameters of the path should be added")
+	assert.Equal(t, "id", getPet.Parameters[0].Name)
+	assert.Contains(t, getPet.Responses, "2XX")
+}
+
+func Test_Operations_ShouldFailForMissingReferences(t *testing.T) {
+	// Arrange
+	spec, err := Parse([]byte(`{"openapi": "3.0.0", "paths": {"/pets": {"get": {"parameters": [{"$ref": "#/components/parameters/Missing"}]}}}}`))
+	require.Nil(t, err)
+
+	// Act
+	_, err = spec.Operations()
+
+	// Assert
+	assert.NotNil(t, err)
+}
+
+func Test_Sample_ShouldMatchSchema(t *testing.T) {
+	spec := loadPetstore(t)
+	cases := map[string]string{
+		"enum":          `{"type": "string", "enum": ["a", "b"]}`,
+		"min length":    `{"type": "string", "minLength": 10}`,
+		"format":        `{"type": "string", "format": "email"}`,
+		"integer range": `{"type": "integer", "minimum": 5, "maximum": 10, "multipleOf": 5}`,
+		"exclusive":     `{"type": "number", "minimum": 0, "exclusiveMinimum": true}`,
+		"array":         `{"type": "array", "items": {"type": "boolean"}, "minItems": 2}`,
+		"all of":        `{"allOf": [{"$ref": "#/components/schemas/Error"}, {"type": "object", "properties": {"code": {"type": "integer"}}, "required": ["code"]}]}`,
+		"one of":        `{"oneOf": [{"type": "integer"}, {"type": "string"}]}`,
+		"nullable":      `{"type": "string", "nullable": true}`,
+		"recursive":     `{"$ref": "#/components/schemas/Pet"}`,
+	}
+
+	for name, schema := range cases {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			var raw interface{}
+			require.Nil(t, json.Unmarshal([]byte(schema), &raw))
+			resolved, err := spec.ResolveSchema(raw)
+			require.Nil(t, err)
+			b, err := json.Marshal(resolved)
+			require.Nil(t, err)
+
+			// Act
+			sample := Sample(resolved)
+
+			// Assert
+			assert.Nil(t, httputils.ValidateJSONValue(b, sample), sample)
+		})
+	}
+}
+
+func Test_SampleRequest_ShouldSetEveryParameter(t *testing.T) {
+	// Arrange
+	spec := loadPetstore(t)
+	operations, err := spec.Operations()
+	require.Nil(t, err)
+
+	// Act
+	listPets, err := spec.SampleRequest(operations[0], "http://localhost/v1/", nil)
+	require.Nil(t, err)
+	createPet, err := spec.SampleRequest(operations[1], "http://localhost/v1", map[string]interface{}{"name": "Tom"})
+	require.Nil(t, err)
+	getPet, err := spec.SampleRequest(operations[2], "http://localhost/v1", map[string]interface{}{"id": 42})
+	require.Nil(t, err)
+
+	// Assert
+	assert.Equal(t, http.MethodGet, listPets.Method)
+	assert.Equal(t, "http://localhost/v1/pets", listPets.URL)
+	assert.Equal(t, []string{"2"}, listPets.Query["limit"])
+	assert.Equal(t, []string{"dog"}, listPets.Query["tag"])
+
+	assert.Equal(t, "00000000-0000-4000-8000-000000000000", createPet.Headers["X-Request-Id"])
+	assert.Equal(t, "Tom", createPet.JSON["name"], "JSON should be preferred over forms and values should be used")
+	assert.NotContains(t, createPet.JSON, "id", "read only properties should not be sent")
+	assert.Nil(t, createPet.Form)
+
+	assert.Equal(t, "http://localhost/v1/pets/42", getPet.URL)
+}
+
+func Test_SampleRequest_ShouldNotChangeExampleOfSpec(t *testing.T) {
+	// Arrange
+	spec, err := Parse([]byte(`{"openapi": "3.0.3", "paths": {"/pets": {"post": {"requestBody": {"content": {"application/json": {
+		"schema": {"type": "object"},
+		"example": {"name": "Rex", "owner": {"name": "Sam"}}
+	}}}, "responses": {"201": {"description": "created"}}}}}}`))
+	require.Nil(t, err)
+	operations, err := spec.Operations()
+	require.Nil(t, err)
+
+	// Act
+	first, err := spec.SampleRequest(operations[0], "http://localhost", map[string]interface{}{"name": "Tom"})
+	require.Nil(t, err)
+	first.JSON["owner"].(map[string]interface{})["name"] = "Bob"
+	second, err := spec.SampleRequest(operations[0], "http://localhost", nil)
+	require.Nil(t, err)
+
+	// Assert
+	assert.Equal(t, "Tom", first.JSON["name"])
+	assert.Equal(t, map[string]interface{}{"name": "Rex", "owner": map[string]interface{}{"name": "Sam"}}, second.JSON, "values of earlier requests should not leak into later ones")
+}
+
+func Test_SampleRequest_ShouldSendBinaryPropertiesAsFiles(t *testing.T) {
+	// Arrange
+	spec := loadPetstore(t)
+	operations, err := spec.Operations()
+	require.Nil(t, err)
+
+	// Act
+	req, err := spec.SampleRequest(operations[4], "http://localhost/v1", nil)
+
+	// Assert
+	require.Nil(t, err)
+	assert.Equal(t, "http://localhost/v1/pets/1/photo", req.URL)
+	body, contentType, err := httputils.CreateMultipartBody(req.Multipart)
+	require.Nil(t, err)
+	assert.Contains(t, contentType, "multipart/form-data")
+	assert.Contains(t, body.String(), `name="caption"`+"\r\n\r\nsam\r\n")
+	assert.Contains(t, body.String(), `name="photo"; filename="photo"`)
+}
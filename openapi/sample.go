@@ -0,0 +1,530 @@
+package openapi
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/mercari/testdeck/httputils"
+	"github.com/mercari/testdeck/intruder"
+	"github.com/pkg/errors"
+)
+
+/*
+sample.go: Generates schema-valid sample values and sample requests for the operations of a spec
+*/
+
+// ----------
+// schemas
+// ----------
+
+// Returns the schema with every $ref replaced by the schema it points to, in the JSON Schema dialect of httputils.ValidateJSONValue
+// OpenAPI 3.0 keywords are converted (nullable, and exclusiveMinimum and exclusiveMaximum as booleans)
+// Recursive schemas are only expanded once, deeper levels match any value
+func (s *Spec) ResolveSchema(schema interface{}) (interface{}, error) {
+	return s.resolveSchema(schema, map[string]bool{})
+}
+
+func (s *Spec) resolveSchema(schema interface{}, refs map[string]bool) (interface{}, error) {
+	switch v := schema.(type) {
+	case map[string]interface{}:
+		if ref, ok := v["$ref"].(string); ok {
+			if refs[ref] {
+				return map[string]interface{}{}, nil
+			}
+			target, err := s.lookup(ref)
+			if err != nil {
+				return nil, err
+			}
+
+			nested := map[string]bool{ref: true}
+			for r := range refs {
+				nested[r] = true
+			}
+			return s.resolveSchema(target, nested)
+		}
+
+		resolved := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			if key == "example" || key == "default" || key == "enum" || key == "const" {
+				// values are not schemas
+				resolved[key] = value
+				continue
+			}
+			r, err := s.resolveSchema(value, refs)
+			if err != nil {
+				return nil, err
+			}
+			resolved[key] = r
+		}
+		convertOpenAPIKeywords(resolved)
+		return resolved, nil
+	case []interface{}:
+		resolved := make([]interface{}, len(v))
+		for i, value := range v {
+			r, err := s.resolveSchema(value, refs)
+			if err != nil {
+				return nil, err
+			}
+			resolved[i] = r
+		}
+		return resolved, nil
+	default:
+		return v, nil
+	}
+}
+
+// Converts the OpenAPI 3.0 keywords of a schema to JSON Schema
+func convertOpenAPIKeywords(s map[string]interface{}) {
+	if nullable, _ := s["nullable"].(bool); nullable {
+		if t, ok := s["type"].(string); ok {
+			s["type"] = []interface{}{t, "null"}
+		}
+		if enum, ok := s["enum"].([]interface{}); ok {
+			s["enum"] = append(append([]interface{}(nil), enum...), nil)
+		}
+	}
+	delete(s, "nullable")
+
+	for exclusive, limit := range map[string]string{"exclusiveMinimum": "minimum", "exclusiveMaximum": "maximum"} {
+		if b, ok := s[exclusive].(bool); ok {
+			delete(s, exclusive)
+			if b {
+				s[exclusive] = s[limit]
+				delete(s, limit)
+			}
+		}
+	}
+}
+
+// ----------
+// sample values
+// ----------
+
+// Returns a value that matches the schema, which must have been resolved with ResolveSchema
+// The example, default, const or first enum value of the schema is used if it has one, otherwise a value is made from the type and the constraints
+// Patterns are not supported, so schemas with a pattern should have an example
+func Sample(schema interface{}) interface{} {
+	s, ok := schema.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	for _, key := range []string{"example", "default", "const"} {
+		if v, ok := s[key]; ok {
+			return v
+		}
+	}
+	if enum, ok := s["enum"].([]interface{}); ok && len(enum) > 0 {
+		return enum[0]
+	}
+
+	if allOf, ok := s["allOf"].([]interface{}); ok && len(allOf) > 0 {
+		// the samples of the schemas are merged, so that objects have the properties of all of them
+		merged := map[string]interface{}{}
+		for _, sub := range allOf {
+			object, ok := Sample(sub).(map[string]interface{})
+			if !ok {
+				return Sample(allOf[0])
+			}
+			for key, value := range object {
+				merged[key] = value
+			}
+		}
+		if _, hasType := s["type"]; !hasType {
+			return merged
+		}
+		if object, ok := sampleOfType(s).(map[string]interface{}); ok {
+			for key, value := range object {
+				merged[key] = value
+			}
+		}
+		return merged
+	}
+	for _, key := range []string{"oneOf", "anyOf"} {
+		if options, ok := s[key].([]interface{}); ok && len(options) > 0 {
+			return Sample(options[0])
+		}
+	}
+
+	return sampleOfType(s)
+}
+
+// Returns a sample value of the type of the schema
+func sampleOfType(s map[string]interface{}) interface{} {
+	switch schemaType(s) {
+	case "object":
+		properties, _ := s["properties"].(map[string]interface{})
+		object := make(map[string]interface{}, len(properties))
+		for name, property := range properties {
+			if readOnly, _ := property.(map[string]interface{})["readOnly"].(bool); readOnly {
+				continue
+			}
+			object[name] = Sample(property)
+		}
+		return object
+	case "array":
+		n := 1
+		if min, ok := s["minItems"].(float64); ok && int(min) > n {
+			n = int(min)
+		}
+		if max, ok := s["maxItems"].(float64); ok && int(max) < n {
+			n = int(max)
+		}
+		array := make([]interface{}, n)
+		for i := range array {
+			array[i] = Sample(s["items"])
+		}
+		return array
+	case "string":
+		return sampleString(s)
+	case "integer":
+		return sampleNumber(s, true)
+	case "number":
+		return sampleNumber(s, false)
+	case "boolean":
+		return true
+	default:
+		return nil
+	}
+}
+
+// Returns the type of the schema (the first type that is not null), or a type guessed from its keywords
+func schemaType(s map[string]interface{}) string {
+	switch t := s["type"].(type) {
+	case string:
+		return t
+	case []interface{}:
+		for _, item := range t {
+			if name, _ := item.(string); name != "null" {
+				return name
+			}
+		}
+		return "null"
+	}
+
+	switch {
+	case s["properties"] != nil:
+		return "object"
+	case s["items"] != nil:
+		return "array"
+	default:
+		return "string"
+	}
+}
+
+// Sample strings of the formats of OpenAPI and JSON Schema
+var formatSamples = map[string]string{
+	"date-time": "2020-01-01T00:00:00Z",
+	"date":      "2020-01-01",
+	"time":      "00:00:00Z",
+	"email":     "test@example.com",
+	"uuid":      "00000000-0000-4000-8000-000000000000",
+	"uri":       "https://example.com",
+	"url":       "https://example.com",
+	"hostname":  "example.com",
+	"ipv4":      "127.0.0.1",
+	"ipv6":      "::1",
+	"byte":      base64.StdEncoding.EncodeToString([]byte("sample")),
+}
+
+func sampleString(s map[string]interface{}) string {
+	format, _ := s["format"].(string)
+	value, ok := formatSamples[format]
+	if !ok {
+		value = "sample"
+	}
+
+	if min, ok := s["minLength"].(float64); ok && len(value) < int(min) {
+		value += strings.Repeat("x", int(min)-len(value))
+	}
+	if max, ok := s["maxLength"].(float64); ok && len(value) > int(max) {
+		value = value[:int(max)]
+	}
+	return value
+}
+
+func sampleNumber(s map[string]interface{}, integer bool) float64 {
+	step := 0.5
+	if integer {
+		step = 1
+	}
+
+	value := 1.0
+	if min, ok := s["minimum"].(float64); ok {
+		value = min
+	} else if min, ok := s["exclusiveMinimum"].(float64); ok {
+		value = min + step
+	} else if max, ok := s["maximum"].(float64); ok && value > max {
+		value = max
+	} else if max, ok := s["exclusiveMaximum"].(float64); ok && value >= max {
+		value = max - step
+	}
+
+	if m, ok := s["multipleOf"].(float64); ok && m > 0 {
+		value = math.Ceil(value/m) * m
+	}
+	if integer {
+		value = math.Ceil(value)
+	}
+	return value
+}
+
+// ----------
+// sample requests
+// ----------
+
+// Returns a schema-valid sample request of the operation
+// baseURL is the URL that the path of the operation is relative to (e.g. https://example.com/v1)
+// values are used instead of samples for the parameters and the top level body fields with the same names (e.g. the id of an existing resource)
+// Every parameter of the operation is set, and the body is sent as JSON, a form or a multipart form, whichever is documented first in that order
+func (s *Spec) SampleRequest(o *Operation, baseURL string, values map[string]interface{}) (intruder.HTTPRequest, error) {
+	req := intruder.HTTPRequest{Method: o.Method}
+	path := o.Path
+
+	for _, p := range o.Parameters {
+		value, ok := values[p.Name]
+		if !ok {
+			value = p.Example
+		}
+		if value == nil {
+			schema, err := s.ResolveSchema(p.Schema)
+			if err != nil {
+				return req, errors.Wrapf(err, "parameter %s", p.Name)
+			}
+			value = Sample(schema)
+		}
+		strs := parameterStrings(value)
+
+		switch p.In {
+		case "path":
+			path = strings.Replace(path, "{"+p.Name+"}", url.PathEscape(strings.Join(strs, ",")), -1)
+		case "query":
+			if req.Query == nil {
+				req.Query = url.Values{}
+			}
+			req.Query[p.Name] = strs
+		case "header":
+			if req.Headers == nil {
+				req.Headers = map[string]string{}
+			}
+			req.Headers[p.Name] = strings.Join(strs, ",")
+		case "cookie":
+			if req.Cookies == nil {
+				req.Cookies = map[string]string{}
+			}
+			req.Cookies[p.Name] = strings.Join(strs, ",")
+		default:
+			return req, errors.Errorf("parameter %s has an unknown location %q", p.Name, p.In)
+		}
+	}
+	req.URL = strings.TrimSuffix(baseURL, "/") + path
+
+	if o.RequestBody == nil || len(o.RequestBody.Content) == 0 {
+		return req, nil
+	}
+	mediaType, content := requestMediaType(o.RequestBody.Content)
+	if mediaType == "" {
+		return req, errors.Errorf("the request body has no supported media type (JSON, form or multipart)")
+	}
+
+	schema, err := s.ResolveSchema(content.Schema)
+	if err != nil {
+		return req, errors.Wrap(err, "request body")
+	}
+	body := content.Example
+	if body == nil {
+		body = Sample(schema)
+	}
+	// the example is part of the spec, so it is copied before the values are set
+	object, ok := copyJSON(body).(map[string]interface{})
+	if !ok {
+		return req, errors.Errorf("the request body is %T, only objects are supported", body)
+	}
+	for name, value := range values {
+		if _, ok := object[name]; ok {
+			object[name] = value
+		}
+	}
+
+	switch mediaType {
+	case "multipart/form-data":
+		req.Multipart, err = multipartStruct(schema, object)
+		if err != nil {
+			return req, errors.Wrap(err, "request body")
+		}
+	case "application/x-www-form-urlencoded":
+		req.Form = url.Values{}
+		for name, value := range object {
+			req.Form[name] = parameterStrings(value)
+		}
+	default:
+		req.JSON = object
+		if mediaType != "application/json" {
+			req.Headers = setHeader(req.Headers, "Content-Type", mediaType)
+		}
+	}
+	return req, nil
+}
+
+// Returns the first media type of the request body that can be sent: JSON (including +json types), a form or a multipart form
+func requestMediaType(content map[string]MediaType) (string, MediaType) {
+	var types []string
+	for t := range content {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	for _, supported := range []func(string) bool{
+		isJSON,
+		func(t string) bool { return t == "application/x-www-form-urlencoded" },
+		func(t string) bool { return t == "multipart/form-data" },
+	} {
+		for _, t := range types {
+			if supported(t) {
+				return t, content[t]
+			}
+		}
+	}
+	return "", MediaType{}
+}
+
+// Returns true for JSON media types (e.g. application/json or application/problem+json)
+func isJSON(mediaType string) bool {
+	mediaType = strings.TrimSpace(strings.Split(mediaType, ";")[0])
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// Returns the value of a parameter as strings, with one string per element of an array
+func parameterStrings(value interface{}) []string {
+	if array, ok := value.([]interface{}); ok {
+		strs := make([]string, len(array))
+		for i, v := range array {
+			strs[i] = fmt.Sprint(v)
+		}
+		return strs
+	}
+	return []string{fmt.Sprint(value)}
+}
+
+// Returns a deep copy of the objects and arrays of a JSON value, so that it can be changed without changing the spec
+func copyJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			c[key] = copyJSON(value)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, value := range v {
+			c[i] = copyJSON(value)
+		}
+		return c
+	}
+	return v
+}
+
+func setHeader(headers map[string]string, key, value string) map[string]string {
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	headers[key] = value
+	return headers
+}
+
+// Returns a pointer to a struct with multipart tags (see httputils.CreateMultipartBody) for a multipart body
+// Binary strings are sent as files, objects as JSON parts and the other properties as fields
+func multipartStruct(schema interface{}, object map[string]interface{}) (interface{}, error) {
+	properties, _ := schema.(map[string]interface{})["properties"].(map[string]interface{})
+
+	var names []string
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var fields []reflect.StructField
+	var values []reflect.Value
+	used := map[string]bool{}
+	for _, name := range names {
+		property, _ := properties[name].(map[string]interface{})
+		value := object[name]
+
+		var tag string
+		var v reflect.Value
+		switch value := value.(type) {
+		case map[string]interface{}:
+			tag, v = "json", reflect.ValueOf(&value).Elem()
+		case []interface{}:
+			tag, v = "field", reflect.ValueOf(parameterStrings(value))
+		case string:
+			if format, _ := property["format"].(string); format == "binary" {
+				tag, v = "file", reflect.ValueOf([]byte(value))
+			} else {
+				tag, v = "field", reflect.ValueOf(value)
+			}
+		case float64:
+			if schemaType(property) == "integer" {
+				tag, v = "field", reflect.ValueOf(int(value))
+			} else {
+				tag, v = "field", reflect.ValueOf(value)
+			}
+		case bool:
+			tag, v = "field", reflect.ValueOf(value)
+		case nil:
+			continue
+		default:
+			return nil, errors.Errorf("property %s has an unsupported type %T", name, value)
+		}
+
+		fields = append(fields, reflect.StructField{
+			Name: exportedName(name, used),
+			Type: v.Type(),
+			Tag:  reflect.StructTag(fmt.Sprintf(`json:%q multipart:%q`, name, tag)),
+		})
+		values = append(values, v)
+	}
+
+	s := reflect.New(reflect.StructOf(fields))
+	for i, v := range values {
+		s.Elem().Field(i).Set(v)
+	}
+	if _, _, err := httputils.CreateMultipartBody(s.Interface()); err != nil {
+		return nil, err
+	}
+	return s.Interface(), nil
+}
+
+// Returns a unique exported Go name for a property (e.g. pet_name -> Pet_name)
+func exportedName(name string, used map[string]bool) string {
+	var b strings.Builder
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	runes := []rune(b.String())
+	if len(runes) == 0 || !unicode.IsUpper(runes[0]) {
+		if len(runes) > 0 && unicode.IsLower(runes[0]) {
+			runes[0] = unicode.ToUpper(runes[0])
+		} else {
+			runes = append([]rune("F"), runes...)
+		}
+	}
+
+	exported := string(runes)
+	for i := 2; used[exported]; i++ {
+		exported = fmt.Sprintf("%s%d", string(runes), i)
+	}
+	used[exported] = true
+	return exported
+}
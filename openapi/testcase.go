@@ -0,0 +1,294 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mercari/testdeck"
+	"github.com/mercari/testdeck/fuzzer"
+	"github.com/mercari/testdeck/httputils"
+	"github.com/mercari/testdeck/intruder"
+	"github.com/pkg/errors"
+)
+
+/*
+testcase.go: Generates testdeck test cases for every operation of an OpenAPI spec
+*/
+
+// Represents configurable options for generating test cases
+type Options struct {
+	BaseURL    string                 // the URL that the paths are relative to (the URL of the first server of the spec if empty)
+	Client     *httputils.Client      // the client that sends the requests (the HTTP client of the test case if nil)
+	Headers    map[string]string      // headers sent with every request that are not parameters of the operations (e.g. Authorization)
+	Parameters map[string]interface{} // values used instead of samples for the parameters and body fields with the same names (e.g. the id of an existing resource)
+
+	Fuzz            *fuzzer.FuzzOptions               // fuzzes every parameter of every operation if set
+	Intruder        *intruder.InputValidationTestData // runs the intruder on every parameter of every operation if set (Run only, TestCases returns an error)
+	IntruderOptions intruder.IntruderOptions          // configurations for the intruder
+}
+
+// TestCase is a generated test case for an operation
+type TestCase struct {
+	Name      string               // the name of the operation, which is used as the name of the test
+	Operation *Operation           // the operation that is tested
+	Request   intruder.HTTPRequest // the sample request that is sent (and that the fuzzer and the intruder start from)
+	testdeck.TestCase
+}
+
+// Returns a test case for every operation of the spec, in the order of Operations
+// Each test case sends a schema-valid sample request and checks that the status code is a documented success code
+// and that the response body matches the schema of its status code
+// If opts.Fuzz is set, there is also a test case that fuzzes every parameter of the request (named <operation>/fuzz)
+// Operations that a sample request cannot be made for are returned as test cases that fail with the reason
+// The intruder needs a *testing.T to generate its own test cases, so opts.Intruder can only be used with Run
+func (s *Spec) TestCases(opts ...Options) ([]TestCase, error) {
+	var o Options
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	if o.Intruder != nil {
+		return nil, errors.New("Options.Intruder is only supported by Run, use intruder.RunHTTPIntruderTests on the Request of the test cases instead")
+	}
+
+	return s.testCases(o)
+}
+
+// Same as TestCases but opts.Intruder is ignored, because Run attacks the requests itself
+func (s *Spec) testCases(o Options) ([]TestCase, error) {
+	baseURL, err := s.baseURL(o.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	operations, err := s.Operations()
+	if err != nil {
+		return nil, err
+	}
+
+	// the headers are set on the client rather than on the requests, so that they are not fuzzed
+	client := o.Client
+	if len(o.Headers) > 0 {
+		if client == nil {
+			client = httputils.DefaultClient
+		}
+		client = client.Clone()
+		for key, value := range o.Headers {
+			client.SetHeader(key, value)
+		}
+	}
+
+	var cases []TestCase
+	for _, op := range operations {
+		req, err := s.SampleRequest(op, baseURL, o.Parameters)
+		if err != nil {
+			cases = append(cases, failedTestCase(op, err))
+			continue
+		}
+		req.Client = client
+
+		cases = append(cases, s.operationTestCase(op, req))
+		if o.Fuzz != nil {
+			cases = append(cases, fuzzTestCase(op, req, *o.Fuzz))
+		}
+	}
+	return cases, nil
+}
+
+// Generates the test cases of the spec and runs them as subtests of t
+// If opts.Intruder is set, the intruder is also run on every parameter of every operation (in subtests named <operation>/intruder)
+func (s *Spec) Run(t *testing.T, opts ...Options) {
+	var o Options
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+
+	cases, err := s.testCases(o)
+	if err != nil {
+		t.Fatalf("Failed to generate test cases from the OpenAPI spec: %s", err.Error())
+	}
+
+	for i := range cases {
+		cases[i].Run(t, cases[i].Name)
+	}
+
+	if o.Intruder == nil {
+		return
+	}
+	for _, tc := range cases {
+		// operations without a sample request are skipped, and each operation is only attacked once
+		if tc.Request.Method == "" || tc.Name != tc.Operation.Name() {
+			continue
+		}
+		tc := tc
+		t.Run(tc.Name+"/intruder", func(t *testing.T) {
+			intruder.RunHTTPIntruderTests(t, testdeck.TestCase{}, tc.Request, *o.Intruder, o.IntruderOptions)
+		})
+	}
+}
+
+// Returns the base URL of the requests: the URL in the options, or the URL of the first server with the default values of its variables
+func (s *Spec) baseURL(override string) (string, error) {
+	baseURL := override
+	if baseURL == "" && len(s.Servers) > 0 {
+		baseURL = s.Servers[0].URL
+		for name, variable := range s.Servers[0].Variables {
+			baseURL = strings.Replace(baseURL, "{"+name+"}", variable.Default, -1)
+		}
+	}
+
+	u, err := url.Parse(baseURL)
+	if err != nil || !u.IsAbs() {
+		return "", errors.Errorf("the base URL %q is not an absolute URL, set Options.BaseURL", baseURL)
+	}
+	return baseURL, nil
+}
+
+// ----------
+// test cases
+// ----------
+
+// Returns the test case that sends the sample request and checks the response
+func (s *Spec) operationTestCase(op *Operation, req intruder.HTTPRequest) TestCase {
+	var res *intruder.HTTPResponse
+	var err error
+
+	tc := TestCase{Name: op.Name(), Operation: op, Request: req}
+
+	// Act
+	tc.Act = func(t *testdeck.TD) {
+		r := req
+		if r.Client == nil {
+			r.Client = t.HTTPClient()
+		}
+		res, err = r.Send()
+	}
+
+	// Assert
+	tc.Assert = func(t *testdeck.TD) {
+		if res == nil {
+			t.Errorf("%s %s: failed to send the request: %s", req.Method, req.URL, err.Error())
+			return
+		}
+		if !successStatus(op, res.StatusCode) {
+			t.Errorf("%s %s: unexpected status code %d (documented: %s): %v", req.Method, req.URL, res.StatusCode, documentedStatuses(op), res.Body)
+			return
+		}
+		if err := s.ValidateResponse(op, res); err != nil {
+			t.Errorf("%s %s: %s", req.Method, req.URL, err.Error())
+		}
+	}
+
+	return tc
+}
+
+// Returns the test case that fuzzes every parameter of the sample request
+func fuzzTestCase(op *Operation, req intruder.HTTPRequest, o fuzzer.FuzzOptions) TestCase {
+	tc := TestCase{Name: op.Name() + "/fuzz", Operation: op, Request: req}
+
+	// Act
+	tc.Act = func(t *testdeck.TD) {
+		fuzzer.FuzzHTTPEndpoint(t, req, o)
+	}
+
+	return tc
+}
+
+// Returns a test case that fails because the test case of the operation could not be generated
+func failedTestCase(op *Operation, err error) TestCase {
+	tc := TestCase{Name: op.Name(), Operation: op}
+
+	// Arrange
+	tc.Arrange = func(t *testdeck.TD) {
+		t.Errorf("%s %s: failed to make a sample request: %s", op.Method, op.Path, err.Error())
+	}
+
+	return tc
+}
+
+// ----------
+// responses
+// ----------
+
+// Checks that the body of the response matches the JSON schema that the spec documents for its status code
+// Responses without a documented JSON schema (and responses to HEAD requests) are not checked
+func (s *Spec) ValidateResponse(op *Operation, res *intruder.HTTPResponse) error {
+	if op.Method == http.MethodHead {
+		return nil
+	}
+	documented, ok := responseFor(op, res.StatusCode)
+	if !ok {
+		return nil
+	}
+
+	var mediaTypes []string
+	for mediaType := range documented.Content {
+		if isJSON(mediaType) && documented.Content[mediaType].Schema != nil {
+			mediaTypes = append(mediaTypes, mediaType)
+		}
+	}
+	if len(mediaTypes) == 0 {
+		return nil
+	}
+	sort.Strings(mediaTypes)
+
+	schema, err := s.ResolveSchema(documented.Content[mediaTypes[0]].Schema)
+	if err != nil {
+		return errors.Wrap(err, "invalid response schema")
+	}
+	b, err := json.Marshal(schema)
+	if err != nil {
+		return errors.Wrap(err, "invalid response schema")
+	}
+	if err := httputils.ValidateJSONValue(b, res.Body); err != nil {
+		return errors.Wrapf(err, "the response to status code %d does not match its schema", res.StatusCode)
+	}
+	return nil
+}
+
+// Returns the response that the spec documents for the status code: the response of the code, of its range (e.g. 2XX) or the default response
+func responseFor(op *Operation, code int) (Response, bool) {
+	status := strconv.Itoa(code)
+	for _, key := range []string{status, status[:1] + "XX", "DEFAULT"} {
+		if r, ok := op.Responses[key]; ok {
+			return r, true
+		}
+	}
+	return Response{}, false
+}
+
+// Returns true if the status code is a documented success code (2xx) of the operation
+// If the operation documents no success code, any status code under 400 is a success
+func successStatus(op *Operation, code int) bool {
+	status := strconv.Itoa(code)
+	documented := false
+	for key := range op.Responses {
+		if !strings.HasPrefix(key, "2") {
+			continue
+		}
+		documented = true
+		if key == status || key == status[:1]+"XX" {
+			return true
+		}
+	}
+	return !documented && code < http.StatusBadRequest
+}
+
+// Returns the documented success codes of the operation for error messages (e.g. 200, 201)
+func documentedStatuses(op *Operation) string {
+	var codes []string
+	for key := range op.Responses {
+		if strings.HasPrefix(key, "2") {
+			codes = append(codes, key)
+		}
+	}
+	if len(codes) == 0 {
+		return fmt.Sprintf("none, so any status code under %d", http.StatusBadRequest)
+	}
+	sort.Strings(codes)
+	return strings.Join(codes, ", ")
+}
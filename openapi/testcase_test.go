@@ -0,0 +1,191 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mercari/testdeck"
+	"github.com/mercari/testdeck/fuzzer"
+	"github.com/mercari/testdeck/intruder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// records the errors of the test cases instead of failing the test
+type recorder struct {
+	testdeck.TestingT
+
+	mu     sync.Mutex
+	errors []string
+}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+// a test server for testdata/petstore.yaml that requires an Authorization header
+// If broken is true, getPet returns a pet without a name and DELETE fails
+func startPetServer(t *testing.T, broken bool) *httptest.Server {
+	writeJSON := func(w http.ResponseWriter, status int, body interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/pets", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, []interface{}{map[string]interface{}{"id": 1, "name": "Rex", "tag": nil}})
+		case http.MethodPost:
+			var pet map[string]interface{}
+			if r.Header.Get("X-Request-Id") == "" || json.NewDecoder(r.Body).Decode(&pet) != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]interface{}{"message": "invalid pet"})
+				return
+			}
+			pet["id"] = 2
+			writeJSON(w, http.StatusCreated, pet)
+		}
+	})
+	mux.HandleFunc("/v1/pets/", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/photo"):
+			if _, _, err := r.FormFile("photo"); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodDelete && broken:
+			w.WriteHeader(http.StatusInternalServerError)
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		case broken:
+			writeJSON(w, http.StatusOK, map[string]interface{}{"id": 1})
+		default:
+			writeJSON(w, http.StatusOK, map[string]interface{}{"id": 1, "name": "Rex"})
+		}
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func Test_Spec_Run_ShouldPassForValidAPI(t *testing.T) {
+	server := startPetServer(t, false)
+	spec := loadPetstore(t)
+
+	spec.Run(t, Options{BaseURL: server.URL + "/v1", Headers: map[string]string{"Authorization": "Bearer token"}})
+}
+
+func Test_TestCases_ShouldReportInvalidResponses(t *testing.T) {
+	// Arrange
+	server := startPetServer(t, true)
+	spec := loadPetstore(t)
+	cases, err := spec.TestCases(Options{BaseURL: server.URL + "/v1", Headers: map[string]string{"Authorization": "Bearer token"}})
+	require.Nil(t, err)
+
+	// Act
+	errors := map[string][]string{}
+	for i := range cases {
+		rec := &recorder{TestingT: t}
+		testdeck.Test(rec, &cases[i].TestCase, testdeck.TestConfig{ParallelOff: true})
+		errors[cases[i].Name] = rec.errors
+	}
+
+	// Assert
+	assert.Empty(t, errors["listPets"])
+	assert.Empty(t, errors["createPet"])
+	assert.Empty(t, errors["uploadPhoto"])
+	require.Len(t, errors["getPet"], 1)
+	assert.Contains(t, errors["getPet"][0], "does not match its schema")
+	require.Len(t, errors["DELETE /pets/{id}"], 1)
+	assert.Contains(t, errors["DELETE /pets/{id}"][0], "unexpected status code 500 (documented: 204)")
+}
+
+func Test_TestCases_ShouldFuzzEveryOperation(t *testing.T) {
+	// Arrange
+	server := startPetServer(t, false)
+	spec := loadPetstore(t)
+
+	// Act
+	cases, err := spec.TestCases(Options{
+		BaseURL: server.URL + "/v1",
+		Headers: map[string]string{"Authorization": "Bearer token"},
+		Fuzz:    &fuzzer.FuzzOptions{Rounds: 5},
+	})
+
+	// Assert
+	require.Nil(t, err)
+	var names []string
+	for _, tc := range cases {
+		names = append(names, tc.Name)
+	}
+	assert.Contains(t, names, "createPet/fuzz")
+	assert.Len(t, names, 10)
+
+	rec := &recorder{TestingT: t}
+	for i := range cases {
+		if cases[i].Name == "createPet/fuzz" {
+			testdeck.Test(rec, &cases[i].TestCase, testdeck.TestConfig{ParallelOff: true})
+		}
+	}
+	assert.Empty(t, rec.errors, "the server should not fail for fuzzed inputs")
+}
+
+func Test_TestCases_ShouldRequireAbsoluteBaseURL(t *testing.T) {
+	// Arrange
+	spec, err := Parse([]byte(`{"openapi": "3.0.0", "servers": [{"url": "/v1"}], "paths": {}}`))
+	require.Nil(t, err)
+
+	// Act
+	_, err = spec.TestCases()
+
+	// Assert
+	assert.NotNil(t, err)
+}
+
+func Test_TestCases_ShouldRejectIntruderOptions(t *testing.T) {
+	// Arrange
+	spec := loadPetstore(t)
+
+	// Act
+	_, err := spec.TestCases(Options{BaseURL: "http://localhost", Intruder: &intruder.InputValidationTestData{}})
+
+	// Assert
+	assert.NotNil(t, err, "the intruder is only run by Run, so TestCases should not ignore it silently")
+}
+
+func Test_TestCases_ShouldFailOperationsWithoutSampleRequest(t *testing.T) {
+	// Arrange
+	spec, err := Parse([]byte(`{
+		"openapi": "3.0.0",
+		"servers": [{"url": "http://localhost"}],
+		"paths": {"/upload": {"post": {"operationId": "upload", "requestBody": {"content": {"text/plain": {"schema": {"type": "string"}}}}}}}
+	}`))
+	require.Nil(t, err)
+	cases, err := spec.TestCases()
+	require.Nil(t, err)
+	require.Len(t, cases, 1)
+	rec := &recorder{TestingT: t}
+
+	// Act
+	testdeck.Test(rec, &cases[0].TestCase, testdeck.TestConfig{ParallelOff: true})
+
+	// Assert
+	require.Len(t, rec.errors, 1)
+	assert.Contains(t, rec.errors[0], "POST /upload: failed to make a sample request")
+}
@@ -0,0 +1,300 @@
+package recorder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mercari/testdeck/constants"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+/*
+recorder.go: Client interceptors that record the gRPC calls made by a test case so that they are saved with its statistics
+*/
+
+// SensitiveMetadata are the metadata keys whose values are not recorded (e.g. tokens)
+var SensitiveMetadata = []string{"authorization", "cookie", "x-api-key"}
+
+// Redacted replaces the values of sensitive metadata
+const Redacted = "REDACTED"
+
+// StatusUnfinished is the status of streams that were not read until the end
+const StatusUnfinished = "Unfinished"
+
+// Recorder stores the gRPC calls made by one test case
+// All methods can be called on a nil Recorder, which records nothing
+type Recorder struct {
+	mu        sync.Mutex
+	records   []constants.RPCRecord
+	streams   map[*recordedStream]bool // streams that have not finished yet
+	lifecycle func() string
+}
+
+// Creates a recorder
+// lifecycle returns the current lifecycle stage of the test case, it can be nil
+func New(lifecycle func() string) *Recorder {
+	return &Recorder{lifecycle: lifecycle, streams: map[*recordedStream]bool{}}
+}
+
+// Adds a record
+func (r *Recorder) Add(record constants.RPCRecord) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, record)
+}
+
+// Returns a copy of the records in the order the calls finished
+// Streams that are still open (e.g. because they were not read until the end) are added at the end with the status StatusUnfinished
+func (r *Recorder) Records() []constants.RPCRecord {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	records := append([]constants.RPCRecord(nil), r.records...)
+	var open []constants.RPCRecord
+	for s := range r.streams {
+		record := s.snapshot()
+		record.Status = StatusUnfinished
+		open = append(open, record)
+	}
+	sort.Slice(open, func(i, j int) bool { return open[i].Start.Before(open[j].Start) })
+	return append(records, open...)
+}
+
+// Tracks a stream until it finishes
+func (r *Recorder) open(s *recordedStream) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.streams[s] = true
+}
+
+// Adds the record of a finished stream
+func (r *Recorder) close(s *recordedStream, record constants.RPCRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.streams, s)
+	r.records = append(r.records, record)
+}
+
+// Returns a new record of a call that starts now
+func (r *Recorder) start(ctx context.Context, method string, cc *grpc.ClientConn, streaming bool) constants.RPCRecord {
+	record := constants.RPCRecord{
+		Method:    method,
+		Streaming: streaming,
+		Start:     time.Now(),
+	}
+	if cc != nil {
+		record.Target = cc.Target()
+	}
+	if r.lifecycle != nil {
+		record.Lifecycle = r.lifecycle()
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		record.Metadata = redact(md)
+	}
+	return record
+}
+
+// ----------
+// context
+// ----------
+
+type contextKey struct{}
+
+// Returns a copy of the context that records the gRPC calls made with it
+func NewContext(ctx context.Context, r *Recorder) context.Context {
+	return context.WithValue(ctx, contextKey{}, r)
+}
+
+// Returns the recorder of the context, or nil if calls made with the context are not recorded
+func FromContext(ctx context.Context) *Recorder {
+	r, _ := ctx.Value(contextKey{}).(*Recorder)
+	return r
+}
+
+// ----------
+// interceptors
+// ----------
+
+// Returns the dial options that install the interceptors
+// grpcutils.Dial and grpcutils.Connect install them already, use this when dialing connections yourself
+func DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(StreamClientInterceptor()),
+	}
+}
+
+// Returns an interceptor that records unary calls made with a context that has a recorder
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		r := FromContext(ctx)
+		if r == nil {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		record := r.start(ctx, method, cc, false)
+		var header, trailer metadata.MD
+		opts = append(opts, grpc.Header(&header), grpc.Trailer(&trailer))
+
+		err := invoker(ctx, method, req, reply, cc, opts...)
+
+		record.Duration = time.Since(record.Start)
+		record.Request = encode(req)
+		if err == nil {
+			record.Response = encode(reply)
+		}
+		setStatus(&record, err)
+		record.Header = redact(header)
+		record.Trailer = redact(trailer)
+		r.Add(record)
+		return err
+	}
+}
+
+// Returns an interceptor that records streaming calls made with a context that has a recorder
+// The call is recorded when the stream finishes
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		r := FromContext(ctx)
+		if r == nil {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+
+		record := r.start(ctx, method, cc, true)
+		s, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			record.Duration = time.Since(record.Start)
+			setStatus(&record, err)
+			r.Add(record)
+			return nil, err
+		}
+
+		rs := &recordedStream{ClientStream: s, recorder: r, record: record, serverStreams: desc.ServerStreams}
+		r.open(rs)
+		return rs, nil
+	}
+}
+
+// A stream that records the messages sent and received
+type recordedStream struct {
+	grpc.ClientStream
+	recorder      *Recorder
+	serverStreams bool
+
+	mu       sync.Mutex
+	record   constants.RPCRecord
+	sent     []string
+	received []string
+	once     sync.Once
+}
+
+func (s *recordedStream) SendMsg(m interface{}) error {
+	s.mu.Lock()
+	s.sent = append(s.sent, encode(m))
+	s.mu.Unlock()
+	return s.ClientStream.SendMsg(m)
+}
+
+func (s *recordedStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		if err == io.EOF {
+			s.finish(nil)
+		} else {
+			s.finish(err)
+		}
+		return err
+	}
+
+	s.mu.Lock()
+	s.received = append(s.received, encode(m))
+	s.mu.Unlock()
+
+	// client-streaming calls receive a single response, after which the stream is finished
+	if !s.serverStreams {
+		s.finish(nil)
+	}
+	return nil
+}
+
+// Adds the record of the stream once
+func (s *recordedStream) finish(err error) {
+	s.once.Do(func() {
+		record := s.snapshot()
+		setStatus(&record, err)
+		if header, herr := s.ClientStream.Header(); herr == nil {
+			record.Header = redact(header)
+		}
+		record.Trailer = redact(s.ClientStream.Trailer())
+		s.recorder.close(s, record)
+	})
+}
+
+// Returns the record of the messages sent and received so far
+func (s *recordedStream) snapshot() constants.RPCRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record := s.record
+	record.Duration = time.Since(record.Start)
+	record.Request = "[" + strings.Join(s.sent, ",") + "]"
+	record.Response = "[" + strings.Join(s.received, ",") + "]"
+	return record
+}
+
+// ----------
+// encoding
+// ----------
+
+// Returns the message in JSON
+func encode(m interface{}) string {
+	if pm, ok := m.(proto.Message); ok {
+		if b, err := protojson.Marshal(pm); err == nil {
+			return string(b)
+		}
+	}
+	if b, err := json.Marshal(m); err == nil {
+		return string(b)
+	}
+	b, _ := json.Marshal(fmt.Sprintf("%+v", m))
+	return string(b)
+}
+
+// Sets the status code and message of the error
+func setStatus(record *constants.RPCRecord, err error) {
+	st := status.Convert(err)
+	record.Status = st.Code().String()
+	record.Error = st.Message()
+}
+
+// Returns a copy of the metadata with the values of sensitive keys replaced
+func redact(md metadata.MD) map[string][]string {
+	if len(md) == 0 {
+		return nil
+	}
+	copied := map[string][]string{}
+	for k, v := range md {
+		copied[k] = append([]string(nil), v...)
+		for _, sensitive := range SensitiveMetadata {
+			if strings.EqualFold(k, sensitive) {
+				copied[k] = []string{Redacted}
+			}
+		}
+	}
+	return copied
+}
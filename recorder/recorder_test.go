@@ -0,0 +1,158 @@
+package recorder
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/mercari/testdeck/constants"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type testServer struct {
+	testpb.UnimplementedTestServiceServer
+}
+
+func (testServer) UnaryCall(ctx context.Context, req *testpb.SimpleRequest) (*testpb.SimpleResponse, error) {
+	grpc.SetHeader(ctx, metadata.Pairs("x-request-id", "req-1"))
+	if req.ResponseSize < 0 {
+		return nil, status.Error(codes.InvalidArgument, "negative size")
+	}
+	return &testpb.SimpleResponse{Username: "a"}, nil
+}
+
+func (testServer) StreamingInputCall(stream testpb.TestService_StreamingInputCallServer) error {
+	var size int32
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&testpb.StreamingInputCallResponse{AggregatedPayloadSize: size})
+		}
+		if err != nil {
+			return err
+		}
+		size += int32(len(req.GetPayload().GetBody()))
+	}
+}
+
+func (testServer) FullDuplexCall(stream testpb.TestService_FullDuplexCallServer) error {
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			return nil
+		}
+		if err := stream.Send(&testpb.StreamingOutputCallResponse{Payload: req.Payload}); err != nil {
+			return err
+		}
+	}
+}
+
+// starts the test service and returns a client whose connection has the recorder interceptors
+func newTestClient(t *testing.T) testpb.TestServiceClient {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	s := grpc.NewServer()
+	testpb.RegisterTestServiceServer(s, testServer{})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial(lis.Addr().String(), append(DialOptions(), grpc.WithInsecure())...)
+	require.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return testpb.NewTestServiceClient(conn)
+}
+
+func Test_UnaryClientInterceptor_ShouldRecordCalls(t *testing.T) {
+	// Arrange
+	client := newTestClient(t)
+	r := New(func() string { return constants.LifecycleAct })
+	ctx := metadata.AppendToOutgoingContext(NewContext(context.Background(), r), "authorization", "bearer secret", "x-flag", "on")
+
+	// Act
+	_, okErr := client.UnaryCall(ctx, &testpb.SimpleRequest{ResponseSize: 1})
+	_, failedErr := client.UnaryCall(ctx, &testpb.SimpleRequest{ResponseSize: -1})
+	_, _ = client.UnaryCall(context.Background(), &testpb.SimpleRequest{}) // not recorded
+
+	// Assert
+	require.Nil(t, okErr)
+	require.NotNil(t, failedErr)
+	records := r.Records()
+	require.Len(t, records, 2)
+
+	ok := records[0]
+	assert.Equal(t, "/grpc.testing.TestService/UnaryCall", ok.Method)
+	assert.Equal(t, constants.LifecycleAct, ok.Lifecycle)
+	assert.JSONEq(t, `{"responseSize": 1}`, ok.Request)
+	assert.JSONEq(t, `{"username": "a"}`, ok.Response)
+	assert.Equal(t, "OK", ok.Status)
+	assert.Equal(t, []string{Redacted}, ok.Metadata["authorization"])
+	assert.Equal(t, []string{"on"}, ok.Metadata["x-flag"])
+	assert.Equal(t, []string{"req-1"}, ok.Header["x-request-id"])
+	assert.False(t, ok.Start.IsZero())
+
+	failed := records[1]
+	assert.Equal(t, "InvalidArgument", failed.Status)
+	assert.Equal(t, "negative size", failed.Error)
+	assert.Empty(t, failed.Response)
+}
+
+func Test_StreamClientInterceptor_ShouldRecordMessages(t *testing.T) {
+	// Arrange
+	client := newTestClient(t)
+	r := New(nil)
+	ctx := NewContext(context.Background(), r)
+
+	// Act
+	upload, err := client.StreamingInputCall(ctx)
+	require.Nil(t, err)
+	upload.Send(&testpb.StreamingInputCallRequest{Payload: &testpb.Payload{Body: []byte("a")}})
+	upload.Send(&testpb.StreamingInputCallRequest{Payload: &testpb.Payload{Body: []byte("bc")}})
+	_, err = upload.CloseAndRecv()
+	require.Nil(t, err)
+
+	chat, err := client.FullDuplexCall(ctx)
+	require.Nil(t, err)
+	chat.Send(&testpb.StreamingOutputCallRequest{Payload: &testpb.Payload{Body: []byte("a")}})
+	_, err = chat.Recv()
+	require.Nil(t, err)
+
+	// Assert
+	records := r.Records()
+	require.Len(t, records, 2)
+
+	assert.True(t, records[0].Streaming)
+	assert.JSONEq(t, `[{"payload": {"body": "YQ=="}}, {"payload": {"body": "YmM="}}]`, records[0].Request)
+	assert.JSONEq(t, `[{"aggregatedPayloadSize": 3}]`, records[0].Response)
+	assert.Equal(t, "OK", records[0].Status)
+
+	// the bidirectional stream is still open
+	assert.Equal(t, "/grpc.testing.TestService/FullDuplexCall", records[1].Method)
+	assert.Equal(t, StatusUnfinished, records[1].Status)
+	assert.JSONEq(t, `[{"payload": {"body": "YQ=="}}]`, records[1].Response)
+
+	// Act
+	chat.CloseSend()
+	_, err = chat.Recv()
+
+	// Assert
+	assert.Equal(t, io.EOF, err)
+	records = r.Records()
+	require.Len(t, records, 2)
+	assert.Equal(t, "OK", records[1].Status)
+}
+
+func Test_Recorder_ShouldIgnoreNil(t *testing.T) {
+	var r *Recorder
+
+	r.Add(constants.RPCRecord{Method: "a"})
+
+	assert.Nil(t, r.Records())
+	assert.Nil(t, FromContext(context.Background()))
+}
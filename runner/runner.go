@@ -60,6 +60,8 @@ type runner struct {
 	eventLogger  EventLogger
 	matchRe      *regexp.Regexp
 	matchPattern string
+	deferredMu   sync.Mutex
+	deferred     []func()
 }
 
 // Interface for the custom test runner (contains Golang's Run() and some other custom methods that we need for recording statistics, etc.)
@@ -76,6 +78,8 @@ type Runner interface {
 	ReportStatistics()
 	Passed() bool
 	Output() string
+	Defer(f func())
+	RunDeferred()
 }
 
 // This is a custom version of Golang testing's type M (a test runner struct)
@@ -240,6 +244,29 @@ func (r *runner) LogEvent(message string) {
 	}
 }
 
+// -----
+// CLEANUP
+// -----
+
+// Defer registers a function to run when the test run finishes (e.g. closing connections shared by tests)
+func (r *runner) Defer(f func()) {
+	r.deferredMu.Lock()
+	defer r.deferredMu.Unlock()
+	r.deferred = append(r.deferred, f)
+}
+
+// RunDeferred runs the registered functions in reverse order, like defer, and removes them
+func (r *runner) RunDeferred() {
+	r.deferredMu.Lock()
+	deferred := r.deferred
+	r.deferred = nil
+	r.deferredMu.Unlock()
+
+	for i := len(deferred) - 1; i >= 0; i-- {
+		deferred[i]()
+	}
+}
+
 // -----
 // TEST NAME MATCHING
 // FIXME: This feature is not working now, tests cannot be run individually by name
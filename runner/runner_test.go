@@ -25,6 +25,21 @@ func Test_Runner_ShouldAddStatistics(t *testing.T) {
 	assert.Equal(t, stats, got[0])
 }
 
+func Test_Runner_ShouldRunDeferredInReverseOrder(t *testing.T) {
+	// Arrange
+	r := newInstance(&badM{})
+	var order []int
+	r.Defer(func() { order = append(order, 1) })
+	r.Defer(func() { order = append(order, 2) })
+
+	// Act
+	r.RunDeferred()
+	r.RunDeferred() // functions only run once
+
+	// Assert
+	assert.Equal(t, []int{2, 1}, order)
+}
+
 // This test consumes the "singleton" behavior of the file. Because of this
 // behavior, we have to move some assertions ahead to guarantee we valid state
 // before initialization.
@@ -5,6 +5,7 @@ import (
 	"github.com/kelseyhightower/envconfig"
 	"github.com/pkg/errors"
 	"os"
+	"time"
 )
 
 /*
@@ -48,6 +49,42 @@ type Env struct {
 
 	// The URL of the DB to save test results to. If not declared, tests will still run but results can only be viewed through Kubernetes pod logs
 	DbUrl string `envconfig:"DB_URL"`
+
+	// The address of the gRPC service to test (e.g. echo.default.svc.cluster.local:8080). Used by grpcutils.Connect
+	GrpcTarget string `envconfig:"GRPC_TARGET"`
+
+	// Whether to connect to the gRPC service without TLS
+	GrpcInsecure bool `envconfig:"GRPC_INSECURE" default:"false"`
+
+	// Path of a PEM file with the CA certificates of the gRPC service. If not declared, the system certificates are used
+	GrpcCACert string `envconfig:"GRPC_CA_CERT"`
+
+	// The server name to verify the certificate of the gRPC service against, if it is different from the host of the target
+	GrpcServerName string `envconfig:"GRPC_SERVER_NAME"`
+
+	// A bearer token sent with every RPC in the authorization metadata
+	GrpcToken string `envconfig:"GRPC_TOKEN"`
+
+	// The default timeout of each RPC, used when the context has no deadline (e.g. 10s). If not declared, RPCs have no timeout
+	GrpcTimeout time.Duration `envconfig:"GRPC_TIMEOUT"`
+
+	// How often to ping the gRPC service to keep connections alive (e.g. 30s). If not declared, keepalive is disabled
+	GrpcKeepaliveTime time.Duration `envconfig:"GRPC_KEEPALIVE_TIME"`
+
+	// How long to wait for a keepalive ping to be acknowledged before closing the connection
+	GrpcKeepaliveTimeout time.Duration `envconfig:"GRPC_KEEPALIVE_TIMEOUT"`
+
+	// URL of an HTTP CONNECT proxy to send gRPC connections through, e.g. a debugging proxy such as Burp Suite (http://localhost:8080)
+	GrpcProxy string `envconfig:"GRPC_PROXY"`
+
+	// Path of a PEM file with the CA certificate of the proxy, trusted so that the proxy can intercept TLS connections
+	GrpcProxyCACert string `envconfig:"GRPC_PROXY_CA_CERT"`
+
+	// Record the gRPC and HTTP exchanges to a cassette file or replay them from it ("record" or "replay", see cassette.StartFromEnv)
+	CassetteMode string `envconfig:"CASSETTE_MODE"`
+
+	// The cassette file to record to or replay from
+	CassetteFile string `envconfig:"CASSETTE_FILE" default:"testdata/cassette.json"`
 }
 
 func (e *Env) validate() error {
@@ -60,6 +97,21 @@ func (e *Env) validate() error {
 			fmt.Sprintf("invalid env is specified: %q", e.Env),
 		},
 
+		{
+			e.GrpcInsecure && e.GrpcCACert != "",
+			"GRPC_INSECURE cannot be used together with GRPC_CA_CERT",
+		},
+
+		{
+			e.GrpcInsecure && e.GrpcProxyCACert != "",
+			"GRPC_INSECURE cannot be used together with GRPC_PROXY_CA_CERT",
+		},
+
+		{
+			e.CassetteMode != "" && e.CassetteMode != "record" && e.CassetteMode != "replay",
+			fmt.Sprintf("invalid cassette mode is specified: %q", e.CassetteMode),
+		},
+
 		// Add your own validation here
 	}
 
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 )
 
 const (
@@ -31,6 +32,29 @@ func TestReadFromEnv(t *testing.T) {
 	}
 }
 
+func TestReadFromEnvGrpc(t *testing.T) {
+	reset := setenvs(t, map[string]string{
+		"ENV":           envDevelopment,
+		"GRPC_TARGET":   "localhost:8080",
+		"GRPC_INSECURE": "true",
+		"GRPC_TIMEOUT":  "5s",
+	})
+	defer reset()
+
+	env, err := ReadFromEnv()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if got, want := env.GrpcTarget, "localhost:8080"; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	if got, want := env.GrpcTimeout, 5*time.Second; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
 func TestReadFromEnvValidationFailed(t *testing.T) {
 	reset := setenvs(t, map[string]string{
 		"ENV":            "prod",
@@ -62,6 +86,41 @@ func TestValidate(t *testing.T) {
 			},
 			false,
 		},
+
+		"InsecureWithCACert": {
+			&Env{
+				Env:          envDevelopment,
+				GrpcInsecure: true,
+				GrpcCACert:   "ca.pem",
+			},
+			false,
+		},
+
+		"InsecureWithProxyCACert": {
+			&Env{
+				Env:             envDevelopment,
+				GrpcInsecure:    true,
+				GrpcProxy:       "http://localhost:8080",
+				GrpcProxyCACert: "proxy-ca.pem",
+			},
+			false,
+		},
+
+		"ReplayCassette": {
+			&Env{
+				Env:          envDevelopment,
+				CassetteMode: "replay",
+			},
+			true,
+		},
+
+		"InvalidCassetteMode": {
+			&Env{
+				Env:          envDevelopment,
+				CassetteMode: "rewind",
+			},
+			false,
+		},
 	}
 
 	for name, tc := range cases {
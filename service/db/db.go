@@ -45,6 +45,8 @@ const (
 	Timing = "timing"
 	// Status is the `status` Endpoint map key
 	Status = "status"
+	// RPC is the `rpc` Endpoint map key
+	RPC = "rpc"
 )
 
 // Examples of endpoints for accessing the test results DB
@@ -54,6 +56,7 @@ var Endpoints = map[string]string{
 	Statistic: "/result",
 	Timing:    "/timing",
 	Status:    "/status",
+	RPC:       "/rpc",
 }
 
 type ServerResponse struct {
@@ -237,6 +240,12 @@ func (g *Db) saveStatistics(jobID int, stat constants.Statistics) (resultID int,
 			return 0, err
 		}
 	}
+	for _, r := range stat.RPCs {
+		err = g.saveRPCRow(r, resultID)
+		if err != nil {
+			return 0, err
+		}
+	}
 	return resultID, err
 }
 
@@ -303,6 +312,50 @@ func (g *Db) saveStatusRow(s constants.Status, resultID int) error {
 	return insertRestOperation(composeEndpoint(Status), newStatusFrom(s, resultID))
 }
 
+// -----
+// Saving recorded gRPC calls
+// -----
+
+type rpc struct {
+	ResultsID int                 `json:"results_id"`
+	Method    string              `json:"method"`
+	Target    string              `json:"target"`
+	Lifecycle string              `json:"lifecycle_value"`
+	Streaming bool                `json:"streaming"`
+	Request   string              `json:"request_text"`
+	Response  string              `json:"response_text"`
+	Status    string              `json:"status_value"`
+	Error     string              `json:"error_text"`
+	Metadata  map[string][]string `json:"metadata"`
+	Header    map[string][]string `json:"header"`
+	Trailer   map[string][]string `json:"trailer"`
+	Start     MySQLTime           `json:"start_ts"`
+	Duration  time.Duration       `json:"duration_ns"`
+}
+
+func newRPCFrom(r constants.RPCRecord, resultID int) *rpc {
+	return &rpc{
+		ResultsID: resultID,
+		Method:    r.Method,
+		Target:    r.Target,
+		Lifecycle: r.Lifecycle,
+		Streaming: r.Streaming,
+		Request:   r.Request,
+		Response:  r.Response,
+		Status:    r.Status,
+		Error:     r.Error,
+		Metadata:  r.Metadata,
+		Header:    r.Header,
+		Trailer:   r.Trailer,
+		Start:     MySQLTime{r.Start},
+		Duration:  r.Duration,
+	}
+}
+
+func (g *Db) saveRPCRow(r constants.RPCRecord, resultID int) error {
+	return insertRestOperation(composeEndpoint(RPC), newRPCFrom(r, resultID))
+}
+
 // -----
 // Methods
 // -----
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"encoding/json"
 	"os"
 	"regexp"
 	"strconv"
@@ -189,3 +190,27 @@ func Test_Save_ShouldSaveToActualDb(t *testing.T) {
 		t.Fatalf("Wanted len IDs: %d, got: %d", want, got)
 	}
 }
+
+func Test_NewRPCFrom_ShouldMarshalRecord(t *testing.T) {
+	start := time.Date(2021, time.January, 2, 3, 4, 5, 0, time.UTC)
+	r := constants.RPCRecord{
+		Method:    "/echo.EchoService/Say",
+		Lifecycle: constants.LifecycleAct,
+		Request:   `{"messageId":"1"}`,
+		Status:    "InvalidArgument",
+		Header:    map[string][]string{"x-request-id": {"a"}},
+		Start:     start,
+		Duration:  time.Millisecond,
+	}
+
+	bs, err := json.Marshal(newRPCFrom(r, 3))
+
+	if err != nil {
+		t.Fatalf("Expected nil error, got: %v", err)
+	}
+	for _, want := range []string{`"results_id":3`, `"method":"/echo.EchoService/Say"`, `"lifecycle_value":"Act"`, `"request_text":"{\"messageId\":\"1\"}"`, `"status_value":"InvalidArgument"`, `"header":{"x-request-id":["a"]}`, `"start_ts":"2021-01-02 03:04:05"`, `"duration_ns":1000000`} {
+		if got := string(bs); !strings.Contains(got, want) {
+			t.Errorf("want substring: '%s', string contents: '%s'", want, got)
+		}
+	}
+}
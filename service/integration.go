@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/mercari/testdeck/cassette"
 	"github.com/mercari/testdeck/runner"
 	"github.com/mercari/testdeck/service/config"
 	"github.com/mercari/testdeck/service/controller"
@@ -63,6 +64,15 @@ func NewService(m *testing.M) Service {
 // Start the testing service
 func (s *ServiceImpl) Start(opt ...ServiceOptions) int {
 	s.controller.Runner().PrintOutputToEventLog(Env.PrintOutputToEventLog)
+
+	// clean up shared resources (e.g. pooled gRPC connections) when the test run finishes
+	defer s.controller.Runner().RunDeferred()
+
+	// record or replay the gRPC and HTTP exchanges of the test run if CASSETTE_MODE is set
+	if _, err := cassette.StartFromEnv(Env); err != nil {
+		fmt.Println(errors.Wrap(err, "Could not start the cassette"))
+		return 1
+	}
 	
 	// Only start tests if run as a test job
 	switch runAs := config.RunAs(Env); runAs {